@@ -0,0 +1,161 @@
+// Package audit appends one JSONL line per request lifecycle event to a rotating file.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/plar/movie-service/logging"
+)
+
+const (
+	EventReceived  = "received"  // request is decoded, it is accepted or rejected
+	EventQueued    = "queued"    // job waits for a free worker
+	EventUpstream  = "upstream"  // provider call is finished
+	EventPublished = "published" // response publish is finished
+
+	OutcomeAccepted = "accepted"
+	OutcomeRejected = "rejected"
+	OutcomeSuccess  = "success"
+	OutcomeError    = "error"
+)
+
+// Event is one line of the audit log, fields which do not belong to the event are omitted.
+type Event struct {
+	Time      time.Time   `json:"time"`
+	Event     string      `json:"event"`
+	RequestId string      `json:"request_id,omitempty"`
+	Client    string      `json:"client,omitempty"`
+	Query     string      `json:"query,omitempty"`
+	Request   interface{} `json:"request,omitempty"`
+
+	Provider  string  `json:"provider,omitempty"`
+	LatencyMs float64 `json:"latency_ms,omitempty"`
+	Results   *int    `json:"results,omitempty"`
+
+	Exchange   string `json:"exchange,omitempty"`
+	RoutingKey string `json:"routing_key,omitempty"`
+
+	Outcome string `json:"outcome,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Latency sets latency of the event in milliseconds.
+func (self *Event) Latency(d time.Duration) {
+	self.LatencyMs = float64(d) / float64(time.Millisecond)
+}
+
+// Logger writes audit events, write errors are logged and do not fail requests.
+type Logger interface {
+	Log(event Event)
+	Close() error
+}
+
+// Options of the audit log, the log is disabled when FileName is empty.
+type Options struct {
+	FileName   string
+	MaxSize    int64 // rotate the file when it grows over the size in bytes, 0 - never
+	MaxBackups int   // rotated files to keep, file.1 is the newest one
+	Redaction  RedactionPolicy
+}
+
+func (self Options) Validate() error {
+	if self.MaxSize < 0 {
+		return fmt.Errorf("Audit file max size cannot be negative, got %d", self.MaxSize)
+	}
+	if self.MaxBackups < 0 {
+		return fmt.Errorf("Audit file max backups cannot be negative, got %d", self.MaxBackups)
+	}
+	return self.Redaction.Validate()
+}
+
+type logger struct {
+	sync.Mutex
+	w      io.WriteCloser
+	policy RedactionPolicy
+	now    func() time.Time
+}
+
+func (self *logger) Log(event Event) {
+	if event.Time.IsZero() {
+		event.Time = self.now()
+	}
+
+	line, err := self.encode(event)
+	if err != nil {
+		log.Errorf("Cannot encode audit event, event=%s, request_id=%s, error=%s", event.Event, event.RequestId, err)
+		return
+	}
+
+	self.Lock()
+	defer self.Unlock()
+	if _, err := self.w.Write(line); err != nil {
+		log.Errorf("Cannot write audit event, event=%s, request_id=%s, error=%s", event.Event, event.RequestId, err)
+	}
+}
+
+// encode returns the redacted JSON line of the event.
+func (self *logger) encode(event Event) ([]byte, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(self.policy.Fields) > 0 {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, err
+		}
+		self.policy.Apply(fields)
+		if data, err = json.Marshal(fields); err != nil {
+			return nil, err
+		}
+	}
+
+	return append(data, '\n'), nil
+}
+
+func (self *logger) Close() error {
+	self.Lock()
+	defer self.Unlock()
+	return self.w.Close()
+}
+
+type nopLogger struct {
+}
+
+func (self nopLogger) Log(event Event) {
+}
+
+func (self nopLogger) Close() error {
+	return nil
+}
+
+// NewLogger writes events to w with the redaction policy applied.
+func NewLogger(w io.WriteCloser, policy RedactionPolicy) Logger {
+	return &logger{w: w, policy: policy, now: time.Now}
+}
+
+// NewNopLogger drops all events.
+func NewNopLogger() Logger {
+	return nopLogger{}
+}
+
+// Open opens the audit file of the options, disabled log drops all events.
+func Open(options Options) (Logger, error) {
+	if len(options.FileName) == 0 {
+		return NewNopLogger(), nil
+	}
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	file, err := openRotatingFile(options.FileName, options.MaxSize, options.MaxBackups)
+	if err != nil {
+		return nil, err
+	}
+	return NewLogger(file, options.Redaction), nil
+}
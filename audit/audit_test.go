@@ -0,0 +1,109 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (self *bufferCloser) Close() error {
+	self.closed = true
+	return nil
+}
+
+type failingWriter struct {
+}
+
+func (self failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk is full")
+}
+
+func (self failingWriter) Close() error {
+	return nil
+}
+
+func TestLog(t *testing.T) {
+	var out bufferCloser
+	l := NewLogger(&out, RedactionPolicy{})
+	l.(*logger).now = func() time.Time { return time.Date(2015, 10, 2, 12, 0, 0, 0, time.UTC) }
+
+	results := 21
+	event := Event{Event: EventUpstream, RequestId: "r1", Provider: "rottentomatoes", Results: &results, Outcome: OutcomeSuccess}
+	event.Latency(1500 * time.Microsecond)
+	l.Log(event)
+	l.Log(Event{Event: EventQueued, RequestId: "r1"})
+
+	assert.Equal(t, `{"time":"2015-10-02T12:00:00Z","event":"upstream","request_id":"r1","provider":"rottentomatoes","latency_ms":1.5,"results":21,"outcome":"success"}
+{"time":"2015-10-02T12:00:00Z","event":"queued","request_id":"r1"}
+`, out.String())
+
+	assert.NoError(t, l.Close())
+	assert.True(t, out.closed)
+}
+
+func TestLogRedacted(t *testing.T) {
+	var out bufferCloser
+	logger := NewLogger(&out, RedactionPolicy{Fields: []string{"query", "request.headers.*"}, Mode: RedactMask})
+
+	logger.Log(Event{
+		Event:   EventReceived,
+		Query:   "martian",
+		Request: map[string]interface{}{"request_id": "r1", "headers": map[string]string{"token": "secret"}},
+	})
+
+	var line map[string]interface{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &line))
+	assert.Equal(t, "REDACTED", line["query"])
+	assert.Equal(t, map[string]interface{}{"request_id": "r1", "headers": map[string]interface{}{"token": "REDACTED"}}, line["request"])
+	assert.False(t, strings.Contains(out.String(), "secret"))
+}
+
+func TestLogWriteError(t *testing.T) {
+	logger := NewLogger(failingWriter{}, RedactionPolicy{})
+	logger.Log(Event{Event: EventQueued})
+	assert.NoError(t, logger.Close())
+}
+
+func TestOpen(t *testing.T) {
+	logger, err := Open(Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, NewNopLogger(), logger)
+
+	_, err = Open(Options{FileName: "audit.jsonl", Redaction: RedactionPolicy{Fields: []string{"query"}, Mode: "encrypt"}})
+	assert.EqualError(t, err, "Unknown redaction mode 'encrypt'")
+
+	_, err = Open(Options{FileName: "/not-existing-dir/audit.jsonl"})
+	assert.Error(t, err)
+
+	dir, _ := ioutil.TempDir("", "movie-service-audit")
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "audit.jsonl")
+
+	logger, err = Open(Options{FileName: fileName})
+	assert.NoError(t, err)
+	logger.Log(Event{Event: EventQueued, RequestId: "r1"})
+	assert.NoError(t, logger.Close())
+
+	data, _ := ioutil.ReadFile(fileName)
+	assert.True(t, strings.HasSuffix(string(data), `"event":"queued","request_id":"r1"}`+"\n"))
+}
+
+func TestOptionsValidate(t *testing.T) {
+	assert.NoError(t, Options{FileName: "audit.jsonl", MaxSize: 1024, MaxBackups: 3}.Validate())
+	assert.EqualError(t, Options{MaxSize: -1}.Validate(), "Audit file max size cannot be negative, got -1")
+	assert.EqualError(t, Options{MaxBackups: -1}.Validate(), "Audit file max backups cannot be negative, got -1")
+	assert.EqualError(t, Options{Redaction: RedactionPolicy{Fields: []string{"query"}}}.Validate(), "Redaction mode is required")
+}
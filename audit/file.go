@@ -0,0 +1,113 @@
+package audit
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	log "github.com/plar/movie-service/logging"
+)
+
+// rotatingFile renames the file to file.1 when it grows over the max size, older files are shifted up to file.<max backups>.
+type rotatingFile struct {
+	sync.Mutex
+	fileName   string
+	maxSize    int64
+	maxBackups int
+	file       *os.File // nil when the file could not be reopened, the next write tries again
+	size       int64
+	closed     bool
+}
+
+func (self *rotatingFile) Write(p []byte) (int, error) {
+	self.Lock()
+	defer self.Unlock()
+
+	if self.closed {
+		return 0, os.ErrClosed
+	}
+	if self.file == nil {
+		if err := self.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if self.maxSize > 0 && self.size > 0 && self.size+int64(len(p)) > self.maxSize {
+		if err := self.rotate(); err != nil {
+			if self.file == nil {
+				return 0, err
+			}
+			// the event is not lost, it goes to the reopened file
+			log.Errorf("Cannot rotate audit file %s, error=%s", self.fileName, err)
+		}
+	}
+
+	n, err := self.file.Write(p)
+	self.size += int64(n)
+	return n, err
+}
+
+// rotate reopens the file name even when the file cannot be moved away, the log keeps growing
+// and the rotation is tried again on the next write.
+func (self *rotatingFile) rotate() error {
+	err := self.file.Close()
+	self.file = nil
+	if err != nil {
+		return err
+	}
+
+	if self.maxBackups > 0 {
+		os.Remove(backupName(self.fileName, self.maxBackups))
+		for i := self.maxBackups - 1; i >= 1; i-- {
+			os.Rename(backupName(self.fileName, i), backupName(self.fileName, i+1))
+		}
+		err = os.Rename(self.fileName, backupName(self.fileName, 1))
+	} else {
+		err = os.Remove(self.fileName)
+	}
+
+	if openErr := self.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+func (self *rotatingFile) open() error {
+	file, err := os.OpenFile(self.fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("Cannot open audit file %s: %s", self.fileName, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("Cannot open audit file %s: %s", self.fileName, err)
+	}
+
+	self.file, self.size = file, info.Size()
+	return nil
+}
+
+func (self *rotatingFile) Close() error {
+	self.Lock()
+	defer self.Unlock()
+	self.closed = true
+	if self.file == nil {
+		return nil
+	}
+	err := self.file.Close()
+	self.file = nil
+	return err
+}
+
+func backupName(fileName string, n int) string {
+	return fmt.Sprintf("%s.%d", fileName, n)
+}
+
+func openRotatingFile(fileName string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	file := &rotatingFile{fileName: fileName, maxSize: maxSize, maxBackups: maxBackups}
+	if err := file.open(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
@@ -0,0 +1,117 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tempAuditFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "movie-service-audit")
+	assert.NoError(t, err)
+	return filepath.Join(dir, "audit.jsonl"), func() { os.RemoveAll(dir) }
+}
+
+func readFile(fileName string) string {
+	data, _ := ioutil.ReadFile(fileName)
+	return string(data)
+}
+
+func TestRotatingFile(t *testing.T) {
+	fileName, cleanup := tempAuditFile(t)
+	defer cleanup()
+
+	file, err := openRotatingFile(fileName, 10, 2)
+	assert.NoError(t, err)
+
+	for _, line := range []string{"line-1\n", "line-2\n", "line-3\n", "line-4\n"} {
+		_, err := file.Write([]byte(line))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, file.Close())
+
+	assert.Equal(t, "line-4\n", readFile(fileName))
+	assert.Equal(t, "line-3\n", readFile(fileName+".1"))
+	assert.Equal(t, "line-2\n", readFile(fileName+".2"))
+	_, err = os.Stat(fileName + ".3")
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = file.Write([]byte("closed\n"))
+	assert.Equal(t, os.ErrClosed, err)
+}
+
+func TestRotatingFileAppends(t *testing.T) {
+	fileName, cleanup := tempAuditFile(t)
+	defer cleanup()
+
+	ioutil.WriteFile(fileName, []byte("line-1\n"), 0600)
+	file, err := openRotatingFile(fileName, 0, 0)
+	assert.NoError(t, err)
+	file.Write([]byte("line-2\n"))
+	file.Close()
+
+	assert.Equal(t, "line-1\nline-2\n", readFile(fileName))
+}
+
+func TestRotatingFileWithoutBackups(t *testing.T) {
+	fileName, cleanup := tempAuditFile(t)
+	defer cleanup()
+
+	file, err := openRotatingFile(fileName, 10, 0)
+	assert.NoError(t, err)
+	file.Write([]byte("line-1\n"))
+	file.Write([]byte("line-2\n"))
+	file.Close()
+
+	assert.Equal(t, "line-2\n", readFile(fileName))
+	_, err = os.Stat(fileName + ".1")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRotatingFileRenameFails(t *testing.T) {
+	fileName, cleanup := tempAuditFile(t)
+	defer cleanup()
+
+	// file.1 is a non-empty directory, the file cannot be renamed to it
+	assert.NoError(t, os.MkdirAll(filepath.Join(fileName+".1", "keep"), 0700))
+
+	file, err := openRotatingFile(fileName, 10, 1)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	// the original file is reopened and the log goes on
+	for _, line := range []string{"line-1\n", "line-2\n", "line-3\n"} {
+		_, err := file.Write([]byte(line))
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, "line-1\nline-2\nline-3\n", readFile(fileName))
+
+	// the rotation succeeds once the backup can be written
+	assert.NoError(t, os.RemoveAll(fileName+".1"))
+	_, err = file.Write([]byte("line-4\n"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, "line-4\n", readFile(fileName))
+	assert.Equal(t, "line-1\nline-2\nline-3\n", readFile(fileName+".1"))
+}
+
+func TestRotatingFileReopens(t *testing.T) {
+	fileName, cleanup := tempAuditFile(t)
+	defer cleanup()
+
+	file, err := openRotatingFile(fileName, 0, 0)
+	assert.NoError(t, err)
+	file.Write([]byte("line-1\n"))
+
+	// the file could not be reopened by the last rotation
+	file.file.Close()
+	file.file = nil
+
+	_, err = file.Write([]byte("line-2\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+	assert.Equal(t, "line-1\nline-2\n", readFile(fileName))
+}
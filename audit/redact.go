@@ -0,0 +1,83 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	RedactMask = "mask" // replace the value with REDACTED
+	RedactHash = "hash" // replace the value with its SHA-256, equal values can still be counted
+	RedactDrop = "drop" // remove the field
+
+	redacted = "REDACTED"
+)
+
+// RedactionPolicy hides fields of the audit events. Fields are dotted paths of the JSON line,
+// "*" matches any key, e.g. "query", "client" or "request.headers.*".
+type RedactionPolicy struct {
+	Fields []string
+	Mode   string
+}
+
+func (self RedactionPolicy) Validate() error {
+	switch self.Mode {
+	case RedactMask, RedactHash, RedactDrop:
+	case "":
+		if len(self.Fields) > 0 {
+			return fmt.Errorf("Redaction mode is required")
+		}
+	default:
+		return fmt.Errorf("Unknown redaction mode '%s'", self.Mode)
+	}
+
+	for _, field := range self.Fields {
+		for _, part := range strings.Split(field, ".") {
+			if len(part) == 0 {
+				return fmt.Errorf("Invalid redacted field '%s'", field)
+			}
+		}
+	}
+	return nil
+}
+
+// Apply redacts the fields of the decoded JSON object.
+func (self RedactionPolicy) Apply(fields map[string]interface{}) {
+	for _, field := range self.Fields {
+		self.apply(fields, strings.Split(field, "."))
+	}
+}
+
+func (self RedactionPolicy) apply(fields map[string]interface{}, path []string) {
+	for key, value := range fields {
+		if path[0] != "*" && path[0] != key {
+			continue
+		}
+
+		if len(path) > 1 {
+			if nested, ok := value.(map[string]interface{}); ok {
+				self.apply(nested, path[1:])
+			}
+			continue
+		}
+
+		switch self.Mode {
+		case RedactDrop:
+			delete(fields, key)
+		case RedactHash:
+			fields[key] = hash(value)
+		default:
+			fields[key] = redacted
+		}
+	}
+}
+
+// hash returns the short SHA-256 of the JSON value.
+func hash(value interface{}) string {
+	data, _ := json.Marshal(value)
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
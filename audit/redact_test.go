@@ -0,0 +1,57 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testFields() map[string]interface{} {
+	return map[string]interface{}{
+		"query":  "martian",
+		"client": "billing",
+		"request": map[string]interface{}{
+			"request_id": "r1",
+			"headers":    map[string]interface{}{"token": "secret", "env": "devel"},
+		},
+	}
+}
+
+func TestRedactMask(t *testing.T) {
+	fields := testFields()
+	RedactionPolicy{Fields: []string{"query", "request.headers.token", "missing.field"}, Mode: RedactMask}.Apply(fields)
+	assert.Equal(t, map[string]interface{}{
+		"query":  "REDACTED",
+		"client": "billing",
+		"request": map[string]interface{}{
+			"request_id": "r1",
+			"headers":    map[string]interface{}{"token": "REDACTED", "env": "devel"},
+		},
+	}, fields)
+}
+
+func TestRedactDrop(t *testing.T) {
+	fields := testFields()
+	RedactionPolicy{Fields: []string{"client", "*.headers"}, Mode: RedactDrop}.Apply(fields)
+	assert.Equal(t, map[string]interface{}{
+		"query":   "martian",
+		"request": map[string]interface{}{"request_id": "r1"},
+	}, fields)
+}
+
+func TestRedactHash(t *testing.T) {
+	fields, other := testFields(), testFields()
+	policy := RedactionPolicy{Fields: []string{"query"}, Mode: RedactHash}
+	policy.Apply(fields)
+	policy.Apply(other)
+
+	assert.Regexp(t, `^sha256:[0-9a-f]{16}$`, fields["query"])
+	assert.Equal(t, fields["query"], other["query"])
+}
+
+func TestRedactionPolicyValidate(t *testing.T) {
+	assert.NoError(t, RedactionPolicy{}.Validate())
+	assert.NoError(t, RedactionPolicy{Fields: []string{"request.headers.*"}, Mode: RedactHash}.Validate())
+	assert.EqualError(t, RedactionPolicy{Fields: []string{"query"}}.Validate(), "Redaction mode is required")
+	assert.EqualError(t, RedactionPolicy{Fields: []string{"request..headers"}, Mode: RedactMask}.Validate(), "Invalid redacted field 'request..headers'")
+}
@@ -0,0 +1,266 @@
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/plar/movie-service/normalize"
+)
+
+var (
+	moviesBucket = []byte("movies")
+	indexBucket  = []byte("index")
+	termsBucket  = []byte("terms") // term -> number of movies, the dictionary of the suggestions
+
+	ErrNotFound = errors.New("catalog movie not found")
+)
+
+// Weights of the terms found in the indexed fields, a term found in several fields gets their sum.
+const (
+	titleWeight    = 4
+	castWeight     = 2
+	synopsisWeight = 1
+)
+
+// Document is a movie stored in the catalog, Data is returned by Get and Search as is.
+type Document struct {
+	Id       string
+	Title    string
+	Cast     []string
+	Synopsis string
+	Data     []byte
+}
+
+// Match is a movie found by Search.
+type Match struct {
+	Id    string
+	Score int
+	Data  []byte
+}
+
+// Catalog keeps the movies on disk with the full-text index over title, cast and synopsis.
+type Catalog interface {
+	// Put stores the documents, the ones stored before are replaced together with their index.
+	Put(docs []Document) error
+
+	// Get returns the data of the stored movie.
+	Get(id string) ([]byte, error)
+
+	// Search returns the movies which contain all terms of the query, the best matches first, limit 0 - all.
+	Search(query string, limit int) ([]Match, error)
+
+	// Suggest returns up to limit spelling corrections of the query made of the catalog terms, the closest first.
+	Suggest(query string, limit int) ([]string, error)
+
+	Close() error
+}
+
+type record struct {
+	Updated time.Time       `json:"updated"`
+	Title   string          `json:"title"`
+	Terms   map[string]int  `json:"terms"` // term -> weight, kept to drop the index on replace
+	Data    json.RawMessage `json:"data"`
+}
+
+type boltCatalog struct {
+	db  *bolt.DB
+	now func() time.Time
+}
+
+func (self *boltCatalog) Put(docs []Document) error {
+	return self.db.Update(func(tx *bolt.Tx) error {
+		movies, index, dictionary := tx.Bucket(moviesBucket), tx.Bucket(indexBucket), tx.Bucket(termsBucket)
+		for _, doc := range docs {
+			if len(doc.Id) == 0 {
+				return errors.New("Catalog movie id cannot be empty")
+			}
+
+			if value := movies.Get([]byte(doc.Id)); value != nil {
+				var old record
+				if err := json.Unmarshal(value, &old); err != nil {
+					return fmt.Errorf("Cannot decode catalog movie %s: %s", doc.Id, err)
+				}
+				for term := range old.Terms {
+					if err := index.Delete(posting(term, doc.Id)); err != nil {
+						return err
+					}
+					if err := count(dictionary, term, -1); err != nil {
+						return err
+					}
+				}
+			}
+
+			terms := documentTerms(doc)
+			value, err := json.Marshal(record{Updated: self.now(), Title: doc.Title, Terms: terms, Data: doc.Data})
+			if err != nil {
+				return err
+			}
+			if err := movies.Put([]byte(doc.Id), value); err != nil {
+				return err
+			}
+			for term, weight := range terms {
+				if err := index.Put(posting(term, doc.Id), []byte{byte(weight)}); err != nil {
+					return err
+				}
+				if err := count(dictionary, term, 1); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (self *boltCatalog) Get(id string) ([]byte, error) {
+	var data []byte
+	err := self.db.View(func(tx *bolt.Tx) error {
+		rec, err := load(tx, id)
+		if err != nil {
+			return err
+		}
+		data = []byte(rec.Data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (self *boltCatalog) Search(query string, limit int) ([]Match, error) {
+	terms := unique(normalize.Terms(query))
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	var matches []Match
+	err := self.db.View(func(tx *bolt.Tx) error {
+		// scores of the movies which contain every term seen so far
+		scores := lookup(tx.Bucket(indexBucket), terms[0])
+		for _, term := range terms[1:] {
+			found := lookup(tx.Bucket(indexBucket), term)
+			for id, score := range scores {
+				if weight, ok := found[id]; ok {
+					scores[id] = score + weight
+				} else {
+					delete(scores, id)
+				}
+			}
+		}
+
+		titles := make(map[string]string, len(scores))
+		for id, score := range scores {
+			rec, err := load(tx, id)
+			if err != nil {
+				return err
+			}
+			titles[id] = rec.Title
+			matches = append(matches, Match{Id: id, Score: score, Data: []byte(rec.Data)})
+		}
+		sort.Slice(matches, func(i, j int) bool {
+			a, b := matches[i], matches[j]
+			if a.Score != b.Score {
+				return a.Score > b.Score
+			}
+			if titles[a.Id] != titles[b.Id] {
+				return titles[a.Id] < titles[b.Id]
+			}
+			return a.Id < b.Id
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+func (self *boltCatalog) Close() error {
+	return self.db.Close()
+}
+
+func load(tx *bolt.Tx, id string) (*record, error) {
+	value := tx.Bucket(moviesBucket).Get([]byte(id))
+	if value == nil {
+		return nil, ErrNotFound
+	}
+	var rec record
+	if err := json.Unmarshal(value, &rec); err != nil {
+		return nil, fmt.Errorf("Cannot decode catalog movie %s: %s", id, err)
+	}
+	return &rec, nil
+}
+
+// lookup returns id -> weight of the movies which contain the term.
+func lookup(index *bolt.Bucket, term string) map[string]int {
+	found := make(map[string]int)
+	prefix := posting(term, "")
+	c := index.Cursor()
+	for k, v := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)); k, v = c.Next() {
+		found[string(k[len(prefix):])] = int(v[0])
+	}
+	return found
+}
+
+// posting is the index key, the postings of a term are sorted together.
+func posting(term, id string) []byte {
+	return []byte(term + "\x00" + id)
+}
+
+func documentTerms(doc Document) map[string]int {
+	terms := make(map[string]int)
+	add := func(text string, weight int) {
+		for _, term := range unique(normalize.Terms(text)) {
+			terms[term] += weight
+		}
+	}
+	add(doc.Title, titleWeight)
+	add(strings.Join(doc.Cast, " "), castWeight)
+	add(doc.Synopsis, synopsisWeight)
+	return terms
+}
+
+func unique(terms []string) []string {
+	seen := make(map[string]bool, len(terms))
+	result := terms[:0]
+	for _, term := range terms {
+		if !seen[term] {
+			seen[term] = true
+			result = append(result, term)
+		}
+	}
+	return result
+}
+
+// Open opens or creates the catalog file.
+func Open(fileName string) (Catalog, error) {
+	db, err := bolt.Open(fileName, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open catalog %s: %s", fileName, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{moviesBucket, indexBucket, termsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Cannot initialize catalog %s: %s", fileName, err)
+	}
+
+	return &boltCatalog{db: db, now: time.Now}, nil
+}
@@ -0,0 +1,110 @@
+package catalog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tempCatalogFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "movie-service-catalog")
+	assert.NoError(t, err)
+	return filepath.Join(dir, "catalog.db"), func() { os.RemoveAll(dir) }
+}
+
+func ids(matches []Match) []string {
+	var result []string
+	for _, match := range matches {
+		result = append(result, match.Id)
+	}
+	return result
+}
+
+var testDocuments = []Document{
+	{Id: "771380589", Title: "The Martian", Cast: []string{"Matt Damon", "Jessica Chastain"},
+		Synopsis: "During a manned mission to Mars, Astronaut Mark Watney is presumed dead.", Data: []byte(`{"Id":"771380589"}`)},
+	{Id: "770672122", Title: "Martian Child", Cast: []string{"John Cusack", "Amanda Peet"},
+		Synopsis: "A widowed science-fiction writer adopts a boy who claims he is from Mars.", Data: []byte(`{"Id":"770672122"}`)},
+	{Id: "13863", Title: "Good Will Hunting", Cast: []string{"Matt Damon", "Robin Williams"},
+		Synopsis: "A janitor at M.I.T. has a gift for mathematics.", Data: []byte(`{"Id":"13863"}`)},
+}
+
+func TestOpenInvalidFile(t *testing.T) {
+	_, err := Open("/not-existing-dir/catalog.db")
+	assert.Error(t, err)
+}
+
+func TestPutGetSearch(t *testing.T) {
+	fileName, cleanup := tempCatalogFile(t)
+	defer cleanup()
+
+	catalog, err := Open(fileName)
+	assert.NoError(t, err)
+	assert.NoError(t, catalog.Put(testDocuments))
+	assert.NoError(t, catalog.Close())
+
+	// movies survive restart
+	catalog, err = Open(fileName)
+	assert.NoError(t, err)
+	defer catalog.Close()
+
+	data, err := catalog.Get("13863")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"Id":"13863"}`, string(data))
+	_, err = catalog.Get("1")
+	assert.Equal(t, ErrNotFound, err)
+
+	// title matches rank above synopsis ones
+	matches, err := catalog.Search("martian", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"770672122", "771380589"}, ids(matches))
+	assert.Equal(t, titleWeight, matches[0].Score)
+	assert.Equal(t, `{"Id":"770672122"}`, string(matches[0].Data))
+
+	matches, err = catalog.Search("Matt DAMON", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"13863", "771380589"}, ids(matches))
+
+	matches, err = catalog.Search("mars", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"770672122"}, ids(matches))
+
+	// every term must match
+	matches, err = catalog.Search("damon mars", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"771380589"}, ids(matches))
+	assert.Equal(t, castWeight+synopsisWeight, matches[0].Score)
+
+	matches, err = catalog.Search("damon alien", 0)
+	assert.NoError(t, err)
+	assert.Empty(t, matches)
+
+	matches, err = catalog.Search("...", 0)
+	assert.NoError(t, err)
+	assert.Empty(t, matches)
+}
+
+func TestPutReplacesIndex(t *testing.T) {
+	fileName, cleanup := tempCatalogFile(t)
+	defer cleanup()
+
+	catalog, err := Open(fileName)
+	assert.NoError(t, err)
+	defer catalog.Close()
+
+	assert.NoError(t, catalog.Put(testDocuments[:1]))
+	assert.NoError(t, catalog.Put([]Document{{Id: "771380589", Title: "The Martian (2015)", Data: []byte(`{}`)}}))
+
+	matches, err := catalog.Search("damon", 0)
+	assert.NoError(t, err)
+	assert.Empty(t, matches)
+
+	matches, err = catalog.Search("martian 2015", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"771380589"}, ids(matches))
+
+	assert.EqualError(t, catalog.Put([]Document{{Title: "No id"}}), "Catalog movie id cannot be empty")
+}
@@ -0,0 +1,151 @@
+package catalog
+
+import (
+	"encoding/binary"
+	"sort"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/plar/movie-service/normalize"
+)
+
+// max number of the dictionary terms which may replace a misspelled query term
+const maxCandidates = 3
+
+type candidate struct {
+	term     string
+	distance int
+	movies   int // how many movies contain the term
+}
+
+func (self *boltCatalog) Suggest(query string, limit int) ([]string, error) {
+	terms := normalize.Terms(query)
+	if len(terms) == 0 || limit < 1 {
+		return nil, nil
+	}
+
+	options := make([][]candidate, len(terms))
+	err := self.db.View(func(tx *bolt.Tx) error {
+		dictionary := tx.Bucket(termsBucket)
+		for i, term := range terms {
+			var err error
+			options[i], err = candidates(dictionary, term)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the closest candidates of all terms first, then the ones with another candidate of a single term
+	type suggestion struct {
+		text     string
+		distance int
+	}
+	combine := func(position, choice int) suggestion {
+		words := make([]string, len(options))
+		var distance int
+		for i, candidates := range options {
+			c := candidates[0]
+			if i == position {
+				c = candidates[choice]
+			}
+			words[i], distance = c.term, distance+c.distance
+		}
+		return suggestion{strings.Join(words, " "), distance}
+	}
+
+	suggestions := []suggestion{combine(-1, 0)}
+	for i, candidates := range options {
+		for j := 1; j < len(candidates); j++ {
+			suggestions = append(suggestions, combine(i, j))
+		}
+	}
+	sort.SliceStable(suggestions, func(i, j int) bool { return suggestions[i].distance < suggestions[j].distance })
+
+	var result []string
+	for _, s := range suggestions {
+		if s.distance > 0 && len(result) < limit {
+			result = append(result, s.text)
+		}
+	}
+	return result, nil
+}
+
+// candidates returns the term itself when the dictionary has it, otherwise the closest dictionary terms.
+// The term is kept when nothing is close enough.
+func candidates(dictionary *bolt.Bucket, term string) ([]candidate, error) {
+	if value := dictionary.Get([]byte(term)); value != nil {
+		return []candidate{{term, 0, movies(value)}}, nil
+	}
+
+	allowed := maxDistance(term)
+	var found []candidate
+	if allowed > 0 {
+		length := len([]rune(term))
+		err := dictionary.ForEach(func(k, v []byte) error {
+			if diff := len([]rune(string(k))) - length; diff > allowed || -diff > allowed {
+				return nil
+			}
+			if d := normalize.Distance(term, string(k)); d <= allowed {
+				found = append(found, candidate{string(k), d, movies(v)})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(found) == 0 {
+		return []candidate{{term, 0, 0}}, nil
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		a, b := found[i], found[j]
+		if a.distance != b.distance {
+			return a.distance < b.distance
+		}
+		if a.movies != b.movies {
+			return a.movies > b.movies
+		}
+		return a.term < b.term
+	})
+	if len(found) > maxCandidates {
+		found = found[:maxCandidates]
+	}
+	return found, nil
+}
+
+// maxDistance allows more typos in the longer terms, the short ones are not corrected.
+func maxDistance(term string) int {
+	switch length := len([]rune(term)); {
+	case length <= 3:
+		return 0
+	case length <= 5:
+		return 1
+	}
+	return 2
+}
+
+func movies(value []byte) int {
+	return int(binary.BigEndian.Uint64(value))
+}
+
+// count changes the number of movies which contain the term, the term is removed when there are none.
+func count(dictionary *bolt.Bucket, term string, delta int) error {
+	n := delta
+	if value := dictionary.Get([]byte(term)); value != nil {
+		n += movies(value)
+	}
+	if n <= 0 {
+		return dictionary.Delete([]byte(term))
+	}
+
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(n))
+	return dictionary.Put([]byte(term), value)
+}
@@ -0,0 +1,59 @@
+package catalog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuggest(t *testing.T) {
+	fileName, cleanup := tempCatalogFile(t)
+	defer cleanup()
+
+	catalog, err := Open(fileName)
+	assert.NoError(t, err)
+	defer catalog.Close()
+	assert.NoError(t, catalog.Put(testDocuments))
+
+	suggestions, err := catalog.Suggest("marshian", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"martian"}, suggestions)
+
+	// the known terms are kept, the short ones are not corrected
+	suggestions, err = catalog.Suggest("Matt Damin", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"matt damon"}, suggestions)
+
+	suggestions, err = catalog.Suggest("mat damon", 3)
+	assert.NoError(t, err)
+	assert.Empty(t, suggestions)
+
+	// the candidates found in more movies go first
+	assert.NoError(t, catalog.Put([]Document{{Id: "1", Title: "Marion", Data: []byte(`{}`)}}))
+	suggestions, err = catalog.Suggest("marian", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"martian", "marion"}, suggestions)
+	suggestions, err = catalog.Suggest("marian", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"martian"}, suggestions)
+
+	suggestions, err = catalog.Suggest("zzzzzz", 3)
+	assert.NoError(t, err)
+	assert.Empty(t, suggestions)
+}
+
+func TestSuggestAfterReplace(t *testing.T) {
+	fileName, cleanup := tempCatalogFile(t)
+	defer cleanup()
+
+	catalog, err := Open(fileName)
+	assert.NoError(t, err)
+	defer catalog.Close()
+
+	assert.NoError(t, catalog.Put([]Document{{Id: "1", Title: "Martian", Data: []byte(`{}`)}}))
+	assert.NoError(t, catalog.Put([]Document{{Id: "1", Title: "Marsian", Data: []byte(`{}`)}}))
+
+	suggestions, err := catalog.Suggest("marthian", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"marsian"}, suggestions)
+}
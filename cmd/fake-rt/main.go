@@ -0,0 +1,53 @@
+// fake-rt serves Rotten Tomatoes API responses from fixtures for local development,
+// point the service at it with [rottentomatoes] base_url = http://127.0.0.1:8081
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/plar/movie-service/fakert"
+)
+
+var (
+	listen    = flag.String("listen", "127.0.0.1:8081", "Address to listen on")
+	fixtures  = flag.String("fixtures", "fixtures", "Directory with fixture files")
+	apiKey    = flag.String("api-key", "", "Required API key, empty - any key is accepted")
+	latency   = flag.Duration("latency", 0, "Delay of every response, e.g. 200ms")
+	errorRate = flag.Float64("error-rate", 0, "Share of requests answered with 500, 0..1")
+	rateLimit = flag.Int("rate-limit", 0, "Requests per second, exceeded requests are answered with 403, 0 - unlimited")
+)
+
+func main() {
+	flag.Parse()
+	defer log.Flush()
+
+	if *errorRate < 0 || *errorRate > 1 {
+		log.Errorf("Error rate must be between 0 and 1, got %v", *errorRate)
+		log.Flush()
+		os.Exit(1)
+	}
+	if _, err := os.Stat(*fixtures); err != nil {
+		log.Errorf("Cannot open fixtures directory, error=%s", err)
+		log.Flush()
+		os.Exit(1)
+	}
+
+	server := fakert.New(fakert.Options{
+		FixturesDir: *fixtures,
+		APIKey:      *apiKey,
+		Latency:     *latency,
+		ErrorRate:   *errorRate,
+		RateLimit:   *rateLimit,
+	})
+
+	log.Infof("Fake Rotten Tomatoes API is listening on http://%s%s, fixtures=%s", *listen, fakert.APIPrefix, *fixtures)
+	if err := http.ListenAndServe(*listen, server); err != nil {
+		log.Errorf("Cannot serve fake API, error=%s", err)
+		log.Flush()
+		os.Exit(1)
+	}
+}
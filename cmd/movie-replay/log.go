@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/plar/movie-service/rest"
+)
+
+const (
+	methodMovies   = "movies"
+	methodFullCast = "full_cast"
+)
+
+// entry is one line of the request log:
+//
+//	{"method":"movies","query":"The Martian","request":{"exchange_name":"movies","routing_key":"search"}}
+//	{"method":"full_cast","id":"771380589"}
+//
+// Lines of the backlog format {"request_id":"...","title":"..."} are replayed as searches for the title.
+type entry struct {
+	Method  string       `json:"method"`
+	Query   string       `json:"query"`
+	Id      string       `json:"id"`
+	Request rest.Request `json:"request"`
+
+	RequestId string `json:"request_id"`
+	Title     string `json:"title"`
+}
+
+// readLog reads request log entries, empty lines are skipped.
+func readLog(r io.Reader) ([]entry, error) {
+	var entries []entry
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 {
+			continue
+		}
+
+		var e entry
+		if err := json.Unmarshal([]byte(text), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		if err := e.normalize(); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (self *entry) normalize() error {
+	if len(self.Query) == 0 {
+		self.Query = self.Title
+	}
+	if len(self.Request.RequestId) == 0 {
+		self.Request.RequestId = self.RequestId
+	}
+	if len(self.Method) == 0 {
+		self.Method = methodMovies
+	}
+
+	switch self.Method {
+	case methodMovies:
+		if len(self.Query) == 0 {
+			return fmt.Errorf("query is required")
+		}
+	case methodFullCast:
+		if len(self.Id) == 0 {
+			return fmt.Errorf("id is required")
+		}
+	default:
+		return fmt.Errorf("unknown method '%s'", self.Method)
+	}
+	return nil
+}
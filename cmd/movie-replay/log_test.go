@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/plar/movie-service/rest"
+)
+
+func TestReadLog(t *testing.T) {
+	entries, err := readLog(strings.NewReader(`
+{"method":"movies","query":"The Martian","request":{"request_id":"r1","exchange_name":"movies","routing_key":"search"}}
+{"method":"full_cast","id":"771380589"}
+
+{"request_id":"user-037","title":"Local fake Rotten Tomatoes server","body":"..."}
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(entries))
+
+	assert.Equal(t, methodMovies, entries[0].Method)
+	assert.Equal(t, "The Martian", entries[0].Query)
+	assert.Equal(t, rest.Request{RequestId: "r1", ExchangeName: "movies", RoutingKey: "search"}, entries[0].Request)
+
+	assert.Equal(t, methodFullCast, entries[1].Method)
+	assert.Equal(t, "771380589", entries[1].Id)
+
+	assert.Equal(t, methodMovies, entries[2].Method)
+	assert.Equal(t, "Local fake Rotten Tomatoes server", entries[2].Query)
+	assert.Equal(t, "user-037", entries[2].Request.RequestId)
+}
+
+func TestReadLogErrors(t *testing.T) {
+	_, err := readLog(strings.NewReader("{\"query\":\"martian\"}\nnot-json\n"))
+	assert.EqualError(t, err, "line 2: invalid character 'o' in literal null (expecting 'u')")
+
+	_, err = readLog(strings.NewReader(`{"method":"movies"}`))
+	assert.EqualError(t, err, "line 1: query is required")
+
+	_, err = readLog(strings.NewReader(`{"method":"full_cast"}`))
+	assert.EqualError(t, err, "line 1: id is required")
+
+	_, err = readLog(strings.NewReader(`{"method":"reviews","id":"1"}`))
+	assert.EqualError(t, err, "line 1: unknown method 'reviews'")
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/plar/movie-service/rest"
+)
+
+// result is a response published by the service.
+type result struct {
+	RequestId string
+	Success   bool
+	Received  time.Time
+}
+
+// sender posts the request and returns HTTP status of the response.
+type sender func(e entry, req rest.Request) (int, error)
+
+// replayer sends log entries with the rate and concurrency and correlates them with the results.
+type replayer struct {
+	send        sender
+	results     <-chan result
+	limiter     *rate.Limiter // nil - unlimited
+	concurrency int
+	timeout     time.Duration // how long to wait for results after the last request
+	runId       string
+	exchange    string // overrides the logged exchange, empty - keep it
+	routingKey  string // overrides the logged routing key, empty - keep it
+	now         func() time.Time
+
+	lock    sync.Mutex
+	pending map[string]time.Time // request id -> sent time
+	drained chan struct{}        // closed when no request is pending after the last one is sent
+	sending bool
+}
+
+func newReplayer(send sender, results <-chan result, concurrency int, perSecond float64, timeout time.Duration, runId string) *replayer {
+	var limiter *rate.Limiter
+	if perSecond > 0 {
+		limiter = rate.NewLimiter(rate.Limit(perSecond), 1)
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &replayer{
+		send:        send,
+		results:     results,
+		limiter:     limiter,
+		concurrency: concurrency,
+		timeout:     timeout,
+		runId:       runId,
+		now:         time.Now,
+		pending:     make(map[string]time.Time),
+		drained:     make(chan struct{}),
+	}
+}
+
+// Run replays entries the given number of times and returns the report.
+func (self *replayer) Run(entries []entry, times int) *report {
+	rep := newReport()
+	started := self.now()
+
+	go self.collect(rep)
+
+	self.lock.Lock()
+	self.sending = true
+	self.lock.Unlock()
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < self.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range jobs {
+				self.replay(rep, n, entries[n%len(entries)])
+			}
+		}()
+	}
+	for n := 0; n < len(entries)*times; n++ {
+		if self.limiter != nil {
+			self.limiter.Wait(context.Background())
+		}
+		jobs <- n
+	}
+	close(jobs)
+	wg.Wait()
+
+	self.lock.Lock()
+	self.sending = false
+	self.checkDrained()
+	self.lock.Unlock()
+
+	select {
+	case <-self.drained:
+	case <-time.After(self.timeout):
+	}
+
+	self.lock.Lock()
+	rep.Lost = len(self.pending)
+	self.pending = make(map[string]time.Time)
+	self.lock.Unlock()
+
+	rep.Elapsed = self.now().Sub(started)
+	return rep
+}
+
+// replay sends n-th request with unique request id, so its result can be correlated.
+func (self *replayer) replay(rep *report, n int, e entry) {
+	req := e.Request
+	req.RequestId = self.runId + "-" + strconv.Itoa(n)
+	if len(self.exchange) > 0 {
+		req.ExchangeName = self.exchange
+	}
+	if len(self.routingKey) > 0 {
+		req.RoutingKey = self.routingKey
+	}
+
+	// the result can arrive before send returns
+	sent := self.now()
+	self.lock.Lock()
+	self.pending[req.RequestId] = sent
+	self.lock.Unlock()
+
+	status, err := self.send(e, req)
+	latency := self.now().Sub(sent)
+	switch {
+	case err != nil:
+		self.forget(req.RequestId)
+		rep.sent(false, "error", latency)
+	case status != 200:
+		self.forget(req.RequestId)
+		rep.sent(false, strconv.Itoa(status), latency)
+	default:
+		rep.sent(true, "", latency)
+	}
+}
+
+func (self *replayer) forget(requestId string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.pending, requestId)
+}
+
+// collect matches results with pending requests until the results channel is closed.
+func (self *replayer) collect(rep *report) {
+	for res := range self.results {
+		// the report is updated before Run can see the request is not pending
+		self.lock.Lock()
+		if sent, exists := self.pending[res.RequestId]; exists {
+			delete(self.pending, res.RequestId)
+			rep.received(res.Success, res.Received.Sub(sent))
+			self.checkDrained()
+		}
+		self.lock.Unlock()
+	}
+}
+
+// checkDrained must be called with the lock held.
+func (self *replayer) checkDrained() {
+	if self.sending || len(self.pending) > 0 {
+		return
+	}
+	select {
+	case <-self.drained:
+	default:
+		close(self.drained)
+	}
+}
+
+func newRunId() string {
+	return fmt.Sprintf("replay-%d", time.Now().UnixNano())
+}
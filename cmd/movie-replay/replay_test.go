@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/plar/movie-service/rest"
+)
+
+// fakeService accepts requests and publishes their results like the service does.
+type fakeService struct {
+	sync.Mutex
+	results  chan result
+	requests []rest.Request
+}
+
+func (self *fakeService) send(e entry, req rest.Request) (int, error) {
+	self.Lock()
+	self.requests = append(self.requests, req)
+	self.Unlock()
+
+	switch e.Query {
+	case "rejected":
+		return 429, nil
+	case "broken":
+		return 0, errors.New("connection refused")
+	case "lost":
+		return 200, nil
+	}
+
+	go func() {
+		self.results <- result{RequestId: req.RequestId, Success: e.Query != "failed", Received: time.Now()}
+	}()
+	return 200, nil
+}
+
+func TestReplay(t *testing.T) {
+	service := &fakeService{results: make(chan result)}
+	defer close(service.results)
+
+	replayer := newReplayer(service.send, service.results, 3, 0, 50*time.Millisecond, "run")
+	replayer.exchange = "movies"
+	replayer.routingKey = "movie-replay.run"
+
+	entries := []entry{
+		{Method: methodMovies, Query: "martian", Request: rest.Request{RequestId: "r1", ExchangeName: "other", RoutingKey: "search"}},
+		{Method: methodMovies, Query: "failed"},
+		{Method: methodMovies, Query: "rejected"},
+		{Method: methodMovies, Query: "broken"},
+		{Method: methodMovies, Query: "lost"},
+	}
+	rep := replayer.Run(entries, 2)
+
+	assert.Equal(t, 10, rep.Sent)
+	assert.Equal(t, 6, rep.Accepted)
+	assert.Equal(t, map[string]int{"429": 2, "error": 2}, rep.Rejected)
+	assert.Equal(t, 2, rep.Succeeded)
+	assert.Equal(t, 2, rep.Failed)
+	assert.Equal(t, 2, rep.Lost)
+	assert.Equal(t, 4, len(rep.endToEnd))
+	assert.Equal(t, 0.8, rep.ErrorRate())
+
+	ids := make(map[string]bool)
+	for _, req := range service.requests {
+		ids[req.RequestId] = true
+		assert.Equal(t, "movies", req.ExchangeName)
+		assert.Equal(t, "movie-replay.run", req.RoutingKey)
+	}
+	assert.Equal(t, 10, len(ids))
+	assert.True(t, ids["run-0"] && ids["run-9"])
+}
+
+func TestReplayDoesNotWaitWhenAllResultsArrived(t *testing.T) {
+	service := &fakeService{results: make(chan result)}
+	defer close(service.results)
+
+	replayer := newReplayer(service.send, service.results, 1, 1000, time.Minute, "run")
+	started := time.Now()
+	rep := replayer.Run([]entry{{Method: methodMovies, Query: "martian"}}, 3)
+
+	assert.Equal(t, 3, rep.Succeeded)
+	assert.Equal(t, 0, rep.Lost)
+	assert.True(t, time.Since(started) < time.Minute)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	"github.com/plar/movie-service/rest"
+)
+
+// requestURL returns URL of the endpoint of the entry.
+func requestURL(serviceURL string, e entry) string {
+	base := strings.TrimSuffix(serviceURL, "/")
+	if e.Method == methodFullCast {
+		return base + "/movie/" + url.PathEscape(e.Id) + "/full_cast"
+	}
+	return base + "/movies?q=" + url.QueryEscape(e.Query)
+}
+
+func newSender(serviceURL, apiKey string, concurrency int) sender {
+	client := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{MaxIdleConnsPerHost: concurrency},
+	}
+
+	return func(e entry, req rest.Request) (int, error) {
+		body, err := json.Marshal(req)
+		if err != nil {
+			return 0, err
+		}
+
+		httpReq, err := http.NewRequest("POST", requestURL(serviceURL, e), bytes.NewReader(body))
+		if err != nil {
+			return 0, err
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set(rest.RequestIdHeader, req.RequestId)
+		if len(apiKey) > 0 {
+			httpReq.Header.Set(rest.APIKeyHeader, apiKey)
+		}
+
+		resp, err := client.Do(httpReq)
+		if err != nil {
+			return 0, err
+		}
+		// drain the body, so the connection is reused
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+}
+
+// consume binds a temporary queue to the exchange with the routing key of the run,
+// results are sent to the returned channel until the returned function closes the connection.
+func consume(uri, caFile, exchange, exchangeType, routingKey string) (<-chan result, func(), error) {
+	tlsOptions := rest.MessageQueueTLSOptions{CAFile: caFile}
+	config, err := tlsOptions.Config()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := amqp.DialTLS(uri, config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot connect to MessageQueue: %s", err)
+	}
+
+	deliveries, err := bind(conn, exchange, exchangeType, routingKey)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	results := make(chan result)
+	go func() {
+		defer close(results)
+		for delivery := range deliveries {
+			if res, ok := decode(delivery, time.Now()); ok {
+				results <- res
+			}
+		}
+	}()
+
+	return results, func() { conn.Close() }, nil
+}
+
+func bind(conn *amqp.Connection, exchange, exchangeType, routingKey string) (<-chan amqp.Delivery, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open channel: %s", err)
+	}
+
+	// the service declares the exchange with the same defaults on the first publish
+	err = ch.ExchangeDeclare(exchange, exchangeType, true, false, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot declare exchange '%s': %s", exchange, err)
+	}
+
+	queue, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot declare queue: %s", err)
+	}
+
+	err = ch.QueueBind(queue.Name, routingKey, exchange, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot bind queue to exchange '%s' with routing key '%s': %s", exchange, routingKey, err)
+	}
+
+	deliveries, err := ch.Consume(queue.Name, "movie-replay", true, true, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot consume queue: %s", err)
+	}
+	return deliveries, nil
+}
+
+// decode returns result of the published response, false for messages which are not responses.
+func decode(delivery amqp.Delivery, received time.Time) (result, bool) {
+	var resp rest.SearchResponse
+	if err := json.Unmarshal(delivery.Body, &resp); err != nil {
+		return result{}, false
+	}
+
+	requestId := resp.Meta.RequestId
+	if len(requestId) == 0 {
+		requestId = delivery.CorrelationId
+	}
+	return result{RequestId: requestId, Success: resp.Meta.Status == rest.SUCCESS, Received: received}, len(requestId) > 0
+}
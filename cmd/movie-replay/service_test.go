@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/plar/movie-service/rest"
+)
+
+func TestRequestURL(t *testing.T) {
+	assert.Equal(t, "http://127.0.0.1:12345/movies?q=The+Martian",
+		requestURL("http://127.0.0.1:12345/", entry{Method: methodMovies, Query: "The Martian"}))
+	assert.Equal(t, "http://127.0.0.1:12345/movie/771380589/full_cast",
+		requestURL("http://127.0.0.1:12345", entry{Method: methodFullCast, Id: "771380589"}))
+}
+
+func TestSender(t *testing.T) {
+	var received *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	send := newSender(server.URL, "KEY", 1)
+	status, err := send(entry{Method: methodMovies, Query: "martian"}, rest.Request{RequestId: "run-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, status)
+	assert.Equal(t, "run-1", received.Header.Get(rest.RequestIdHeader))
+	assert.Equal(t, "KEY", received.Header.Get(rest.APIKeyHeader))
+	assert.Equal(t, "martian", received.URL.Query().Get("q"))
+}
+
+func TestDecode(t *testing.T) {
+	now := time.Now()
+
+	body, _ := json.Marshal(rest.NewSearchResponseSuccess("run-1", nil))
+	res, ok := decode(amqp.Delivery{Body: body}, now)
+	assert.True(t, ok)
+	assert.Equal(t, result{RequestId: "run-1", Success: true, Received: now}, res)
+
+	body, _ = json.Marshal(rest.NewSearchResponseError("", errors.New("api error, response code: 500")))
+	res, ok = decode(amqp.Delivery{Body: body, CorrelationId: "run-2"}, now)
+	assert.True(t, ok)
+	assert.Equal(t, result{RequestId: "run-2", Success: false, Received: now}, res)
+
+	_, ok = decode(amqp.Delivery{Body: []byte("not-json")}, now)
+	assert.False(t, ok)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+var percentiles = []float64{50, 90, 95, 99}
+
+// report collects results of the replay.
+type report struct {
+	sync.Mutex
+	Sent      int
+	Accepted  int
+	Rejected  map[string]int // HTTP status or "error" -> count
+	Succeeded int
+	Failed    int // results published with error status
+	Lost      int // accepted requests without result in time
+	Elapsed   time.Duration
+
+	accept   []time.Duration // request -> HTTP response
+	endToEnd []time.Duration // request -> AMQP result
+}
+
+func newReport() *report {
+	return &report{Rejected: make(map[string]int)}
+}
+
+func (self *report) sent(accepted bool, reason string, latency time.Duration) {
+	self.Lock()
+	defer self.Unlock()
+	self.Sent++
+	if !accepted {
+		self.Rejected[reason]++
+		return
+	}
+	self.Accepted++
+	self.accept = append(self.accept, latency)
+}
+
+func (self *report) received(success bool, latency time.Duration) {
+	self.Lock()
+	defer self.Unlock()
+	if success {
+		self.Succeeded++
+	} else {
+		self.Failed++
+	}
+	self.endToEnd = append(self.endToEnd, latency)
+}
+
+// ErrorRate returns share of sent requests which are rejected, failed or lost.
+func (self *report) ErrorRate() float64 {
+	self.Lock()
+	defer self.Unlock()
+	if self.Sent == 0 {
+		return 0
+	}
+	return float64(self.Sent-self.Succeeded) / float64(self.Sent)
+}
+
+func (self *report) Print(w io.Writer) {
+	errorRate := self.ErrorRate()
+
+	self.Lock()
+	defer self.Unlock()
+
+	fmt.Fprintf(w, "requests:    sent=%d accepted=%d succeeded=%d failed=%d lost=%d elapsed=%s\n",
+		self.Sent, self.Accepted, self.Succeeded, self.Failed, self.Lost, self.Elapsed.Round(time.Millisecond))
+	if self.Elapsed > 0 {
+		fmt.Fprintf(w, "throughput:  %.1f req/s\n", float64(self.Sent)/self.Elapsed.Seconds())
+	}
+	fmt.Fprintf(w, "error rate:  %.2f%%\n", errorRate*100)
+
+	reasons := make([]string, 0, len(self.Rejected))
+	for reason := range self.Rejected {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "rejected:    %s=%d\n", reason, self.Rejected[reason])
+	}
+
+	fmt.Fprintf(w, "accept:      %s\n", summary(self.accept))
+	fmt.Fprintf(w, "end-to-end:  %s\n", summary(self.endToEnd))
+}
+
+// summary formats percentiles of the latencies, e.g. "p50=12ms p90=40ms p95=45ms p99=80ms max=81ms".
+func summary(latencies []time.Duration) string {
+	if len(latencies) == 0 {
+		return "-"
+	}
+
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	s := ""
+	for _, p := range percentiles {
+		s += fmt.Sprintf("p%g=%s ", p, percentile(sorted, p).Round(time.Microsecond))
+	}
+	return s + fmt.Sprintf("max=%s", sorted[len(sorted)-1].Round(time.Microsecond))
+}
+
+// percentile uses nearest rank of the sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(t, 50*time.Millisecond, percentile(sorted, 50))
+	assert.Equal(t, 99*time.Millisecond, percentile(sorted, 99))
+	assert.Equal(t, 100*time.Millisecond, percentile(sorted, 100))
+	assert.Equal(t, time.Millisecond, percentile(sorted[:1], 99))
+}
+
+func TestReportPrint(t *testing.T) {
+	rep := newReport()
+	rep.sent(true, "", 2*time.Millisecond)
+	rep.sent(true, "", 4*time.Millisecond)
+	rep.sent(true, "", 4*time.Millisecond)
+	rep.sent(false, "429", time.Millisecond)
+	rep.received(true, 10*time.Millisecond)
+	rep.received(false, 30*time.Millisecond)
+	rep.Lost = 1
+	rep.Elapsed = 2 * time.Second
+
+	assert.Equal(t, 0.75, rep.ErrorRate())
+
+	var out bytes.Buffer
+	rep.Print(&out)
+	assert.Equal(t, "requests:    sent=4 accepted=3 succeeded=1 failed=1 lost=1 elapsed=2s\n"+
+		"throughput:  2.0 req/s\n"+
+		"error rate:  75.00%\n"+
+		"rejected:    429=1\n"+
+		"accept:      p50=4ms p90=4ms p95=4ms p99=4ms max=4ms\n"+
+		"end-to-end:  p50=10ms p90=30ms p95=30ms p99=30ms max=30ms\n", out.String())
+
+	assert.Equal(t, "-", summary(nil))
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	"github.com/plar/movie-service/rest"
+)
+
+// exchangeOptions declare the exchange when it does not exist, they should match the service settings.
+type exchangeOptions struct {
+	Type       string
+	Durable    bool
+	AutoDelete bool
+}
+
+// listener receives results from a temporary queue bound to the exchange.
+type listener struct {
+	conn       *amqp.Connection
+	deliveries <-chan amqp.Delivery
+}
+
+func listen(uri, caFile string, req rest.Request, exchange exchangeOptions) (*listener, error) {
+	tlsOptions := rest.MessageQueueTLSOptions{CAFile: caFile}
+	config, err := tlsOptions.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := amqp.DialTLS(uri, config)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot connect to MessageQueue: %s", err)
+	}
+
+	deliveries, err := bind(conn, req, exchange)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &listener{conn: conn, deliveries: deliveries}, nil
+}
+
+func bind(conn *amqp.Connection, req rest.Request, exchange exchangeOptions) (<-chan amqp.Delivery, error) {
+	ch, err := declare(conn, req.ExchangeName, exchange)
+	if err != nil {
+		return nil, err
+	}
+
+	queue, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot declare queue: %s", err)
+	}
+
+	err = ch.QueueBind(queue.Name, req.RoutingKey, req.ExchangeName, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot bind queue to exchange '%s' with routing key '%s': %s", req.ExchangeName, req.RoutingKey, err)
+	}
+
+	deliveries, err := ch.Consume(queue.Name, "moviectl", true, true, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot consume queue: %s", err)
+	}
+	return deliveries, nil
+}
+
+// declare uses the existing exchange whatever its settings are, the missing one is declared with the options.
+func declare(conn *amqp.Connection, name string, exchange exchangeOptions) (*amqp.Channel, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open channel: %s", err)
+	}
+
+	err = ch.ExchangeDeclarePassive(name, exchange.Type, exchange.Durable, exchange.AutoDelete, false, false, nil)
+	if err == nil {
+		return ch, nil
+	}
+	if amqpErr, ok := err.(*amqp.Error); !ok || amqpErr.Code != amqp.NotFound {
+		return nil, fmt.Errorf("Cannot check exchange '%s': %s", name, err)
+	}
+
+	// the failed passive declare closes the channel
+	ch, err = conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open channel: %s", err)
+	}
+	err = ch.ExchangeDeclare(name, exchange.Type, exchange.Durable, exchange.AutoDelete, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot declare exchange '%s': %s", name, err)
+	}
+	return ch, nil
+}
+
+// Wait returns the result of the request, results of other requests on the same routing key are skipped.
+func (self *listener) Wait(requestId string, timeout time.Duration) (*rest.SearchResponse, error) {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case delivery, ok := <-self.deliveries:
+			if !ok {
+				return nil, errors.New("MessageQueue connection is closed")
+			}
+			if resp, matched := match(delivery, requestId); matched {
+				return resp, nil
+			}
+		case <-deadline:
+			return nil, fmt.Errorf("No result in %s, request_id=%s", timeout, requestId)
+		}
+	}
+}
+
+func (self *listener) Close() error {
+	return self.conn.Close()
+}
+
+// match decodes the delivery when it is the result of the request.
+func match(delivery amqp.Delivery, requestId string) (*rest.SearchResponse, bool) {
+	var resp rest.SearchResponse
+	if err := json.Unmarshal(delivery.Body, &resp); err != nil {
+		return nil, false
+	}
+	if resp.Meta.RequestId != requestId && delivery.CorrelationId != requestId {
+		return nil, false
+	}
+	return &resp, true
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/plar/movie-service/rest"
+)
+
+func TestMatch(t *testing.T) {
+	body, _ := json.Marshal(rest.NewSearchResponseSuccess("moviectl-1", nil))
+
+	resp, matched := match(amqp.Delivery{Body: body}, "moviectl-1")
+	assert.True(t, matched)
+	assert.Equal(t, "moviectl-1", resp.Meta.RequestId)
+
+	_, matched = match(amqp.Delivery{Body: body}, "moviectl-2")
+	assert.False(t, matched)
+
+	_, matched = match(amqp.Delivery{Body: []byte("not-json"), CorrelationId: "moviectl-1"}, "moviectl-1")
+	assert.False(t, matched)
+}
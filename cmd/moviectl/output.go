@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/plar/movie-service/rest"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+func printResponse(w io.Writer, format string, resp *rest.SearchResponse) error {
+	if format == outputJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(resp)
+	}
+	return printTable(w, resp)
+}
+
+func printTable(w io.Writer, resp *rest.SearchResponse) error {
+	if resp.Meta.Status != rest.SUCCESS {
+		_, err := fmt.Fprintf(w, "request_id=%s status=%s error=%s\n", resp.Meta.RequestId, resp.Meta.Status, resp.Meta.Error)
+		return err
+	}
+
+	fmt.Fprintf(w, "request_id=%s status=%s movies=%d\n\n", resp.Meta.RequestId, resp.Meta.Status, len(resp.Data.Movies))
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTITLE\tCRITICS\tAUDIENCE")
+	for _, movie := range resp.Data.Movies {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", movie.Id, movie.Title,
+			rating(movie.Ratings.CriticsRating, movie.Ratings.CriticsScore),
+			rating(movie.Ratings.AudienceRating, movie.Ratings.AudienceScore))
+	}
+	return tw.Flush()
+}
+
+// rating formats rating with its score, e.g. "Certified Fresh (92)".
+func rating(name string, score int) string {
+	if len(name) == 0 {
+		return fmt.Sprintf("- (%d)", score)
+	}
+	return fmt.Sprintf("%s (%d)", name, score)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/plar/movie-service/rest"
+)
+
+func TestPrintTable(t *testing.T) {
+	resp := rest.NewSearchResponseSuccess("moviectl-1", []rest.Movie{
+		{Id: "771380589", Title: "The Martian", Ratings: rest.Ratings{CriticsRating: "Certified Fresh", CriticsScore: 92, AudienceRating: "Upright", AudienceScore: 92}},
+		{Id: "1", Title: "Martian Child", Ratings: rest.Ratings{CriticsScore: 33}},
+	})
+
+	var out bytes.Buffer
+	assert.NoError(t, printResponse(&out, outputTable, resp))
+	assert.Equal(t, "request_id=moviectl-1 status=success movies=2\n\n"+
+		"ID         TITLE          CRITICS               AUDIENCE\n"+
+		"771380589  The Martian    Certified Fresh (92)  Upright (92)\n"+
+		"1          Martian Child  - (33)                - (0)\n", out.String())
+
+	out.Reset()
+	assert.NoError(t, printResponse(&out, outputTable, rest.NewSearchResponseError("moviectl-2", errors.New("api error, response code: 500"))))
+	assert.Equal(t, "request_id=moviectl-2 status=error error=api error, response code: 500\n", out.String())
+}
+
+func TestPrintJSON(t *testing.T) {
+	resp := rest.NewSearchResponseSuccess("moviectl-1", []rest.Movie{{Id: "771380589", Title: "The Martian"}})
+
+	var out bytes.Buffer
+	assert.NoError(t, printResponse(&out, outputJSON, resp))
+
+	var decoded rest.SearchResponse
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, *resp, decoded)
+}
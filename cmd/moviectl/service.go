@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/plar/movie-service/rest"
+)
+
+// requestURL returns URL of the search or full cast endpoint.
+func requestURL(serviceURL, command, arg string) (string, error) {
+	base := strings.TrimSuffix(serviceURL, "/")
+	switch command {
+	case "search":
+		return base + "/movies?q=" + url.QueryEscape(arg), nil
+	case "cast":
+		return base + "/movie/" + url.PathEscape(arg) + "/full_cast", nil
+	}
+	return "", fmt.Errorf("Unknown command '%s'", command)
+}
+
+// send posts the request, the service accepts it and publishes the result later.
+func send(serviceURL, apiKey, command, arg string, req rest.Request, timeout time.Duration) error {
+	u, err := requestURL(serviceURL, command, arg)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set(rest.RequestIdHeader, req.RequestId)
+	if len(apiKey) > 0 {
+		httpReq.Header.Set(rest.APIKeyHeader, apiKey)
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("Cannot send request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	data, _ := ioutil.ReadAll(resp.Body)
+	var errResp rest.ErrorResponse
+	if err := json.Unmarshal(data, &errResp); err != nil || len(errResp.Meta.Error) == 0 {
+		return fmt.Errorf("Request is rejected, status=%d, body=%s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return fmt.Errorf("Request is rejected, status=%d, code=%s, error=%s", resp.StatusCode, errResp.Meta.Code, errResp.Meta.Error)
+}
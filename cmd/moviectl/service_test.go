@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/plar/movie-service/rest"
+)
+
+func TestRequestURL(t *testing.T) {
+	u, err := requestURL("http://127.0.0.1:12345/", "search", "The Martian")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://127.0.0.1:12345/movies?q=The+Martian", u)
+
+	u, err = requestURL("http://127.0.0.1:12345", "cast", "771380589")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://127.0.0.1:12345/movie/771380589/full_cast", u)
+
+	_, err = requestURL("http://127.0.0.1:12345", "reviews", "771380589")
+	assert.EqualError(t, err, "Unknown command 'reviews'")
+}
+
+func TestSend(t *testing.T) {
+	var received *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		if r.URL.Query().Get("q") == "rejected" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"meta":{"status":"error","code":"RATE_LIMITED","error":"Rate limit exceeded"}}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	req := rest.Request{RequestId: "moviectl-1", ExchangeName: "movies", RoutingKey: "moviectl.moviectl-1"}
+	assert.NoError(t, send(server.URL, "KEY", "search", "martian", req, time.Second))
+	assert.Equal(t, "moviectl-1", received.Header.Get(rest.RequestIdHeader))
+	assert.Equal(t, "KEY", received.Header.Get(rest.APIKeyHeader))
+
+	err := send(server.URL, "", "search", "rejected", req, time.Second)
+	assert.EqualError(t, err, "Request is rejected, status=429, code=RATE_LIMITED, error=Rate limit exceeded")
+	assert.Empty(t, received.Header.Get(rest.APIKeyHeader))
+}
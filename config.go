@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cihub/seelog"
+
+	"github.com/plar/movie-service/config"
+	log "github.com/plar/movie-service/logging"
+	"github.com/plar/movie-service/rest"
+	"github.com/plar/movie-service/tracing"
+)
+
+// how long the exit waits for the spans to be exported
+const tracingShutdownTimeout = 5 * time.Second
+
+// reloadLock runs one reload at a time, the next SIGHUP waits for the current reload
+var reloadLock sync.Mutex
+
+// loadLogger replaces the seelog logger with the one configured in the log.xml file.
+func loadLogger(fileName string) error {
+	logger, err := seelog.LoggerFromConfigAsFile(fileName)
+	if err != nil {
+		return err
+	}
+	return log.ReplaceSeelog(logger)
+}
+
+// loadConfig loads the effective config and logs a validation report.
+func loadConfig(fileName string) (*config.Config, bool) {
+	cfg, err := config.LoadFromEnv([]byte(_conf_default), fileName)
+	if err != nil {
+		log.Error(err)
+		return nil, false
+	}
+
+	errs := cfg.Validate()
+	for _, err := range errs {
+		log.Errorf("Invalid configuration: %s", err)
+	}
+	return cfg, len(errs) == 0
+}
+
+// configureLogging switches the log output to the format of the [logging] section.
+func configureLogging(cfg *config.Config) {
+	if err := log.Configure(cfg.LoggingOptions()); err != nil {
+		log.Errorf("Cannot configure logging, keep the current output: %s", err)
+	}
+}
+
+// shutdownTracing exports the spans which are still buffered.
+func shutdownTracing(provider tracing.Provider) {
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+	if err := provider.Shutdown(ctx); err != nil {
+		log.Errorf("Cannot export pending spans, error=%s", err)
+	}
+}
+
+// reload re-reads log.xml and the ini file and applies them to the running server.
+// The current settings are kept when the new ones are invalid.
+func reload(server rest.MovieServer) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	log.Infof("Reload configuration, config=%s, log=%s", *configFileName, *logFileName)
+
+	if err := loadLogger(*logFileName); err != nil {
+		log.Errorf("Cannot reload logger, keep the current one: %s", err)
+	}
+
+	cfg, valid := loadConfig(*configFileName)
+	if !valid {
+		log.Errorf("Cannot reload configuration, keep the current one")
+		return
+	}
+	configureLogging(cfg)
+
+	if err := server.Reload(cfg.Context()); err != nil {
+		log.Errorf("Cannot reload configuration: %s", err)
+	}
+}
@@ -0,0 +1,913 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-ini/ini"
+	"github.com/streadway/amqp"
+
+	"github.com/plar/movie-service/audit"
+	"github.com/plar/movie-service/logging"
+	"github.com/plar/movie-service/rest"
+	"github.com/plar/movie-service/tracing"
+)
+
+const (
+	// Environment variables MOVIE_SERVICE_<SECTION>_<KEY> override ini settings,
+	// e.g. MOVIE_SERVICE_RABBITMQ_URI or MOVIE_SERVICE_ROTTENTOMATOES_ROTTENTOMATOES_API_KEY
+	EnvPrefix = "MOVIE_SERVICE_"
+
+	redacted = "REDACTED"
+
+	sectionService          = "movie-service"
+	sectionRabbitMQ         = "rabbitmq"
+	sectionHealth           = "health"
+	sectionRottenTomatoes   = "rottentomatoes"
+	sectionAllowedExchanges = "allowed-exchanges"
+	sectionAuth             = "auth"
+	sectionTLS              = "tls"
+	sectionShutdown         = "shutdown"
+	sectionJournal          = "journal"
+	sectionCatalog          = "catalog"
+	sectionAudit            = "audit"
+	sectionLogging          = "logging"
+	sectionTracing          = "tracing"
+	sectionCache            = "cache"
+	sectionBatch            = "batch"
+
+	// Section [api-key.<client>] describes API key of the client
+	sectionAPIKeyPrefix = "api-key."
+)
+
+// apiKeyKeys are the keys of [api-key.<client>], the environment can set them for a client missing in the file
+var apiKeyKeys = []string{"key", "rate_limit", "burst", "daily_quota", "exchanges", "routing_keys"}
+
+type ServiceConfig struct {
+	URI     string
+	Workers int
+}
+
+type RabbitMQConfig struct {
+	URI                string
+	Reliable           bool
+	ExchangeType       string
+	ExchangeDeclare    string
+	ExchangeDurable    bool
+	ExchangeAutoDelete bool
+	DeliveryMode       string
+	Expiration         string
+	Priority           int
+	ProbeExchange      bool
+
+	// amqps:// settings
+	TLSCAFile     string
+	TLSCertFile   string
+	TLSKeyFile    string
+	TLSServerName string
+}
+
+type TLSConfig struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	ClientAuth   string
+}
+
+type HealthConfig struct {
+	AMQPProbeInterval     time.Duration
+	UpstreamProbeInterval time.Duration
+	MaxPendingJobs        int
+	WorkerStuckTimeout    time.Duration
+}
+
+type JournalConfig struct {
+	File string
+}
+
+type CatalogConfig struct {
+	File string
+}
+
+type AuditConfig struct {
+	File       string
+	MaxSizeMB  int
+	MaxBackups int
+	Redact     []string
+	RedactMode string
+}
+
+type LoggingConfig struct {
+	// seelog (log.xml) or json
+	Format string
+
+	// json format only, seelog levels are set by log.xml
+	Level string
+
+	// json format only, empty - stdout
+	File string
+}
+
+type TracingConfig struct {
+	// none, stdout or otlp
+	Exporter string
+
+	// OTLP collector host:port
+	Endpoint string
+	Insecure bool
+
+	ServiceName string
+	SampleRatio float64
+}
+
+type CacheConfig struct {
+	MaxStale        time.Duration
+	MaxEntries      int
+	RefreshInterval time.Duration
+}
+
+type BatchConfig struct {
+	MaxItems    int
+	Concurrency int
+}
+
+type ShutdownConfig struct {
+	PreStopDelay   time.Duration
+	RequestTimeout time.Duration
+	DrainTimeout   time.Duration
+	PublishTimeout time.Duration
+}
+
+type RottenTomatoesConfig struct {
+	APIKey string
+
+	// Base URL of the API, empty - the real API
+	BaseURL string
+
+	// Limits one request to the API, a hung provider fails the search and opens the circuit
+	RequestTimeout time.Duration
+
+	// Circuit breaker, see rest.BreakerOptions
+	BreakerFailures    int
+	BreakerOpenTimeout time.Duration
+	BreakerSuccesses   int
+}
+
+type AuthConfig struct {
+	// JSON file with list of API keys, keys from the file are added to [api-key.<client>] ones
+	KeysFile string
+	FileKeys []rest.APIKey
+}
+
+// Config is the effective service configuration: defaults, ini file and environment overrides.
+type Config struct {
+	Service          ServiceConfig
+	TLS              TLSConfig
+	RabbitMQ         RabbitMQConfig
+	Health           HealthConfig
+	Shutdown         ShutdownConfig
+	Journal          JournalConfig
+	Catalog          CatalogConfig
+	Audit            AuditConfig
+	Logging          LoggingConfig
+	Tracing          TracingConfig
+	Cache            CacheConfig
+	Batch            BatchConfig
+	RottenTomatoes   RottenTomatoesConfig
+	AllowedExchanges map[string][]string
+	Auth             AuthConfig
+	APIKeys          []rest.APIKey
+}
+
+// EnvName returns the environment variable which overrides the key of the section.
+func EnvName(section, key string) string {
+	name := strings.ToUpper(section + "_" + key)
+	return EnvPrefix + strings.NewReplacer("-", "_", ".", "_").Replace(name)
+}
+
+// Load reads defaults and the config file (missing file is ignored) and applies environment overrides.
+// Parse errors of the individual keys are collected into a single error.
+func Load(defaults []byte, fileName string, environ []string) (*Config, error) {
+	file, err := ini.LooseLoad(defaults, fileName)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot load settings: %s", err)
+	}
+
+	applyEnv(file, environ)
+
+	p := &parser{file: file}
+	cfg := &Config{
+		Service: ServiceConfig{
+			URI:     p.str(sectionService, "uri"),
+			Workers: p.integer(sectionService, "workers"),
+		},
+		RabbitMQ: RabbitMQConfig{
+			URI:                p.str(sectionRabbitMQ, "uri"),
+			Reliable:           p.boolean(sectionRabbitMQ, "reliable"),
+			ExchangeType:       p.str(sectionRabbitMQ, "exchange_type"),
+			ExchangeDeclare:    p.str(sectionRabbitMQ, "exchange_declare"),
+			ExchangeDurable:    p.boolean(sectionRabbitMQ, "exchange_durable"),
+			ExchangeAutoDelete: p.boolean(sectionRabbitMQ, "exchange_auto_delete"),
+			DeliveryMode:       p.str(sectionRabbitMQ, "delivery_mode"),
+			Expiration:         p.str(sectionRabbitMQ, "expiration"),
+			Priority:           p.integer(sectionRabbitMQ, "priority"),
+			ProbeExchange:      p.boolean(sectionRabbitMQ, "probe_exchange"),
+			TLSCAFile:          p.str(sectionRabbitMQ, "tls_ca_file"),
+			TLSCertFile:        p.str(sectionRabbitMQ, "tls_cert_file"),
+			TLSKeyFile:         p.str(sectionRabbitMQ, "tls_key_file"),
+			TLSServerName:      p.str(sectionRabbitMQ, "tls_server_name"),
+		},
+		TLS: TLSConfig{
+			CertFile:     p.str(sectionTLS, "cert_file"),
+			KeyFile:      p.str(sectionTLS, "key_file"),
+			ClientCAFile: p.str(sectionTLS, "client_ca_file"),
+			ClientAuth:   p.str(sectionTLS, "client_auth"),
+		},
+		Health: HealthConfig{
+			AMQPProbeInterval:     p.duration(sectionHealth, "amqp_probe_interval"),
+			UpstreamProbeInterval: p.duration(sectionHealth, "upstream_probe_interval"),
+			MaxPendingJobs:        p.integer(sectionHealth, "max_pending_jobs"),
+			WorkerStuckTimeout:    p.duration(sectionHealth, "worker_stuck_timeout"),
+		},
+		Shutdown: ShutdownConfig{
+			PreStopDelay:   p.duration(sectionShutdown, "pre_stop_delay"),
+			RequestTimeout: p.duration(sectionShutdown, "request_timeout"),
+			DrainTimeout:   p.duration(sectionShutdown, "drain_timeout"),
+			PublishTimeout: p.duration(sectionShutdown, "publish_timeout"),
+		},
+		Journal: JournalConfig{
+			File: p.str(sectionJournal, "file"),
+		},
+		Catalog: CatalogConfig{
+			File: p.str(sectionCatalog, "file"),
+		},
+		Audit: AuditConfig{
+			File:       p.str(sectionAudit, "file"),
+			MaxSizeMB:  p.integer(sectionAudit, "max_size_mb"),
+			MaxBackups: p.integer(sectionAudit, "max_backups"),
+			Redact:     p.list(sectionAudit, "redact"),
+			RedactMode: p.str(sectionAudit, "redact_mode"),
+		},
+		Logging: LoggingConfig{
+			Format: p.str(sectionLogging, "format"),
+			Level:  p.str(sectionLogging, "level"),
+			File:   p.str(sectionLogging, "file"),
+		},
+		Tracing: TracingConfig{
+			Exporter:    p.str(sectionTracing, "exporter"),
+			Endpoint:    p.str(sectionTracing, "endpoint"),
+			Insecure:    p.boolean(sectionTracing, "insecure"),
+			ServiceName: p.str(sectionTracing, "service_name"),
+			SampleRatio: p.float(sectionTracing, "sample_ratio"),
+		},
+		Cache: CacheConfig{
+			MaxStale:        p.duration(sectionCache, "max_stale"),
+			MaxEntries:      p.integer(sectionCache, "max_entries"),
+			RefreshInterval: p.duration(sectionCache, "refresh_interval"),
+		},
+		Batch: BatchConfig{
+			MaxItems:    p.integer(sectionBatch, "max_items"),
+			Concurrency: p.integer(sectionBatch, "concurrency"),
+		},
+		RottenTomatoes: RottenTomatoesConfig{
+			APIKey:         p.str(sectionRottenTomatoes, "rottentomatoes_api_key"),
+			BaseURL:        p.str(sectionRottenTomatoes, "base_url"),
+			RequestTimeout: p.duration(sectionRottenTomatoes, "request_timeout"),
+
+			BreakerFailures:    p.integer(sectionRottenTomatoes, "breaker_failures"),
+			BreakerOpenTimeout: p.duration(sectionRottenTomatoes, "breaker_open_timeout"),
+			BreakerSuccesses:   p.integer(sectionRottenTomatoes, "breaker_successes"),
+		},
+		AllowedExchanges: make(map[string][]string),
+		Auth: AuthConfig{
+			KeysFile: p.str(sectionAuth, "keys_file"),
+		},
+	}
+
+	for _, key := range file.Section(sectionAllowedExchanges).Keys() {
+		cfg.AllowedExchanges[key.Name()] = key.Strings(",")
+	}
+
+	for _, section := range file.Sections() {
+		if strings.HasPrefix(section.Name(), sectionAPIKeyPrefix) {
+			cfg.APIKeys = append(cfg.APIKeys, p.apiKey(section))
+		}
+	}
+
+	if len(cfg.Auth.KeysFile) > 0 {
+		keys, err := loadKeysFile(cfg.Auth.KeysFile)
+		if err != nil {
+			p.fail(sectionAuth, "keys_file", err)
+		}
+		cfg.Auth.FileKeys = keys
+	}
+
+	if len(p.errs) > 0 {
+		return nil, fmt.Errorf("Cannot parse settings: %s", joinErrors(p.errs))
+	}
+	return cfg, nil
+}
+
+// LoadFromEnv loads config using the process environment.
+func LoadFromEnv(defaults []byte, fileName string) (*Config, error) {
+	return Load(defaults, fileName, os.Environ())
+}
+
+// loadKeysFile reads JSON list of API keys.
+func loadKeysFile(fileName string) ([]rest.APIKey, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []rest.APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("Cannot decode %s: %s", fileName, err)
+	}
+	return keys, nil
+}
+
+func applyEnv(file *ini.File, environ []string) {
+	env := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		if i := strings.Index(kv, "="); i > 0 && strings.HasPrefix(kv, EnvPrefix) {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+
+	for _, section := range file.Sections() {
+		for _, key := range section.Keys() {
+			name := EnvName(section.Name(), key.Name())
+			if value, exists := env[name]; exists {
+				key.SetValue(value)
+				delete(env, name)
+			}
+		}
+	}
+
+	// the rest may add the keys of [api-key.<client>], sorted to add the sections in the same order
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if section, key := apiKeyEnv(file, name); len(section) > 0 {
+			file.Section(section).Key(key).SetValue(env[name])
+		}
+	}
+}
+
+// apiKeyEnv maps the environment variable to the key of [api-key.<client>], empty section - not an API key.
+// The section of the file is used when its name maps to the variable, otherwise the client is the lowercased
+// rest of the name, e.g. MOVIE_SERVICE_API_KEY_BILLING_EU_KEY adds [api-key.billing_eu] key, and EnvName
+// of the new section gives the variable back.
+func apiKeyEnv(file *ini.File, name string) (string, string) {
+	prefix := EnvPrefix + "API_KEY_"
+	if !strings.HasPrefix(name, prefix) {
+		return "", ""
+	}
+
+	tail := strings.TrimPrefix(name, prefix)
+	for _, key := range apiKeyKeys {
+		suffix := "_" + strings.ToUpper(key)
+		if !strings.HasSuffix(tail, suffix) || len(tail) == len(suffix) {
+			continue
+		}
+		client := strings.TrimSuffix(tail, suffix)
+
+		for _, section := range file.Sections() {
+			if strings.HasPrefix(section.Name(), sectionAPIKeyPrefix) && EnvName(section.Name(), key) == name {
+				return section.Name(), key
+			}
+		}
+		return sectionAPIKeyPrefix + strings.ToLower(client), key
+	}
+	return "", ""
+}
+
+// Validate returns all problems found in the config, empty if the config can be used.
+func (self *Config) Validate() []error {
+	var errs []error
+	fail := func(section, key, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("[%s] %s: %s", section, key, fmt.Sprintf(format, args...)))
+	}
+
+	if _, port, err := net.SplitHostPort(self.Service.URI); err != nil || len(port) == 0 {
+		fail(sectionService, "uri", "must be host:port, got '%s'", self.Service.URI)
+	}
+	if self.Service.Workers < 1 || self.Service.Workers > rest.MaxWorkers {
+		fail(sectionService, "workers", "must be between 1 and %d, got %d", rest.MaxWorkers, self.Service.Workers)
+	}
+
+	if err := self.TLSOptions().Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("[%s] %s", sectionTLS, err))
+	}
+	checkFile(fail, sectionTLS, "cert_file", self.TLS.CertFile)
+	checkFile(fail, sectionTLS, "key_file", self.TLS.KeyFile)
+	checkFile(fail, sectionTLS, "client_ca_file", self.TLS.ClientCAFile)
+
+	if _, err := amqp.ParseURI(self.RabbitMQ.URI); err != nil {
+		fail(sectionRabbitMQ, "uri", "%s", err)
+	}
+	if err := self.MessageQueueTLSOptions().Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("[%s] %s", sectionRabbitMQ, err))
+	}
+	checkFile(fail, sectionRabbitMQ, "tls_ca_file", self.RabbitMQ.TLSCAFile)
+	checkFile(fail, sectionRabbitMQ, "tls_cert_file", self.RabbitMQ.TLSCertFile)
+	checkFile(fail, sectionRabbitMQ, "tls_key_file", self.RabbitMQ.TLSKeyFile)
+	if self.RabbitMQ.Priority < 0 || self.RabbitMQ.Priority > rest.MaxPriority {
+		fail(sectionRabbitMQ, "priority", "must be between 0 and %d, got %d", rest.MaxPriority, self.RabbitMQ.Priority)
+	} else if err := self.PublishOptions().Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("[%s] %s", sectionRabbitMQ, err))
+	}
+
+	if self.Health.AMQPProbeInterval <= 0 {
+		fail(sectionHealth, "amqp_probe_interval", "must be positive duration, e.g. 10s")
+	}
+	if self.Health.UpstreamProbeInterval <= 0 {
+		fail(sectionHealth, "upstream_probe_interval", "must be positive duration, e.g. 60s")
+	}
+	if self.Health.MaxPendingJobs < 1 {
+		fail(sectionHealth, "max_pending_jobs", "must be positive, got %d", self.Health.MaxPendingJobs)
+	}
+	if self.Health.WorkerStuckTimeout <= 0 {
+		fail(sectionHealth, "worker_stuck_timeout", "must be positive duration, e.g. 60s")
+	}
+
+	if self.Audit.MaxSizeMB < 0 {
+		fail(sectionAudit, "max_size_mb", "cannot be negative, got %d", self.Audit.MaxSizeMB)
+	}
+	if self.Audit.MaxBackups < 0 {
+		fail(sectionAudit, "max_backups", "cannot be negative, got %d", self.Audit.MaxBackups)
+	}
+	if err := self.AuditOptions().Redaction.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("[%s] %s", sectionAudit, err))
+	}
+
+	if err := self.LoggingOptions().Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("[%s] %s", sectionLogging, err))
+	}
+
+	if err := self.TracingOptions().Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("[%s] %s", sectionTracing, err))
+	}
+
+	if err := self.CacheOptions().Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("[%s] %s", sectionCache, err))
+	}
+
+	if err := self.BatchOptions().Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("[%s] %s", sectionBatch, err))
+	}
+
+	if self.Shutdown.PreStopDelay < 0 {
+		fail(sectionShutdown, "pre_stop_delay", "cannot be negative, got %s", self.Shutdown.PreStopDelay)
+	}
+	if self.Shutdown.RequestTimeout <= 0 {
+		fail(sectionShutdown, "request_timeout", "must be positive duration, e.g. 10s")
+	}
+	if self.Shutdown.DrainTimeout <= 0 {
+		fail(sectionShutdown, "drain_timeout", "must be positive duration, e.g. 10s")
+	}
+	if self.Shutdown.PublishTimeout <= 0 {
+		fail(sectionShutdown, "publish_timeout", "must be positive duration, e.g. 10s")
+	}
+
+	if len(self.RottenTomatoes.APIKey) == 0 {
+		fail(sectionRottenTomatoes, "rottentomatoes_api_key", "is required, set it in the config file or %s",
+			EnvName(sectionRottenTomatoes, "rottentomatoes_api_key"))
+	}
+	if len(self.RottenTomatoes.BaseURL) > 0 {
+		if u, err := url.Parse(self.RottenTomatoes.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			fail(sectionRottenTomatoes, "base_url", "must be http(s)://host[:port][/path], got '%s'", self.RottenTomatoes.BaseURL)
+		}
+	}
+	if self.RottenTomatoes.RequestTimeout <= 0 {
+		fail(sectionRottenTomatoes, "request_timeout", "must be positive duration, e.g. 10s")
+	}
+	if err := self.BreakerOptions().Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("[%s] %s", sectionRottenTomatoes, err))
+	}
+
+	clients := make(map[string]bool)
+	keys := make(map[string]string)
+	for _, key := range self.Keys() {
+		section := sectionAPIKeyPrefix + key.Client
+		switch {
+		case len(key.Client) == 0:
+			fail(sectionAuth, "keys_file", "client is required for every key")
+			continue
+		case clients[key.Client]:
+			fail(section, "client", "is defined more than once")
+		}
+		clients[key.Client] = true
+
+		if len(key.Key) == 0 {
+			fail(section, "key", "is required")
+		} else if other, exists := keys[key.Key]; exists {
+			fail(section, "key", "is already used by client '%s'", other)
+		} else {
+			keys[key.Key] = key.Client
+		}
+
+		if key.RateLimit < 0 {
+			fail(section, "rate_limit", "cannot be negative, got %v", key.RateLimit)
+		}
+		if key.Burst < 0 {
+			fail(section, "burst", "cannot be negative, got %d", key.Burst)
+		}
+		if key.DailyQuota < 0 {
+			fail(section, "daily_quota", "cannot be negative, got %d", key.DailyQuota)
+		}
+	}
+
+	return errs
+}
+
+// checkFile reports the file which is set but cannot be read.
+func checkFile(fail func(section, key, format string, args ...interface{}), section, key, fileName string) {
+	if len(fileName) == 0 {
+		return
+	}
+	if file, err := os.Open(fileName); err != nil {
+		fail(section, key, "%s", err)
+	} else {
+		file.Close()
+	}
+}
+
+// Keys returns API keys from the config and the keys file.
+func (self *Config) Keys() []rest.APIKey {
+	keys := make([]rest.APIKey, 0, len(self.APIKeys)+len(self.Auth.FileKeys))
+	keys = append(keys, self.APIKeys...)
+	return append(keys, self.Auth.FileKeys...)
+}
+
+func (self *Config) PublishOptions() rest.PublishOptions {
+	return rest.PublishOptions{
+		ExchangeType:       self.RabbitMQ.ExchangeType,
+		ExchangeDeclare:    self.RabbitMQ.ExchangeDeclare,
+		ExchangeDurable:    self.RabbitMQ.ExchangeDurable,
+		ExchangeAutoDelete: self.RabbitMQ.ExchangeAutoDelete,
+		DeliveryMode:       self.RabbitMQ.DeliveryMode,
+		Expiration:         self.RabbitMQ.Expiration,
+		Priority:           self.priority(),
+	}
+}
+
+// priority converts the configured priority without wrapping, the values out of range fail PublishOptions.Validate.
+func (self *Config) priority() uint8 {
+	if self.RabbitMQ.Priority < 0 || self.RabbitMQ.Priority > math.MaxUint8 {
+		return math.MaxUint8
+	}
+	return uint8(self.RabbitMQ.Priority)
+}
+
+func (self *Config) TLSOptions() rest.TLSOptions {
+	return rest.TLSOptions{
+		CertFile:     self.TLS.CertFile,
+		KeyFile:      self.TLS.KeyFile,
+		ClientCAFile: self.TLS.ClientCAFile,
+		ClientAuth:   self.TLS.ClientAuth,
+	}
+}
+
+func (self *Config) MessageQueueTLSOptions() rest.MessageQueueTLSOptions {
+	return rest.MessageQueueTLSOptions{
+		CAFile:     self.RabbitMQ.TLSCAFile,
+		CertFile:   self.RabbitMQ.TLSCertFile,
+		KeyFile:    self.RabbitMQ.TLSKeyFile,
+		ServerName: self.RabbitMQ.TLSServerName,
+	}
+}
+
+func (self *Config) AuditOptions() audit.Options {
+	return audit.Options{
+		FileName:   self.Audit.File,
+		MaxSize:    int64(self.Audit.MaxSizeMB) * 1024 * 1024,
+		MaxBackups: self.Audit.MaxBackups,
+		Redaction: audit.RedactionPolicy{
+			Fields: self.Audit.Redact,
+			Mode:   self.Audit.RedactMode,
+		},
+	}
+}
+
+func (self *Config) LoggingOptions() logging.Options {
+	return logging.Options{
+		Format: self.Logging.Format,
+		Level:  self.Logging.Level,
+		File:   self.Logging.File,
+	}
+}
+
+func (self *Config) TracingOptions() tracing.Options {
+	return tracing.Options{
+		Exporter:    self.Tracing.Exporter,
+		Endpoint:    self.Tracing.Endpoint,
+		Insecure:    self.Tracing.Insecure,
+		ServiceName: self.Tracing.ServiceName,
+		SampleRatio: self.Tracing.SampleRatio,
+	}
+}
+
+func (self *Config) BreakerOptions() rest.BreakerOptions {
+	return rest.BreakerOptions{
+		FailureThreshold: self.RottenTomatoes.BreakerFailures,
+		OpenTimeout:      self.RottenTomatoes.BreakerOpenTimeout,
+		SuccessThreshold: self.RottenTomatoes.BreakerSuccesses,
+	}
+}
+
+func (self *Config) CacheOptions() rest.CacheOptions {
+	return rest.CacheOptions{
+		MaxStale:        self.Cache.MaxStale,
+		MaxEntries:      self.Cache.MaxEntries,
+		RefreshInterval: self.Cache.RefreshInterval,
+	}
+}
+
+func (self *Config) BatchOptions() rest.BatchOptions {
+	return rest.BatchOptions{
+		MaxItems:    self.Batch.MaxItems,
+		Concurrency: self.Batch.Concurrency,
+	}
+}
+
+// Context converts config to the movie server context.
+func (self *Config) Context() rest.MovieServerContext {
+	publishOptions := self.PublishOptions()
+	tlsOptions := self.TLSOptions()
+	messageQueueTLSOptions := self.MessageQueueTLSOptions()
+	auditOptions := self.AuditOptions()
+	breakerOptions := self.BreakerOptions()
+	cacheOptions := self.CacheOptions()
+	batchOptions := self.BatchOptions()
+	healthOptions := rest.HealthOptions{
+		MessageQueueProbeInterval: self.Health.AMQPProbeInterval,
+		UpstreamProbeInterval:     self.Health.UpstreamProbeInterval,
+		MaxPendingJobs:            self.Health.MaxPendingJobs,
+		WorkerStuckTimeout:        self.Health.WorkerStuckTimeout,
+	}
+	shutdownOptions := rest.ShutdownOptions{
+		PreStopDelay:   self.Shutdown.PreStopDelay,
+		RequestTimeout: self.Shutdown.RequestTimeout,
+		DrainTimeout:   self.Shutdown.DrainTimeout,
+		PublishTimeout: self.Shutdown.PublishTimeout,
+	}
+
+	return rest.MovieServerContext{
+		MessageQueueURI: self.RabbitMQ.URI,
+		MessageQueueTLS: &messageQueueTLSOptions,
+		Reliable:        self.RabbitMQ.Reliable,
+		TLS:             &tlsOptions,
+		PublishOptions:  &publishOptions,
+		ValidationOptions: rest.ValidationOptions{
+			AllowedExchanges: self.AllowedExchanges,
+			ProbeExchange:    self.RabbitMQ.ProbeExchange,
+		},
+		APIKeys:              self.Keys(),
+		HealthOptions:        &healthOptions,
+		ShutdownOptions:      &shutdownOptions,
+		JournalFile:          self.Journal.File,
+		CatalogFile:          self.Catalog.File,
+		AuditOptions:         &auditOptions,
+		BreakerOptions:       &breakerOptions,
+		CacheOptions:         &cacheOptions,
+		BatchOptions:         &batchOptions,
+		ServiceURI:           self.Service.URI,
+		Workers:              self.Service.Workers,
+		RottenTomatoesAPIKey: self.RottenTomatoes.APIKey,
+		RottenTomatoesURL:    self.RottenTomatoes.BaseURL,
+		UpstreamTimeout:      self.RottenTomatoes.RequestTimeout,
+	}
+}
+
+// Redacted returns copy of the config with secrets replaced.
+func (self *Config) Redacted() *Config {
+	cfg := *self
+	if len(cfg.RottenTomatoes.APIKey) > 0 {
+		cfg.RottenTomatoes.APIKey = redacted
+	}
+	cfg.RabbitMQ.URI = redactURI(cfg.RabbitMQ.URI)
+	cfg.APIKeys = redactKeys(cfg.APIKeys)
+	cfg.Auth.FileKeys = redactKeys(cfg.Auth.FileKeys)
+	return &cfg
+}
+
+func redactKeys(keys []rest.APIKey) []rest.APIKey {
+	if keys == nil {
+		return nil
+	}
+	redactedKeys := make([]rest.APIKey, len(keys))
+	for i, key := range keys {
+		key.Key = redacted
+		redactedKeys[i] = key
+	}
+	return redactedKeys
+}
+
+func redactURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.User == nil {
+		return uri
+	}
+	if _, hasPassword := u.User.Password(); hasPassword {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	return u.String()
+}
+
+// WriteTo writes config in the ini format.
+func (self *Config) WriteTo(w io.Writer) (int64, error) {
+	file := ini.Empty()
+	set := func(section, key string, value interface{}) {
+		file.Section(section).Key(key).SetValue(fmt.Sprint(value))
+	}
+
+	set(sectionService, "uri", self.Service.URI)
+	set(sectionService, "workers", self.Service.Workers)
+
+	set(sectionTLS, "cert_file", self.TLS.CertFile)
+	set(sectionTLS, "key_file", self.TLS.KeyFile)
+	set(sectionTLS, "client_ca_file", self.TLS.ClientCAFile)
+	set(sectionTLS, "client_auth", self.TLS.ClientAuth)
+
+	set(sectionRabbitMQ, "uri", self.RabbitMQ.URI)
+	set(sectionRabbitMQ, "reliable", self.RabbitMQ.Reliable)
+	set(sectionRabbitMQ, "exchange_type", self.RabbitMQ.ExchangeType)
+	set(sectionRabbitMQ, "exchange_declare", self.RabbitMQ.ExchangeDeclare)
+	set(sectionRabbitMQ, "exchange_durable", self.RabbitMQ.ExchangeDurable)
+	set(sectionRabbitMQ, "exchange_auto_delete", self.RabbitMQ.ExchangeAutoDelete)
+	set(sectionRabbitMQ, "delivery_mode", self.RabbitMQ.DeliveryMode)
+	set(sectionRabbitMQ, "expiration", self.RabbitMQ.Expiration)
+	set(sectionRabbitMQ, "priority", self.RabbitMQ.Priority)
+	set(sectionRabbitMQ, "probe_exchange", self.RabbitMQ.ProbeExchange)
+	set(sectionRabbitMQ, "tls_ca_file", self.RabbitMQ.TLSCAFile)
+	set(sectionRabbitMQ, "tls_cert_file", self.RabbitMQ.TLSCertFile)
+	set(sectionRabbitMQ, "tls_key_file", self.RabbitMQ.TLSKeyFile)
+	set(sectionRabbitMQ, "tls_server_name", self.RabbitMQ.TLSServerName)
+
+	set(sectionHealth, "amqp_probe_interval", self.Health.AMQPProbeInterval)
+	set(sectionHealth, "upstream_probe_interval", self.Health.UpstreamProbeInterval)
+	set(sectionHealth, "max_pending_jobs", self.Health.MaxPendingJobs)
+	set(sectionHealth, "worker_stuck_timeout", self.Health.WorkerStuckTimeout)
+
+	set(sectionShutdown, "pre_stop_delay", self.Shutdown.PreStopDelay)
+	set(sectionShutdown, "request_timeout", self.Shutdown.RequestTimeout)
+	set(sectionShutdown, "drain_timeout", self.Shutdown.DrainTimeout)
+	set(sectionShutdown, "publish_timeout", self.Shutdown.PublishTimeout)
+
+	set(sectionJournal, "file", self.Journal.File)
+
+	set(sectionCatalog, "file", self.Catalog.File)
+
+	set(sectionAudit, "file", self.Audit.File)
+	set(sectionAudit, "max_size_mb", self.Audit.MaxSizeMB)
+	set(sectionAudit, "max_backups", self.Audit.MaxBackups)
+	set(sectionAudit, "redact", strings.Join(self.Audit.Redact, ", "))
+	set(sectionAudit, "redact_mode", self.Audit.RedactMode)
+
+	set(sectionLogging, "format", self.Logging.Format)
+	set(sectionLogging, "level", self.Logging.Level)
+	set(sectionLogging, "file", self.Logging.File)
+
+	set(sectionTracing, "exporter", self.Tracing.Exporter)
+	set(sectionTracing, "endpoint", self.Tracing.Endpoint)
+	set(sectionTracing, "insecure", self.Tracing.Insecure)
+	set(sectionTracing, "service_name", self.Tracing.ServiceName)
+	set(sectionTracing, "sample_ratio", self.Tracing.SampleRatio)
+
+	set(sectionCache, "max_stale", self.Cache.MaxStale)
+	set(sectionCache, "max_entries", self.Cache.MaxEntries)
+	set(sectionCache, "refresh_interval", self.Cache.RefreshInterval)
+
+	set(sectionBatch, "max_items", self.Batch.MaxItems)
+	set(sectionBatch, "concurrency", self.Batch.Concurrency)
+
+	set(sectionRottenTomatoes, "rottentomatoes_api_key", self.RottenTomatoes.APIKey)
+	set(sectionRottenTomatoes, "base_url", self.RottenTomatoes.BaseURL)
+	set(sectionRottenTomatoes, "request_timeout", self.RottenTomatoes.RequestTimeout)
+	set(sectionRottenTomatoes, "breaker_failures", self.RottenTomatoes.BreakerFailures)
+	set(sectionRottenTomatoes, "breaker_open_timeout", self.RottenTomatoes.BreakerOpenTimeout)
+	set(sectionRottenTomatoes, "breaker_successes", self.RottenTomatoes.BreakerSuccesses)
+
+	// clients are sorted, so the same config is always written the same way
+	file.Section(sectionAllowedExchanges)
+	clients := make([]string, 0, len(self.AllowedExchanges))
+	for client := range self.AllowedExchanges {
+		clients = append(clients, client)
+	}
+	sort.Strings(clients)
+	for _, client := range clients {
+		set(sectionAllowedExchanges, client, strings.Join(self.AllowedExchanges[client], ", "))
+	}
+
+	set(sectionAuth, "keys_file", self.Auth.KeysFile)
+
+	// keys from the keys file are not written, they are loaded from the file again
+	for _, key := range self.APIKeys {
+		section := sectionAPIKeyPrefix + key.Client
+		set(section, "key", key.Key)
+		set(section, "rate_limit", key.RateLimit)
+		set(section, "burst", key.Burst)
+		set(section, "daily_quota", key.DailyQuota)
+		set(section, "exchanges", strings.Join(key.AllowedExchanges, ", "))
+		set(section, "routing_keys", strings.Join(key.AllowedRoutingKeys, ", "))
+	}
+
+	return file.WriteTo(w)
+}
+
+// parser reads typed values and collects errors of all keys.
+type parser struct {
+	file *ini.File
+	errs []error
+}
+
+func (self *parser) fail(section, key string, err error) {
+	self.errs = append(self.errs, fmt.Errorf("[%s] %s: %s", section, key, err))
+}
+
+func (self *parser) str(section, key string) string {
+	return self.file.Section(section).Key(key).String()
+}
+
+func (self *parser) list(section, key string) []string {
+	return self.file.Section(section).Key(key).Strings(",")
+}
+
+func (self *parser) boolean(section, key string) bool {
+	v, err := self.file.Section(section).Key(key).Bool()
+	if err != nil {
+		self.fail(section, key, err)
+	}
+	return v
+}
+
+func (self *parser) integer(section, key string) int {
+	v, err := self.file.Section(section).Key(key).Int()
+	if err != nil {
+		self.fail(section, key, err)
+	}
+	return v
+}
+
+func (self *parser) float(section, key string) float64 {
+	v, err := self.file.Section(section).Key(key).Float64()
+	if err != nil {
+		self.fail(section, key, err)
+	}
+	return v
+}
+
+// apiKey reads [api-key.<client>] section, missing limits mean unlimited.
+func (self *parser) apiKey(section *ini.Section) rest.APIKey {
+	name := section.Name()
+	key := rest.APIKey{
+		Client: strings.TrimPrefix(name, sectionAPIKeyPrefix),
+		Key:    self.str(name, "key"),
+	}
+	if section.HasKey("rate_limit") {
+		key.RateLimit = self.float(name, "rate_limit")
+	}
+	if section.HasKey("burst") {
+		key.Burst = self.integer(name, "burst")
+	}
+	if section.HasKey("daily_quota") {
+		key.DailyQuota = self.integer(name, "daily_quota")
+	}
+	if section.HasKey("exchanges") {
+		key.AllowedExchanges = section.Key("exchanges").Strings(",")
+	}
+	if section.HasKey("routing_keys") {
+		key.AllowedRoutingKeys = section.Key("routing_keys").Strings(",")
+	}
+	return key
+}
+
+func (self *parser) duration(section, key string) time.Duration {
+	v, err := self.file.Section(section).Key(key).Duration()
+	if err != nil {
+		self.fail(section, key, err)
+	}
+	return v
+}
+
+func joinErrors(errs []error) string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
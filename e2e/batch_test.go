@@ -0,0 +1,79 @@
+package e2e
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/plar/movie-service/rest"
+)
+
+func TestBatchPublishesEachItem(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	req := rest.Request{RequestId: "batch-1", ExchangeName: "movies", RoutingKey: "search.results"}
+	status := h.Batch(rest.BatchRequest{Request: req, Queries: []string{"Martian", "Unknown Movie"}}, "certified_fresh=true")
+	assert.Equal(t, http.StatusOK, status)
+
+	messages, responses := h.Published(2)
+	if !assert.Equal(t, 2, len(responses)) {
+		return
+	}
+
+	found := make(map[string]rest.SearchResponse)
+	for i, resp := range responses {
+		assert.Equal(t, "search.results", messages[i].RoutingKey)
+		assert.Equal(t, "batch-1", resp.Meta.RequestId)
+		assert.Equal(t, 2, resp.Meta.Batch.Total)
+		found[resp.Meta.Batch.Query] = resp
+	}
+	assert.Equal(t, 0, found["Martian"].Meta.Batch.Index)
+	if assert.Equal(t, 1, len(found["Martian"].Data.Movies)) {
+		assert.Equal(t, "The Martian", found["Martian"].Data.Movies[0].Title)
+	}
+	assert.Equal(t, 1, found["Unknown Movie"].Meta.Batch.Index)
+	assert.Equal(t, rest.SUCCESS, found["Unknown Movie"].Meta.Status)
+	assert.Empty(t, found["Unknown Movie"].Data.Movies)
+}
+
+func TestBatchAggregatesIds(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	req := rest.Request{RequestId: "batch-2", ExchangeName: "movies", RoutingKey: "search.results"}
+	status := h.Batch(rest.BatchRequest{Request: req, Ids: []string{"771380589", "404"}, Publish: rest.PublishAggregate, Concurrency: 1}, "")
+	assert.Equal(t, http.StatusOK, status)
+
+	_, responses := h.Published(1)
+	if !assert.Equal(t, 1, len(responses)) {
+		return
+	}
+	items := responses[0].Data.Items
+	if !assert.Equal(t, 2, len(items)) {
+		return
+	}
+
+	assert.Equal(t, rest.BatchItem{Index: 0, Total: 2, Id: "771380589"}, items[0].BatchItem)
+	assert.Equal(t, rest.SUCCESS, items[0].Meta.Status)
+	if assert.Equal(t, 1, len(items[0].Data.Movies)) {
+		movie := items[0].Data.Movies[0]
+		assert.Equal(t, "The Martian", movie.Title)
+		assert.Equal(t, "PG-13", movie.MpaaRating)
+	}
+
+	assert.Equal(t, rest.BatchItem{Index: 1, Total: 2, Id: "404"}, items[1].BatchItem)
+	assert.Equal(t, rest.ERROR, items[1].Meta.Status)
+	assert.Equal(t, rest.CodeMovieNotFound, items[1].Meta.Code)
+}
+
+func TestBatchRejectsInvalidBody(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	req := rest.Request{RequestId: "batch-3", ExchangeName: "movies", RoutingKey: "search.results"}
+	assert.Equal(t, http.StatusUnprocessableEntity, h.Batch(rest.BatchRequest{Request: req}, ""))
+	assert.Equal(t, http.StatusUnprocessableEntity, h.Batch(rest.BatchRequest{Request: req, Queries: []string{"Martian"}, Publish: "later"}, ""))
+	assert.Equal(t, 0, h.upstream.Requests())
+}
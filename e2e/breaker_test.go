@@ -0,0 +1,49 @@
+package e2e
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/plar/movie-service/rest"
+)
+
+func TestSearchFailsFastWhenCircuitIsOpen(t *testing.T) {
+	h := newHarness(t, func(ctx *rest.MovieServerContext) {
+		ctx.BreakerOptions = &rest.BreakerOptions{FailureThreshold: 2, OpenTimeout: time.Hour, SuccessThreshold: 1}
+	})
+	defer h.Close()
+
+	h.upstream.FailNext(http.StatusInternalServerError, 2)
+	for i, requestId := range []string{"failed-1", "failed-2", "rejected-1"} {
+		assert.Equal(t, http.StatusOK, h.Search(rest.Request{RequestId: requestId, ExchangeName: "movies", RoutingKey: "search.errors"}, "Martian"))
+		h.Published(i + 1)
+	}
+
+	messages, responses := h.Published(3)
+	if !assert.Equal(t, 3, len(messages)) {
+		return
+	}
+	assert.Contains(t, responses[1].Meta.Error, "500")
+	assert.Equal(t, rest.Meta{
+		RequestId: "rejected-1",
+		Status:    rest.ERROR,
+		Code:      rest.CodeUpstreamUnavailable,
+		Error:     "Upstream provider 'rottentomatoes' is unavailable, circuit breaker is open, retry in 1h0m0s",
+	}, responses[2].Meta)
+	assert.Equal(t, 2, h.upstream.Requests())
+
+	resp, err := http.Get(h.httpServer.URL + "/readyz")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	var health rest.HealthResponse
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&health))
+	assert.Equal(t, rest.ERROR, health.Checks["upstream_circuit"].Status)
+}
@@ -0,0 +1,37 @@
+package e2e
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/plar/movie-service/rest"
+)
+
+func TestSearchServesStaleResultsOnUpstreamError(t *testing.T) {
+	h := newHarness(t, func(ctx *rest.MovieServerContext) {
+		ctx.CacheOptions = &rest.CacheOptions{MaxStale: time.Hour, MaxEntries: 10, RefreshInterval: 10 * time.Millisecond}
+	})
+	defer h.Close()
+
+	assert.Equal(t, http.StatusOK, h.Search(rest.Request{RequestId: "fresh-1", ExchangeName: "movies", RoutingKey: "search.results"}, "Martian"))
+	h.Published(1)
+
+	h.upstream.FailNext(http.StatusInternalServerError, 1)
+	assert.Equal(t, http.StatusOK, h.Search(rest.Request{RequestId: "stale-1", ExchangeName: "movies", RoutingKey: "search.results"}, "Martian"))
+
+	_, responses := h.Published(2)
+	if !assert.Equal(t, 2, len(responses)) {
+		return
+	}
+	assert.False(t, responses[0].Meta.Stale)
+	assert.Equal(t, "stale-1", responses[1].Meta.RequestId)
+	assert.Equal(t, rest.SUCCESS, responses[1].Meta.Status)
+	assert.True(t, responses[1].Meta.Stale)
+	assert.Equal(t, responses[0].Data.Movies, responses[1].Data.Movies)
+
+	// the stale query is searched again in the background
+	assert.Eventually(t, func() bool { return h.upstream.Requests() == 3 }, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,60 @@
+package e2e
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/plar/movie-service/rest"
+)
+
+func TestLocalSearchAnswersOffline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "movie-service-catalog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	h := newHarness(t, func(ctx *rest.MovieServerContext) {
+		ctx.CatalogFile = filepath.Join(dir, "catalog.db")
+	})
+	defer h.Close()
+
+	assert.Equal(t, http.StatusOK, h.Search(rest.Request{RequestId: "upstream-1", ExchangeName: "movies", RoutingKey: "search.results"}, "Martian"))
+	h.Published(1)
+
+	// the upstream is down, local searches do not call it
+	h.upstream.SetErrorRate(1)
+	assert.Equal(t, http.StatusOK, h.Search(rest.Request{RequestId: "local-1", ExchangeName: "movies", RoutingKey: "search.results"}, "damon+martian&source=local"))
+	assert.Equal(t, http.StatusOK, h.Search(rest.Request{RequestId: "local-2", ExchangeName: "movies", RoutingKey: "search.results"}, "alien&source=local"))
+
+	_, responses := h.Published(3)
+	if !assert.Equal(t, 3, len(responses)) {
+		return
+	}
+	assert.Equal(t, rest.Meta{RequestId: "local-1", Status: rest.SUCCESS}, responses[1].Meta)
+	if assert.Equal(t, 1, len(responses[1].Data.Movies)) {
+		movie := responses[1].Data.Movies[0]
+		assert.Equal(t, "771380589", movie.Id)
+		assert.Equal(t, "The Martian", movie.Title)
+		assert.Equal(t, 2015, movie.Year)
+		assert.Equal(t, "PG-13", movie.MpaaRating)
+		assert.Equal(t, rest.CastMember{Name: "Matt Damon", Characters: []string{"Mark Watney"}}, movie.Cast[0])
+		assert.Contains(t, movie.Synopsis, "Astronaut Mark Watney")
+		assert.Equal(t, 92, movie.Ratings.CriticsScore)
+	}
+	assert.Equal(t, rest.Meta{RequestId: "local-2", Status: rest.SUCCESS}, responses[2].Meta)
+	assert.Empty(t, responses[2].Data.Movies)
+	assert.Equal(t, 1, h.upstream.Requests())
+}
+
+func TestLocalSearchWithoutCatalog(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	assert.Equal(t, http.StatusServiceUnavailable, h.Search(rest.Request{RequestId: "local-1"}, "martian&source=local"))
+	assert.Equal(t, http.StatusBadRequest, h.Search(rest.Request{RequestId: "remote-1"}, "martian&source=remote"))
+	assert.Equal(t, 0, h.upstream.Requests())
+}
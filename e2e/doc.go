@@ -0,0 +1,3 @@
+// Package e2e tests the path from HTTP request to AMQP publish: the service runs against
+// the fake Rotten Tomatoes API (fakert) and the in-memory broker (fakeamqp).
+package e2e
@@ -0,0 +1,41 @@
+package e2e
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/plar/movie-service/rest"
+)
+
+func TestSearchFiltersAndSortsResults(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	status := h.Search(rest.Request{RequestId: "filtered-1", ExchangeName: "movies", RoutingKey: "search.results"}, "Martian&mpaa=PG,PG-13&year_from=1990&sort=audience_score")
+	assert.Equal(t, http.StatusOK, status)
+
+	_, responses := h.Published(1)
+	if !assert.Equal(t, 1, len(responses)) {
+		return
+	}
+	meta := responses[0].Meta
+	assert.Equal(t, rest.SUCCESS, meta.Status)
+	assert.Equal(t, &rest.SearchFilter{YearFrom: 1990, Mpaa: []string{"PG", "PG-13"}, Sort: rest.SortAudienceScore, Order: rest.OrderDesc}, meta.Filter)
+	assert.Equal(t, 16, meta.Excluded)
+
+	var titles []string
+	for _, movie := range responses[0].Data.Movies {
+		titles = append(titles, movie.Title)
+	}
+	assert.Equal(t, []string{"The Martian", "Rifftrax Live: Santa Claus Conquers The Martians", "Martian Child", "My Favorite Martian", "Martians Go Home"}, titles)
+}
+
+func TestSearchRejectsInvalidFilter(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	assert.Equal(t, http.StatusBadRequest, h.Search(rest.Request{RequestId: "invalid-1"}, "Martian&min_critics_score=high"))
+	assert.Equal(t, 0, h.upstream.Requests())
+}
@@ -0,0 +1,62 @@
+package e2e
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/plar/movie-service/rest"
+)
+
+func TestLookupByImdbId(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	req := rest.Request{RequestId: "lookup-1", ExchangeName: "movies", RoutingKey: "lookup.results"}
+	assert.Equal(t, http.StatusOK, h.Lookup(rest.LookupRequest{Request: req, MovieLookup: rest.MovieLookup{ImdbId: "tt3659388"}}))
+
+	_, responses := h.Published(1)
+	if !assert.Equal(t, 1, len(responses)) {
+		return
+	}
+	assert.Equal(t, rest.SUCCESS, responses[0].Meta.Status)
+	assert.Equal(t, &rest.MovieMatch{By: rest.MatchImdbId, Confidence: 1}, responses[0].Meta.Match)
+	if assert.Equal(t, 1, len(responses[0].Data.Movies)) {
+		movie := responses[0].Data.Movies[0]
+		assert.Equal(t, "771380589", movie.Id)
+		assert.Equal(t, "3659388", movie.ImdbId)
+		assert.Equal(t, 92, movie.Ratings.CriticsScore)
+	}
+}
+
+func TestLookupByTitleAndYear(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	req := rest.Request{RequestId: "lookup-2", ExchangeName: "movies", RoutingKey: "lookup.results"}
+	assert.Equal(t, http.StatusOK, h.Lookup(rest.LookupRequest{Request: req, MovieLookup: rest.MovieLookup{Title: "The Martian", Year: 2015}}))
+	_, responses := h.Published(1)
+	if assert.Equal(t, 1, len(responses)) && assert.Equal(t, 1, len(responses[0].Data.Movies)) {
+		assert.Equal(t, "The Martian", responses[0].Data.Movies[0].Title)
+		assert.Equal(t, &rest.MovieMatch{By: rest.MatchTitle, Confidence: 1}, responses[0].Meta.Match)
+	}
+
+	// the release year of another country is close
+	req.RequestId = "lookup-3"
+	assert.Equal(t, http.StatusOK, h.Lookup(rest.LookupRequest{Request: req, MovieLookup: rest.MovieLookup{Title: "Martian", Year: 2016}}))
+	_, responses = h.Published(2)
+	if assert.Equal(t, 2, len(responses)) && assert.Equal(t, 1, len(responses[1].Data.Movies)) {
+		assert.Equal(t, "lookup-3", responses[1].Meta.RequestId)
+		assert.Equal(t, "The Martian", responses[1].Data.Movies[0].Title)
+		assert.Equal(t, &rest.MovieMatch{By: rest.MatchTitle, Confidence: 0.85}, responses[1].Meta.Match)
+	}
+
+	req.RequestId = "lookup-4"
+	assert.Equal(t, http.StatusOK, h.Lookup(rest.LookupRequest{Request: req, MovieLookup: rest.MovieLookup{ImdbId: "tt0133093"}}))
+	_, responses = h.Published(3)
+	if assert.Equal(t, 3, len(responses)) {
+		assert.Equal(t, rest.ERROR, responses[2].Meta.Status)
+		assert.Equal(t, rest.CodeMovieNotFound, responses[2].Meta.Code)
+	}
+}
@@ -0,0 +1,52 @@
+package e2e
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/plar/movie-service/rest"
+)
+
+func TestSearchRetriesSpellingSuggestions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "movie-service-catalog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	h := newHarness(t, func(ctx *rest.MovieServerContext) {
+		ctx.CatalogFile = filepath.Join(dir, "catalog.db")
+	})
+	defer h.Close()
+
+	assert.Equal(t, http.StatusOK, h.Search(rest.Request{RequestId: "martian-1", ExchangeName: "movies", RoutingKey: "search.results"}, "Martian"))
+	h.Published(1)
+
+	// the upstream has nothing for the misspelled query, the catalog suggests the known term
+	assert.Equal(t, http.StatusOK, h.Search(rest.Request{RequestId: "marshian-1", ExchangeName: "movies", RoutingKey: "search.results"}, "the+marshian"))
+	h.Published(2)
+	assert.Equal(t, http.StatusOK, h.Search(rest.Request{RequestId: "martian-2015", ExchangeName: "movies", RoutingKey: "search.results"}, "Martian+2015"))
+
+	_, responses := h.Published(3)
+	if !assert.Equal(t, 3, len(responses)) {
+		return
+	}
+	assert.Nil(t, responses[0].Meta.Rewrite)
+
+	assert.Equal(t, rest.SUCCESS, responses[1].Meta.Status)
+	assert.Equal(t, &rest.QueryRewrite{Query: "martian", Suggested: true}, responses[1].Meta.Rewrite)
+	assert.Equal(t, 21, len(responses[1].Data.Movies))
+
+	assert.Equal(t, &rest.QueryRewrite{Query: "martian", Year: 2015}, responses[2].Meta.Rewrite)
+	movies := responses[2].Data.Movies
+	if assert.Equal(t, 21, len(movies)) {
+		assert.Equal(t, "The Martian", movies[0].Title)
+		assert.Equal(t, "Martian Land", movies[1].Title)
+		assert.Equal(t, "Martian Land", movies[2].Title)
+		assert.Equal(t, "Martian Child", movies[3].Title)
+	}
+	assert.Equal(t, 4, h.upstream.Requests())
+}
@@ -0,0 +1,94 @@
+package e2e
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/plar/movie-service/rest"
+)
+
+func TestSearchPublishesMovies(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	status := h.Search(rest.Request{RequestId: "martian-1", ExchangeName: "movies", RoutingKey: "search.results"}, "Martian")
+	assert.Equal(t, http.StatusOK, status)
+
+	messages, responses := h.Published(1)
+	if !assert.Equal(t, 1, len(messages)) {
+		return
+	}
+	assert.Equal(t, "movies", messages[0].Exchange)
+	assert.Equal(t, "search.results", messages[0].RoutingKey)
+	assert.Equal(t, "martian-1", messages[0].MessageId)
+	assert.Equal(t, "martian-1", messages[0].CorrelationId)
+	assert.Equal(t, map[string]string{"movies": amqp.ExchangeTopic}, h.broker.Exchanges())
+
+	resp := responses[0]
+	assert.Equal(t, rest.Meta{RequestId: "martian-1", Status: rest.SUCCESS}, resp.Meta)
+	assert.Equal(t, 21, len(resp.Data.Movies))
+	assert.Equal(t, "771380589", resp.Data.Movies[0].Id)
+	assert.Equal(t, "The Martian", resp.Data.Movies[0].Title)
+	assert.Equal(t, 1, h.upstream.Requests())
+}
+
+func TestSearchPublishesEmptyResult(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	status := h.Search(rest.Request{RequestId: "nothing-1", ExchangeName: "movies", RoutingKey: "search.results"}, "xxxxxxxxxxxxxxxxxxxx")
+	assert.Equal(t, http.StatusOK, status)
+
+	messages, responses := h.Published(1)
+	if !assert.Equal(t, 1, len(messages)) {
+		return
+	}
+	assert.Equal(t, "search.results", messages[0].RoutingKey)
+	assert.Equal(t, rest.Meta{RequestId: "nothing-1", Status: rest.SUCCESS}, responses[0].Meta)
+	assert.Equal(t, 0, len(responses[0].Data.Movies))
+}
+
+func TestSearchPublishesUpstreamError(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	h.upstream.FailNext(http.StatusInternalServerError, 1)
+	h.upstream.FailNext(http.StatusForbidden, 1)
+
+	assert.Equal(t, http.StatusOK, h.Search(rest.Request{RequestId: "failed-1", ExchangeName: "movies", RoutingKey: "search.errors"}, "Martian"))
+	assert.Equal(t, http.StatusOK, h.Search(rest.Request{RequestId: "limited-1", ExchangeName: "movies", RoutingKey: "search.errors"}, "Martian"))
+
+	messages, responses := h.Published(2)
+	if !assert.Equal(t, 2, len(messages)) {
+		return
+	}
+
+	// workers may publish in any order
+	errs := make(map[string]string)
+	for i, resp := range responses {
+		assert.Equal(t, "search.errors", messages[i].RoutingKey)
+		assert.Equal(t, rest.ERROR, resp.Meta.Status)
+		assert.Equal(t, 0, len(resp.Data.Movies))
+		errs[resp.Meta.RequestId] = resp.Meta.Error
+	}
+	assert.Equal(t, 2, len(errs))
+	assert.Contains(t, errs["failed-1"]+errs["limited-1"], "500")
+	assert.Contains(t, errs["failed-1"]+errs["limited-1"], "403")
+}
+
+func TestSearchPublishFailure(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	h.broker.DeclareExchange("movies", amqp.ExchangeFanout)
+	assert.Equal(t, http.StatusOK, h.Search(rest.Request{RequestId: "mismatch-1", ExchangeName: "movies", RoutingKey: "search"}, "Martian"))
+
+	// the exchange of another type cannot be declared, nothing is published and the connection is closed
+	_, err := h.broker.WaitForMessages(1, 200*time.Millisecond)
+	assert.Error(t, err)
+	assert.Equal(t, 0, h.broker.OpenConnections())
+}
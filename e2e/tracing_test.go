@@ -0,0 +1,76 @@
+package e2e
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/plar/movie-service/rest"
+)
+
+const (
+	traceId      = "4bf92f3577b34da6a3ce929d0e0e4736"
+	parentSpanId = "00f067aa0ba902b7"
+)
+
+func TestSearchContinuesTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	h := newHarness(t)
+	defer h.Close()
+
+	body, _ := json.Marshal(rest.Request{RequestId: "traced-1", ExchangeName: "movies", RoutingKey: "search.results"})
+	req, _ := http.NewRequest("POST", h.httpServer.URL+"/movies?q=Martian", bytes.NewReader(body))
+	req.Header.Set("traceparent", "00-"+traceId+"-"+parentSpanId+"-01")
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	messages, _ := h.Published(1)
+	if !assert.Equal(t, 1, len(messages)) {
+		return
+	}
+
+	// the publish span ends after the broker confirms the message
+	assert.Eventually(t, func() bool { return len(recorder.Ended()) == 4 }, publishTimeout, 10*time.Millisecond)
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		assert.Equal(t, traceId, span.SpanContext().TraceID().String(), span.Name())
+		spans[span.Name()] = span
+	}
+
+	server := spans["POST /movies"]
+	if !assert.NotNil(t, server) {
+		return
+	}
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind())
+	assert.Equal(t, parentSpanId, server.Parent().SpanID().String())
+	for _, name := range []string{"workerqueue.wait", "upstream.search", "amqp.publish"} {
+		if assert.NotNil(t, spans[name], name) {
+			assert.Equal(t, server.SpanContext().SpanID(), spans[name].Parent().SpanID(), name)
+		}
+	}
+
+	// consumers continue the trace from the publish span
+	traceparent, _ := messages[0].Headers["traceparent"].(string)
+	parts := strings.Split(traceparent, "-")
+	if assert.Equal(t, 4, len(parts), traceparent) {
+		assert.Equal(t, traceId, parts[1])
+		assert.Equal(t, spans["amqp.publish"].SpanContext().SpanID().String(), parts[2])
+	}
+}
@@ -0,0 +1,289 @@
+// Package fakeamqp is an in-memory message broker for tests, it implements rest.Dialer
+// and keeps published messages instead of sending them to RabbitMQ.
+package fakeamqp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	"github.com/plar/movie-service/rest"
+)
+
+// Message is a message published to the broker.
+type Message struct {
+	Exchange   string
+	RoutingKey string
+	amqp.Publishing
+}
+
+// Broker keeps declared exchanges and published messages, failures can be injected while it is used.
+type Broker struct {
+	sync.Mutex
+	exchanges   map[string]string // name -> kind
+	messages    []Message
+	changed     chan struct{} // closed and replaced on every published message
+	connections int
+
+	dialErr    error
+	publishErr error
+	nack       bool
+}
+
+// Dial opens a connection to the broker, it can be used as rest.Dialer.
+func (self *Broker) Dial(uri string, config *tls.Config) (rest.Connection, error) {
+	if _, err := amqp.ParseURI(uri); err != nil {
+		return nil, err
+	}
+
+	self.Lock()
+	defer self.Unlock()
+	if self.dialErr != nil {
+		return nil, self.dialErr
+	}
+	self.connections++
+	return &connection{broker: self}, nil
+}
+
+// DeclareExchange creates the exchange as a pre-provisioned one.
+func (self *Broker) DeclareExchange(name, kind string) {
+	self.Lock()
+	defer self.Unlock()
+	self.exchanges[name] = kind
+}
+
+// Exchanges returns kinds of the declared exchanges by name.
+func (self *Broker) Exchanges() map[string]string {
+	self.Lock()
+	defer self.Unlock()
+	exchanges := make(map[string]string, len(self.exchanges))
+	for name, kind := range self.exchanges {
+		exchanges[name] = kind
+	}
+	return exchanges
+}
+
+// SetDialError makes new connections fail with the error, nil - connections succeed.
+func (self *Broker) SetDialError(err error) {
+	self.Lock()
+	defer self.Unlock()
+	self.dialErr = err
+}
+
+// SetPublishError makes publishes fail with the error, nil - publishes succeed.
+func (self *Broker) SetPublishError(err error) {
+	self.Lock()
+	defer self.Unlock()
+	self.publishErr = err
+}
+
+// SetNack makes the broker reject messages published in confirm mode.
+func (self *Broker) SetNack(nack bool) {
+	self.Lock()
+	defer self.Unlock()
+	self.nack = nack
+}
+
+// OpenConnections returns number of connections which are not closed.
+func (self *Broker) OpenConnections() int {
+	self.Lock()
+	defer self.Unlock()
+	return self.connections
+}
+
+// Messages returns messages published so far.
+func (self *Broker) Messages() []Message {
+	self.Lock()
+	defer self.Unlock()
+	return append([]Message(nil), self.messages...)
+}
+
+// WaitForMessages waits until at least n messages are published.
+func (self *Broker) WaitForMessages(n int, timeout time.Duration) ([]Message, error) {
+	deadline := time.After(timeout)
+	for {
+		self.Lock()
+		messages, changed := append([]Message(nil), self.messages...), self.changed
+		self.Unlock()
+
+		if len(messages) >= n {
+			return messages, nil
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return messages, fmt.Errorf("%d message(s) published in %s, expected %d", len(messages), timeout, n)
+		}
+	}
+}
+
+func (self *Broker) declare(name, kind string, passive bool) error {
+	self.Lock()
+	defer self.Unlock()
+
+	existing, exists := self.exchanges[name]
+	switch {
+	case !exists && passive:
+		return &amqp.Error{Code: amqp.NotFound, Reason: fmt.Sprintf("NOT_FOUND - no exchange '%s' in vhost '/'", name)}
+	case exists && existing != kind:
+		return &amqp.Error{Code: amqp.PreconditionFailed, Reason: fmt.Sprintf("PRECONDITION_FAILED - inequivalent arg 'type' for exchange '%s' in vhost '/': received '%s' but current is '%s'", name, kind, existing)}
+	case !exists:
+		self.exchanges[name] = kind
+	}
+	return nil
+}
+
+func (self *Broker) publish(msg Message) (bool, error) {
+	self.Lock()
+	defer self.Unlock()
+
+	if self.publishErr != nil {
+		return false, self.publishErr
+	}
+	if _, exists := self.exchanges[msg.Exchange]; !exists && len(msg.Exchange) > 0 {
+		return false, &amqp.Error{Code: amqp.NotFound, Reason: fmt.Sprintf("NOT_FOUND - no exchange '%s' in vhost '/'", msg.Exchange)}
+	}
+	if self.nack {
+		return false, nil
+	}
+
+	self.messages = append(self.messages, msg)
+	close(self.changed)
+	self.changed = make(chan struct{})
+	return true, nil
+}
+
+func (self *Broker) disconnect() {
+	self.Lock()
+	defer self.Unlock()
+	self.connections--
+}
+
+type connection struct {
+	sync.Mutex
+	broker *Broker
+	closed bool
+}
+
+func (self *connection) Channel() (rest.Channel, error) {
+	self.Lock()
+	defer self.Unlock()
+	if self.closed {
+		return nil, amqp.ErrClosed
+	}
+	return &channel{conn: self}, nil
+}
+
+func (self *connection) Close() error {
+	self.Lock()
+	defer self.Unlock()
+	if self.closed {
+		return amqp.ErrClosed
+	}
+	self.closed = true
+	self.broker.disconnect()
+	return nil
+}
+
+func (self *connection) isClosed() bool {
+	self.Lock()
+	defer self.Unlock()
+	return self.closed
+}
+
+type channel struct {
+	sync.Mutex
+	conn        *connection
+	closed      bool
+	confirmMode bool
+	deliveryTag uint64
+	confirms    []chan amqp.Confirmation
+}
+
+func (self *channel) check() error {
+	if self.closed || self.conn.isClosed() {
+		return amqp.ErrClosed
+	}
+	return nil
+}
+
+func (self *channel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	self.Lock()
+	defer self.Unlock()
+	if err := self.check(); err != nil {
+		return err
+	}
+	return self.conn.broker.declare(name, kind, false)
+}
+
+func (self *channel) ExchangeDeclarePassive(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	self.Lock()
+	defer self.Unlock()
+	if err := self.check(); err != nil {
+		return err
+	}
+	return self.conn.broker.declare(name, kind, true)
+}
+
+func (self *channel) Confirm(noWait bool) error {
+	self.Lock()
+	defer self.Unlock()
+	if err := self.check(); err != nil {
+		return err
+	}
+	self.confirmMode = true
+	return nil
+}
+
+func (self *channel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	self.Lock()
+	defer self.Unlock()
+	self.confirms = append(self.confirms, confirm)
+	return confirm
+}
+
+func (self *channel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	self.Lock()
+	defer self.Unlock()
+	if err := self.check(); err != nil {
+		return err
+	}
+
+	ack, err := self.conn.broker.publish(Message{Exchange: exchange, RoutingKey: key, Publishing: msg})
+	if err != nil {
+		return err
+	}
+
+	if self.confirmMode {
+		self.deliveryTag++
+		for _, confirm := range self.confirms {
+			select {
+			case confirm <- amqp.Confirmation{DeliveryTag: self.deliveryTag, Ack: ack}:
+			default:
+				// the real library blocks here, tests must read confirms they asked for
+			}
+		}
+	}
+	return nil
+}
+
+func (self *channel) Close() error {
+	self.Lock()
+	defer self.Unlock()
+	if self.closed {
+		return amqp.ErrClosed
+	}
+	self.closed = true
+	return nil
+}
+
+func New() *Broker {
+	return &Broker{
+		exchanges: make(map[string]string),
+		changed:   make(chan struct{}),
+	}
+}
@@ -0,0 +1,290 @@
+// Package fakert serves Rotten Tomatoes API v1.0 responses from fixture files,
+// so the service can run and be tested without an API key and network access.
+package fakert
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	APIPrefix = "/api/public/v1.0"
+
+	// Fixture files: movies-<query>.json, movie-<id>.json, movie-<id>-cast.json and movie-<id>-reviews.json.
+	// Unknown search queries get movies-empty.json, the IMDb aliases are matched with alternate_ids of movie-<id>.json.
+	emptySearchFixture = "movies-empty.json"
+
+	// error messages of the real API
+	errInvalidKey  = "Account Inactive"
+	errOverQPS     = "Account Over Queries Per Second Limit"
+	errMovieLookup = "Could not find a movie with the specified id"
+	errAliasType   = "Alias type must be imdb"
+	errInternal    = "Internal Server Error"
+)
+
+type Options struct {
+	FixturesDir string
+
+	// Required API key, empty - any key is accepted
+	APIKey string
+
+	// Delay of every response
+	Latency time.Duration
+
+	// Share of requests answered with 500, 0..1
+	ErrorRate float64
+
+	// Requests per second, exceeded requests are answered with 403 as the real API does, 0 - unlimited
+	RateLimit int
+}
+
+// Server is a fake Rotten Tomatoes API, options can be changed while it is running.
+type Server struct {
+	sync.Mutex
+	options  Options
+	random   *rand.Rand
+	failures []int // statuses of the next responses
+
+	// requests in the current second
+	window      time.Time
+	windowCount int
+
+	requests int64
+	router   *mux.Router
+}
+
+func (self *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	self.router.ServeHTTP(w, r)
+}
+
+func (self *Server) SetLatency(latency time.Duration) {
+	self.Lock()
+	defer self.Unlock()
+	self.options.Latency = latency
+}
+
+func (self *Server) SetErrorRate(rate float64) {
+	self.Lock()
+	defer self.Unlock()
+	self.options.ErrorRate = rate
+}
+
+func (self *Server) SetRateLimit(limit int) {
+	self.Lock()
+	defer self.Unlock()
+	self.options.RateLimit = limit
+}
+
+// FailNext answers the next count requests with the status, e.g. 500 or 403 for the rate limit.
+func (self *Server) FailNext(status, count int) {
+	self.Lock()
+	defer self.Unlock()
+	for i := 0; i < count; i++ {
+		self.failures = append(self.failures, status)
+	}
+}
+
+// Requests returns number of API requests received by the server.
+func (self *Server) Requests() int {
+	return int(atomic.LoadInt64(&self.requests))
+}
+
+// fault returns the status and error of the injected failure, 0 - no failure.
+func (self *Server) fault(now time.Time) (int, string) {
+	self.Lock()
+	defer self.Unlock()
+
+	if len(self.failures) > 0 {
+		status := self.failures[0]
+		self.failures = self.failures[1:]
+		if status == http.StatusForbidden {
+			return status, errOverQPS
+		}
+		return status, http.StatusText(status)
+	}
+
+	if self.options.RateLimit > 0 {
+		if now.Sub(self.window) >= time.Second {
+			self.window, self.windowCount = now, 0
+		}
+		self.windowCount++
+		if self.windowCount > self.options.RateLimit {
+			return http.StatusForbidden, errOverQPS
+		}
+	}
+
+	if self.options.ErrorRate > 0 && self.random.Float64() < self.options.ErrorRate {
+		return http.StatusInternalServerError, errInternal
+	}
+	return 0, ""
+}
+
+func (self *Server) settings() Options {
+	self.Lock()
+	defer self.Unlock()
+	return self.options
+}
+
+// middleware checks API key, waits for the latency and injects failures.
+func (self *Server) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&self.requests, 1)
+		opts := self.settings()
+
+		if opts.Latency > 0 {
+			select {
+			case <-time.After(opts.Latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if len(opts.APIKey) > 0 && r.URL.Query().Get("apikey") != opts.APIKey {
+			writeError(w, http.StatusForbidden, errInvalidKey)
+			return
+		}
+
+		if status, msg := self.fault(time.Now()); status != 0 {
+			if status == http.StatusForbidden {
+				w.Header().Set("X-Mashery-Error-Code", "ERR_403_DEVELOPER_OVER_QPS")
+			}
+			writeError(w, status, msg)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (self *Server) ping(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"links": map[string]string{
+			"movies": "http://" + r.Host + APIPrefix + "/movies.json",
+		},
+	})
+}
+
+func (self *Server) search(w http.ResponseWriter, r *http.Request) {
+	name := "movies-" + fixtureName(r.URL.Query().Get("q")) + ".json"
+	if !self.serveFixture(w, name) {
+		self.serveFixture(w, emptySearchFixture)
+	}
+}
+
+func (self *Server) movie(suffix string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := "movie-" + fixtureName(mux.Vars(r)["id"]) + suffix + ".json"
+		if !self.serveFixture(w, name) {
+			writeError(w, http.StatusNotFound, errMovieLookup)
+		}
+	}
+}
+
+// alias serves the movie info fixture with the IMDb id in its alternate_ids, "tt" prefix of the id is optional.
+func (self *Server) alias(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("type") != "imdb" {
+		writeError(w, http.StatusBadRequest, errAliasType)
+		return
+	}
+	id := strings.TrimPrefix(strings.ToLower(r.URL.Query().Get("id")), "tt")
+
+	names, _ := filepath.Glob(filepath.Join(self.settings().FixturesDir, "movie-*.json"))
+	for _, name := range names {
+		if len(id) == 0 || strings.HasSuffix(name, "-cast.json") || strings.HasSuffix(name, "-reviews.json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		var movie struct {
+			AlternateIds struct {
+				Imdb string `json:"imdb"`
+			} `json:"alternate_ids"`
+		}
+		if json.Unmarshal(data, &movie) == nil && movie.AlternateIds.Imdb == id {
+			self.serveFixture(w, filepath.Base(name))
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, errMovieLookup)
+}
+
+// serveFixture writes the fixture file, false if there is no such file.
+func (self *Server) serveFixture(w http.ResponseWriter, name string) bool {
+	data, err := ioutil.ReadFile(filepath.Join(self.settings().FixturesDir, name))
+	if os.IsNotExist(err) {
+		return false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Cannot read fixture %s: %s", name, err))
+		return true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+	return true
+}
+
+// fixtureName turns query or id into a safe part of the file name, "The Martian" -> "the-martian".
+func fixtureName(value string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, strings.TrimSpace(value))
+	return strings.Trim(name, "-")
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func New(options Options) *Server {
+	server := &Server{
+		options: options,
+		random:  rand.New(rand.NewSource(time.Now().UnixNano())),
+		router:  mux.NewRouter(),
+	}
+
+	// ping URL is the prefix itself with .json, it does not belong to the subrouter
+	server.router.Handle(APIPrefix+".json", server.middleware(http.HandlerFunc(server.ping))).Methods("GET")
+
+	api := server.router.PathPrefix(APIPrefix + "/").Subrouter()
+	api.Use(server.middleware)
+	api.HandleFunc("/movies.json", server.search).Methods("GET")
+	api.HandleFunc("/movies/{id}.json", server.movie("")).Methods("GET")
+	api.HandleFunc("/movies/{id}/cast.json", server.movie("-cast")).Methods("GET")
+	api.HandleFunc("/movies/{id}/reviews.json", server.movie("-reviews")).Methods("GET")
+	api.HandleFunc("/movie_alias.json", server.alias).Methods("GET")
+
+	return server
+}
+
+// NewTestServer starts the fake API on a local port, the caller closes the returned server.
+func NewTestServer(options Options) (*Server, *httptest.Server) {
+	server := New(options)
+	return server, httptest.NewServer(server)
+}
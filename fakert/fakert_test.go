@@ -0,0 +1,135 @@
+package fakert
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func get(t *testing.T, url string) (int, map[string]interface{}) {
+	resp, err := http.Get(url)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	var body map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	return resp.StatusCode, body
+}
+
+func TestFixtureName(t *testing.T) {
+	assert.Equal(t, "the-martian", fixtureName(" The Martian "))
+	assert.Equal(t, "771380589", fixtureName("771380589"))
+	assert.Equal(t, "etc-passwd", fixtureName("../etc/passwd"))
+}
+
+func TestServeFixtures(t *testing.T) {
+	fake, server := NewTestServer(Options{FixturesDir: "../fixtures", APIKey: "APIKEY"})
+	defer server.Close()
+	api := server.URL + APIPrefix
+
+	status, body := get(t, api+".json?apikey=APIKEY")
+	assert.Equal(t, http.StatusOK, status)
+	assert.NotNil(t, body["links"])
+
+	status, body = get(t, api+"/movies.json?q=Martian&apikey=APIKEY")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 21, len(body["movies"].([]interface{})))
+
+	status, body = get(t, api+"/movies.json?q=unknown&apikey=APIKEY")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 0, len(body["movies"].([]interface{})))
+
+	status, body = get(t, api+"/movies/771380589.json?apikey=APIKEY")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "The Martian", body["title"])
+
+	status, _ = get(t, api+"/movies/771380589/cast.json?apikey=APIKEY")
+	assert.Equal(t, http.StatusOK, status)
+	status, _ = get(t, api+"/movies/771380589/reviews.json?apikey=APIKEY")
+	assert.Equal(t, http.StatusOK, status)
+
+	status, body = get(t, api+"/movies/1.json?apikey=APIKEY")
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, errMovieLookup, body["error"])
+
+	status, body = get(t, api+"/movie_alias.json?type=imdb&id=tt3659388&apikey=APIKEY")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "The Martian", body["title"])
+
+	status, body = get(t, api+"/movie_alias.json?type=imdb&id=0133093&apikey=APIKEY")
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, errMovieLookup, body["error"])
+
+	status, body = get(t, api+"/movie_alias.json?type=tmdb&id=286217&apikey=APIKEY")
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, errAliasType, body["error"])
+
+	status, body = get(t, api+"/movies.json?q=Martian&apikey=WRONG")
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, errInvalidKey, body["error"])
+
+	assert.Equal(t, 11, fake.Requests())
+}
+
+func TestInjectedFailures(t *testing.T) {
+	fake, server := NewTestServer(Options{FixturesDir: "../fixtures"})
+	defer server.Close()
+	url := server.URL + APIPrefix + "/movies.json?q=Martian"
+
+	fake.FailNext(http.StatusServiceUnavailable, 2)
+	status, _ := get(t, url)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	status, _ = get(t, url)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	status, _ = get(t, url)
+	assert.Equal(t, http.StatusOK, status)
+
+	fake.SetErrorRate(1)
+	status, body := get(t, url)
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Equal(t, errInternal, body["error"])
+	fake.SetErrorRate(0)
+
+	fake.SetLatency(50 * time.Millisecond)
+	start := time.Now()
+	status, _ = get(t, url)
+	assert.Equal(t, http.StatusOK, status)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	fake.SetLatency(0)
+}
+
+func TestRateLimit(t *testing.T) {
+	fake, server := NewTestServer(Options{FixturesDir: "../fixtures", RateLimit: 2})
+	defer server.Close()
+	url := server.URL + APIPrefix + "/movies.json?q=Martian"
+
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		status, _ := fake.fault(now)
+		assert.Equal(t, 0, status)
+	}
+	status, msg := fake.fault(now)
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, errOverQPS, msg)
+
+	// the next second opens a new window
+	status, _ = fake.fault(now.Add(time.Second))
+	assert.Equal(t, 0, status)
+
+	fake.SetRateLimit(0)
+	resp, err := http.Get(url)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	fake.SetRateLimit(1)
+	fake.FailNext(http.StatusForbidden, 1)
+	resp, err = http.Get(url)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, "ERR_403_DEVELOPER_OVER_QPS", resp.Header.Get("X-Mashery-Error-Code"))
+}
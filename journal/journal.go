@@ -0,0 +1,181 @@
+package journal
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	log "github.com/plar/movie-service/logging"
+)
+
+var (
+	jobsBucket = []byte("jobs")
+
+	// entries which cannot be decoded are moved here, so they do not block the replay of the others
+	corruptBucket = []byte("corrupt")
+
+	ErrNotFound = errors.New("journal entry not found")
+)
+
+// Entry is an accepted job which is not completed yet.
+type Entry struct {
+	Id       uint64
+	Accepted time.Time
+	Attempts int // how many times the entry was replayed
+	Data     []byte
+}
+
+// Journal keeps accepted jobs on disk until they are completed.
+type Journal interface {
+	// Append stores the job and returns its id, the data is synced to disk before returning.
+	Append(data []byte) (uint64, error)
+
+	// Complete removes the job from the journal.
+	Complete(id uint64) error
+
+	// Replay returns incomplete jobs in the order they were accepted and counts the replay attempt.
+	// Entries which cannot be decoded are moved aside and skipped.
+	Replay() ([]Entry, error)
+
+	Close() error
+}
+
+type record struct {
+	Accepted time.Time       `json:"accepted"`
+	Attempts int             `json:"attempts"`
+	Data     json.RawMessage `json:"data"`
+}
+
+type boltJournal struct {
+	db  *bolt.DB
+	now func() time.Time
+}
+
+func (self *boltJournal) Append(data []byte) (uint64, error) {
+	var id uint64
+	err := self.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+
+		var err error
+		id, err = bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		value, err := json.Marshal(record{Accepted: self.now(), Data: data})
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key(id), value)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (self *boltJournal) Complete(id uint64) error {
+	return self.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+		if bucket.Get(key(id)) == nil {
+			return ErrNotFound
+		}
+		return bucket.Delete(key(id))
+	})
+}
+
+func (self *boltJournal) Replay() ([]Entry, error) {
+	var entries []Entry
+	err := self.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+
+		// bucket cannot be modified while iterating over it
+		var keys, values, corruptKeys, corruptValues [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			var rec record
+			if err := json.Unmarshal(v, &rec); err != nil {
+				log.Errorf("Cannot decode journal entry %d, skip it, error=%s", binary.BigEndian.Uint64(k), err)
+				corruptKeys = append(corruptKeys, append([]byte(nil), k...))
+				corruptValues = append(corruptValues, append([]byte(nil), v...))
+				return nil
+			}
+
+			rec.Attempts++
+			value, err := json.Marshal(rec)
+			if err != nil {
+				return err
+			}
+
+			keys, values = append(keys, append([]byte(nil), k...)), append(values, value)
+			entries = append(entries, Entry{
+				Id:       binary.BigEndian.Uint64(k),
+				Accepted: rec.Accepted,
+				Attempts: rec.Attempts,
+				Data:     []byte(rec.Data),
+			})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for i := range keys {
+			if err := bucket.Put(keys[i], values[i]); err != nil {
+				return err
+			}
+		}
+
+		corrupt := tx.Bucket(corruptBucket)
+		for i := range corruptKeys {
+			if err := corrupt.Put(corruptKeys[i], corruptValues[i]); err != nil {
+				return err
+			}
+			if err := bucket.Delete(corruptKeys[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (self *boltJournal) Close() error {
+	return self.db.Close()
+}
+
+// key keeps entries sorted by id.
+func key(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+	return k
+}
+
+// Open opens or creates the journal file.
+func Open(fileName string) (Journal, error) {
+	db, err := bolt.Open(fileName, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open journal %s: %s", fileName, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{jobsBucket, corruptBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Cannot initialize journal %s: %s", fileName, err)
+	}
+
+	return &boltJournal{db: db, now: time.Now}, nil
+}
@@ -0,0 +1,104 @@
+package journal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+func tempJournalFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "movie-service-journal")
+	assert.NoError(t, err)
+	return filepath.Join(dir, "jobs.db"), func() { os.RemoveAll(dir) }
+}
+
+func TestOpenInvalidFile(t *testing.T) {
+	_, err := Open("/not-existing-dir/jobs.db")
+	assert.Error(t, err)
+}
+
+func TestAppendCompleteReplay(t *testing.T) {
+	fileName, cleanup := tempJournalFile(t)
+	defer cleanup()
+
+	journal, err := Open(fileName)
+	assert.NoError(t, err)
+
+	id1, err := journal.Append([]byte(`{"query":"martian"}`))
+	assert.NoError(t, err)
+	id2, err := journal.Append([]byte(`{"query":"alien"}`))
+	assert.NoError(t, err)
+	id3, err := journal.Append([]byte(`{"query":"matrix"}`))
+	assert.NoError(t, err)
+	assert.True(t, id1 < id2 && id2 < id3)
+
+	assert.NoError(t, journal.Complete(id2))
+	assert.Equal(t, ErrNotFound, journal.Complete(id2))
+	assert.NoError(t, journal.Close())
+
+	// incomplete jobs survive restart
+	journal, err = Open(fileName)
+	assert.NoError(t, err)
+	defer journal.Close()
+
+	entries, err := journal.Replay()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, id1, entries[0].Id)
+	assert.Equal(t, `{"query":"martian"}`, string(entries[0].Data))
+	assert.Equal(t, 1, entries[0].Attempts)
+	assert.False(t, entries[0].Accepted.IsZero())
+	assert.Equal(t, id3, entries[1].Id)
+
+	entries, err = journal.Replay()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, entries[1].Attempts)
+
+	// ids are not reused
+	id4, err := journal.Append([]byte(`{"query":"heat"}`))
+	assert.NoError(t, err)
+	assert.True(t, id4 > id3)
+}
+
+func TestReplaySkipsCorruptEntry(t *testing.T) {
+	fileName, cleanup := tempJournalFile(t)
+	defer cleanup()
+
+	journal, err := Open(fileName)
+	assert.NoError(t, err)
+	defer journal.Close()
+
+	id1, err := journal.Append([]byte(`{"query":"martian"}`))
+	assert.NoError(t, err)
+	id2, err := journal.Append([]byte(`{"query":"alien"}`))
+	assert.NoError(t, err)
+	id3, err := journal.Append([]byte(`{"query":"matrix"}`))
+	assert.NoError(t, err)
+
+	db := journal.(*boltJournal).db
+	assert.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Put(key(id2), []byte("{not json"))
+	}))
+
+	// the corrupt entry does not block the others
+	for attempt := 1; attempt <= 2; attempt++ {
+		entries, err := journal.Replay()
+		assert.NoError(t, err)
+		if assert.Equal(t, 2, len(entries)) {
+			assert.Equal(t, id1, entries[0].Id)
+			assert.Equal(t, id3, entries[1].Id)
+			assert.Equal(t, attempt, entries[1].Attempts)
+		}
+	}
+
+	// it is kept aside for inspection
+	assert.NoError(t, db.View(func(tx *bolt.Tx) error {
+		assert.Nil(t, tx.Bucket(jobsBucket).Get(key(id2)))
+		assert.Equal(t, "{not json", string(tx.Bucket(corruptBucket).Get(key(id2))))
+		return nil
+	}))
+}
@@ -0,0 +1,208 @@
+// Package logging writes log lines with request-scoped fields either to seelog, configured by log.xml,
+// or as JSON objects, one per line. Package functions log without fields, so it can replace seelog imports:
+//
+//	logger := log.With(log.Fields{log.FieldRequestId: req.RequestId, log.FieldQuery: query})
+//	logger.Errorf("Cannot publish message, error=%s", err)
+package logging
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Fields attached to every line of the logger, keys of the well-known ones go first.
+const (
+	FieldRequestId  = "request_id"
+	FieldClient     = "client"
+	FieldQuery      = "query"
+	FieldWorkerId   = "worker_id"
+	FieldExchange   = "exchange"
+	FieldRoutingKey = "routing_key"
+	FieldLatency    = "latency_ms"
+)
+
+var fieldOrder = map[string]int{
+	FieldRequestId:  1,
+	FieldClient:     2,
+	FieldQuery:      3,
+	FieldWorkerId:   4,
+	FieldExchange:   5,
+	FieldRoutingKey: 6,
+	FieldLatency:    7,
+}
+
+type Level int
+
+const (
+	TraceLvl Level = iota
+	DebugLvl
+	InfoLvl
+	WarnLvl
+	ErrorLvl
+	CriticalLvl
+)
+
+var levelNames = []string{"trace", "debug", "info", "warn", "error", "critical"}
+
+func (self Level) String() string {
+	if self < TraceLvl || self > CriticalLvl {
+		return fmt.Sprintf("level(%d)", int(self))
+	}
+	return levelNames[self]
+}
+
+// ParseLevel returns level by its seelog name, e.g. "info".
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if levelName == name {
+			return Level(i), nil
+		}
+	}
+	return InfoLvl, fmt.Errorf("Unknown log level '%s'", name)
+}
+
+type Fields map[string]interface{}
+
+// keys returns field names, well-known ones in the fixed order and the others sorted.
+func (self Fields) keys() []string {
+	keys := make([]string, 0, len(self))
+	for key := range self {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		oi, oj := fieldOrder[keys[i]], fieldOrder[keys[j]]
+		switch {
+		case oi > 0 && oj > 0:
+			return oi < oj
+		case oi > 0 || oj > 0:
+			return oi > 0
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// text formats fields as ", key=value, key=value".
+func (self Fields) text() string {
+	var b strings.Builder
+	for _, key := range self.keys() {
+		fmt.Fprintf(&b, ", %s=%v", key, self[key])
+	}
+	return b.String()
+}
+
+type Logger interface {
+	// With returns logger which adds the fields to the fields of this one.
+	With(fields Fields) Logger
+
+	Tracef(format string, args ...interface{})
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	Criticalf(format string, args ...interface{})
+}
+
+// output writes formatted lines, all entry points must reach write through the same
+// number of calls, so seelog reports file and line of the caller.
+type output interface {
+	write(level Level, msg string, fields Fields)
+	flush()
+	close() error
+}
+
+var (
+	outputLock sync.RWMutex
+	current    output = seelogOutput{}
+
+	root = &logger{}
+)
+
+func currentOutput() output {
+	outputLock.RLock()
+	defer outputLock.RUnlock()
+	return current
+}
+
+type logger struct {
+	fields Fields
+}
+
+func (self *logger) With(fields Fields) Logger {
+	merged := make(Fields, len(self.fields)+len(fields))
+	for key, value := range self.fields {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+	return &logger{fields: merged}
+}
+
+func (self *logger) logf(level Level, format string, args []interface{}) {
+	currentOutput().write(level, fmt.Sprintf(format, args...), self.fields)
+}
+
+func (self *logger) Tracef(format string, args ...interface{}) {
+	self.logf(TraceLvl, format, args)
+}
+
+func (self *logger) Debugf(format string, args ...interface{}) {
+	self.logf(DebugLvl, format, args)
+}
+
+func (self *logger) Infof(format string, args ...interface{}) {
+	self.logf(InfoLvl, format, args)
+}
+
+func (self *logger) Warnf(format string, args ...interface{}) {
+	self.logf(WarnLvl, format, args)
+}
+
+func (self *logger) Errorf(format string, args ...interface{}) {
+	self.logf(ErrorLvl, format, args)
+}
+
+func (self *logger) Criticalf(format string, args ...interface{}) {
+	self.logf(CriticalLvl, format, args)
+}
+
+// With returns logger with the fields.
+func With(fields Fields) Logger {
+	return root.With(fields)
+}
+
+func Tracef(format string, args ...interface{}) {
+	root.logf(TraceLvl, format, args)
+}
+
+func Debugf(format string, args ...interface{}) {
+	root.logf(DebugLvl, format, args)
+}
+
+func Infof(format string, args ...interface{}) {
+	root.logf(InfoLvl, format, args)
+}
+
+func Warnf(format string, args ...interface{}) {
+	root.logf(WarnLvl, format, args)
+}
+
+func Errorf(format string, args ...interface{}) {
+	root.logf(ErrorLvl, format, args)
+}
+
+func Criticalf(format string, args ...interface{}) {
+	root.logf(CriticalLvl, format, args)
+}
+
+func Error(args ...interface{}) {
+	root.logf(ErrorLvl, "%s", []interface{}{fmt.Sprint(args...)})
+}
+
+// Flush writes buffered lines, it must be called before exit.
+func Flush() {
+	currentOutput().flush()
+}
@@ -0,0 +1,33 @@
+package logging
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFieldsText(t *testing.T) {
+	fields := Fields{"journal_id": 7, FieldWorkerId: 3, FieldRequestId: "r1", "attempt": 2, FieldQuery: "martian"}
+	assert.Equal(t, []string{FieldRequestId, FieldQuery, FieldWorkerId, "attempt", "journal_id"}, fields.keys())
+	assert.Equal(t, ", request_id=r1, query=martian, worker_id=3, attempt=2, journal_id=7", fields.text())
+	assert.Equal(t, "", Fields{}.text())
+}
+
+func TestWith(t *testing.T) {
+	requestLogger := With(Fields{FieldRequestId: "r1", FieldQuery: "martian"})
+	workerLogger := requestLogger.With(Fields{FieldWorkerId: 3, FieldQuery: "alien"})
+
+	assert.Equal(t, Fields{FieldRequestId: "r1", FieldQuery: "martian"}, requestLogger.(*logger).fields)
+	assert.Equal(t, Fields{FieldRequestId: "r1", FieldQuery: "alien", FieldWorkerId: 3}, workerLogger.(*logger).fields)
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("warn")
+	assert.NoError(t, err)
+	assert.Equal(t, WarnLvl, level)
+	assert.Equal(t, "warn", level.String())
+
+	_, err = ParseLevel("verbose")
+	assert.EqualError(t, err, "Unknown log level 'verbose'")
+	assert.Equal(t, "level(42)", Level(42).String())
+}
@@ -0,0 +1,164 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cihub/seelog"
+)
+
+const (
+	FormatSeelog = "seelog" // lines go to seelog, outputs and formats are configured by log.xml
+	FormatJSON   = "json"   // one JSON object per line
+
+	// calls between the caller and seelog: entry point, logf, write
+	seelogStackDepth = 3
+)
+
+// Options select the output, Level and File are used by the JSON output only.
+type Options struct {
+	Format string
+	Level  string
+	File   string // empty - stdout
+}
+
+func (self Options) Validate() error {
+	switch self.Format {
+	case FormatSeelog:
+	case FormatJSON:
+		if _, err := ParseLevel(self.Level); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Unknown log format '%s'", self.Format)
+	}
+	return nil
+}
+
+// seelogOutput appends fields to the message: "Cannot publish message, request_id=r1, worker_id=3".
+type seelogOutput struct {
+}
+
+func (self seelogOutput) write(level Level, msg string, fields Fields) {
+	msg += fields.text()
+	switch level {
+	case TraceLvl:
+		seelog.Trace(msg)
+	case DebugLvl:
+		seelog.Debug(msg)
+	case InfoLvl:
+		seelog.Info(msg)
+	case WarnLvl:
+		seelog.Warn(msg)
+	case ErrorLvl:
+		seelog.Error(msg)
+	default:
+		seelog.Critical(msg)
+	}
+}
+
+func (self seelogOutput) flush() {
+	seelog.Flush()
+}
+
+func (self seelogOutput) close() error {
+	return nil
+}
+
+// jsonOutput writes {"time":"...","level":"info","msg":"...",<fields>} lines.
+type jsonOutput struct {
+	sync.Mutex
+	w        io.Writer
+	minLevel Level
+	now      func() time.Time
+}
+
+func (self *jsonOutput) write(level Level, msg string, fields Fields) {
+	if level < self.minLevel {
+		return
+	}
+
+	line := make(map[string]interface{}, len(fields)+3)
+	for key, value := range fields {
+		line[key] = value
+	}
+	line["time"] = self.now().Format(time.RFC3339Nano)
+	line["level"] = level.String()
+	line["msg"] = msg
+
+	data, err := json.Marshal(line)
+	if err != nil {
+		data, _ = json.Marshal(map[string]string{"time": line["time"].(string), "level": level.String(), "msg": msg, "error": err.Error()})
+	}
+
+	self.Lock()
+	defer self.Unlock()
+	self.w.Write(append(data, '\n'))
+}
+
+func (self *jsonOutput) flush() {
+	if file, ok := self.w.(*os.File); ok {
+		file.Sync()
+	}
+}
+
+func (self *jsonOutput) close() error {
+	if file, ok := self.w.(*os.File); ok && file != os.Stdout && file != os.Stderr {
+		return file.Close()
+	}
+	return nil
+}
+
+func newJSONOutput(w io.Writer, minLevel Level) *jsonOutput {
+	return &jsonOutput{w: w, minLevel: minLevel, now: time.Now}
+}
+
+// Configure switches the output, the previous output is flushed and closed.
+func Configure(options Options) error {
+	if err := options.Validate(); err != nil {
+		return err
+	}
+
+	var next output = seelogOutput{}
+	if options.Format == FormatJSON {
+		level, _ := ParseLevel(options.Level)
+		w := os.Stdout
+		if len(options.File) > 0 {
+			var err error
+			w, err = os.OpenFile(options.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return fmt.Errorf("Cannot open log file %s: %s", options.File, err)
+			}
+		}
+		next = newJSONOutput(w, level)
+	}
+
+	setOutput(next)
+	return nil
+}
+
+func setOutput(next output) {
+	outputLock.Lock()
+	previous := current
+	current = next
+	outputLock.Unlock()
+
+	previous.flush()
+	previous.close()
+}
+
+// ReplaceSeelog replaces the seelog logger used by the seelog output, e.g. the one loaded from log.xml.
+func ReplaceSeelog(logger seelog.LoggerInterface) error {
+	if err := logger.SetAdditionalStackDepth(seelogStackDepth); err != nil {
+		return err
+	}
+	return seelog.ReplaceLogger(logger)
+}
+
+func init() {
+	seelog.Current.SetAdditionalStackDepth(seelogStackDepth)
+}
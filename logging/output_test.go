@@ -0,0 +1,95 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cihub/seelog"
+	"github.com/stretchr/testify/assert"
+)
+
+// useOutput switches output for the test and returns function which restores the previous one.
+func useOutput(next output) func() {
+	outputLock.Lock()
+	previous := current
+	current = next
+	outputLock.Unlock()
+	return func() {
+		outputLock.Lock()
+		current = previous
+		outputLock.Unlock()
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	var out bytes.Buffer
+	jsonOut := newJSONOutput(&out, InfoLvl)
+	jsonOut.now = func() time.Time { return time.Date(2015, 10, 2, 12, 0, 0, 0, time.UTC) }
+	defer useOutput(jsonOut)()
+
+	logger := With(Fields{FieldRequestId: "r1", FieldWorkerId: 3})
+	logger.Debugf("Search accepted")
+	logger.Errorf("Cannot publish message, error=%s", "channel closed")
+	Infof("Configuration is reloaded")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+
+	var line map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &line))
+	assert.Equal(t, map[string]interface{}{
+		"time":       "2015-10-02T12:00:00Z",
+		"level":      "error",
+		"msg":        "Cannot publish message, error=channel closed",
+		"request_id": "r1",
+		"worker_id":  float64(3),
+	}, line)
+	assert.Equal(t, `{"level":"info","msg":"Configuration is reloaded","time":"2015-10-02T12:00:00Z"}`, lines[1])
+}
+
+func TestSeelogOutput(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := seelog.LoggerFromWriterWithMinLevelAndFormat(&out, seelog.TraceLvl, "%LEV %File:%Line %Msg%n")
+	assert.NoError(t, err)
+	assert.NoError(t, ReplaceSeelog(logger))
+	defer ReplaceSeelog(seelog.Default)
+	defer useOutput(seelogOutput{})()
+
+	With(Fields{FieldRequestId: "r1"}).Warnf("Job dropped, query=%s", "martian")
+	Error("Cannot start")
+	Flush()
+
+	// file and line belong to the caller, not to the logging package
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	assert.Regexp(t, `^WRN output_test.go:\d+ Job dropped, query=martian, request_id=r1$`, lines[0])
+	assert.Regexp(t, `^ERR output_test.go:\d+ Cannot start$`, lines[1])
+}
+
+func TestConfigure(t *testing.T) {
+	defer setOutput(seelogOutput{})
+
+	assert.EqualError(t, Configure(Options{Format: "xml"}), "Unknown log format 'xml'")
+	assert.EqualError(t, Configure(Options{Format: FormatJSON, Level: "verbose"}), "Unknown log level 'verbose'")
+	assert.Error(t, Configure(Options{Format: FormatJSON, Level: "info", File: "/not-existing-dir/movie-service.log"}))
+
+	dir, _ := ioutil.TempDir("", "movie-service-logging")
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "movie-service.log")
+
+	assert.NoError(t, Configure(Options{Format: FormatJSON, Level: "warn", File: fileName}))
+	Infof("Skipped")
+	With(Fields{FieldExchange: "movies"}).Warnf("Written")
+	assert.NoError(t, Configure(Options{Format: FormatSeelog}))
+
+	data, err := ioutil.ReadFile(fileName)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"))
+	assert.True(t, strings.Contains(string(data), `"exchange":"movies","level":"warn","msg":"Written"`))
+}
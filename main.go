@@ -7,63 +7,79 @@ import (
 	"os/signal"
 	"syscall"
 
-	log "github.com/cihub/seelog"
+	"github.com/cihub/seelog"
 
-	"github.com/go-ini/ini"
+	log "github.com/plar/movie-service/logging"
 	"github.com/plar/movie-service/rest"
+	"github.com/plar/movie-service/tracing"
 )
 
 var (
-	config      = flag.String("config", "movie-service.ini", "Configuration file")
-	logFileName = flag.String("log", "log.xml", "use custom log.xml")
+	configFileName = flag.String("config", "movie-service.ini", "Configuration file")
+	logFileName    = flag.String("log", "log.xml", "use custom log.xml")
+	printConfig    = flag.Bool("print-config", false, "Print effective configuration with redacted secrets and exit")
 )
 
 func init() {
 	flag.Parse()
 
-	logger, err := log.LoggerFromConfigAsFile(*logFileName)
-	if err == nil {
-		log.ReplaceLogger(logger)
+	if err := loadLogger(*logFileName); err == nil {
 		return
 	}
 
-	logger, err = log.LoggerFromConfigAsString(_log_default)
+	logger, err := seelog.LoggerFromConfigAsString(_log_default)
 	if err != nil {
 		panic(err)
 	}
+	log.ReplaceSeelog(logger)
 }
 
 func main() {
 	defer log.Flush()
 
-	cfg, err := ini.LooseLoad(_conf_default, *config)
-	if err != nil {
-		log.Error(fmt.Errorf("Cannot load settings: %s", err))
+	cfg, valid := loadConfig(*configFileName)
+	if *printConfig && cfg != nil {
+		cfg.Redacted().WriteTo(os.Stdout)
+	}
+	if !valid {
+		log.Flush()
+		os.Exit(1)
+	}
+	if *printConfig {
 		return
 	}
+	configureLogging(cfg)
 
-	//server := rest.NewRestServerWithLogger(log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile))
-	ctx := rest.MovieServerContext{
-		MessageQueueURI:      cfg.Section("rabbitmq").Key("uri").String(),
-		ServiceURI:           cfg.Section("movie-service").Key("uri").String(),
-		RottenTomatoesAPIKey: cfg.Section("rottentomatoes").Key("rottentomatoes_api_key").String(),
+	tracer, err := tracing.Open(cfg.TracingOptions())
+	if err != nil {
+		log.Errorf("Cannot start tracing: %s", err)
+		return
 	}
+	defer shutdownTracing(tracer)
 
-	server, err := rest.NewMovieServer(ctx)
+	//server := rest.NewRestServerWithLogger(log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile))
+	server, err := rest.NewMovieServer(cfg.Context())
 	if err != nil {
 		log.Error(fmt.Errorf("Cannot create service: %s", err))
 		return
 	}
 
-	// handle Ctrl-C & system.SIGTERM
-	// TBD: HUP reload configs
+	// handle Ctrl-C & system.SIGTERM, reload configs on system.SIGHUP
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	signal.Notify(c, syscall.SIGTERM)
+	signal.Notify(c, syscall.SIGHUP)
 	go func() {
-		sig := <-c
-		log.Infof("scraper: received system '%v' signal", sig)
-		server.Quit()
+		for sig := range c {
+			log.Infof("scraper: received system '%v' signal", sig)
+			if sig == syscall.SIGHUP {
+				// reload can wait for busy workers, the signals are still handled meanwhile
+				go reload(server)
+				continue
+			}
+			server.Quit()
+			return
+		}
 	}()
 
 	server.Start()
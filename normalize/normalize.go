@@ -0,0 +1,117 @@
+// Package normalize turns the search queries and the indexed movie fields into comparable terms.
+package normalize
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// the year of the first films, the newer years are up to the next few ones
+const minYear = 1888
+
+// articles stripped from the beginning of the query
+var articles = map[string]bool{"the": true, "a": true, "an": true}
+
+// Normalized is the search query rewritten to the terms the provider and the catalog match best.
+type Normalized struct {
+	Text string // folded terms joined with spaces
+	Year int    // the year found at the end of the query, 0 - none
+}
+
+// Fold lower-cases the text and removes the diacritics, "Amélie" -> "amelie".
+func Fold(text string) string {
+	folded, _, err := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), text)
+	if err != nil {
+		folded = text
+	}
+	return strings.ToLower(folded)
+}
+
+// Terms splits the folded text into the terms of letters and digits, the punctuation is dropped.
+func Terms(text string) []string {
+	return strings.FieldsFunc(Fold(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Query folds the query, strips the punctuation and the leading article and extracts the year,
+// "The Martian (2015)" -> "martian", 2015. The only term of the query is always kept.
+func Query(text string) Normalized {
+	terms := Terms(text)
+
+	var year int
+	if len(terms) > 1 {
+		if y, ok := parseYear(terms[len(terms)-1]); ok {
+			year, terms = y, terms[:len(terms)-1]
+		}
+	}
+	return Normalized{Text: strings.Join(stripArticle(terms), " "), Year: year}
+}
+
+// Title folds the title and strips the punctuation and the leading article, the year is kept,
+// "The Martian" -> "martian", "Blade Runner 2049" -> "blade runner 2049".
+func Title(text string) string {
+	return strings.Join(stripArticle(Terms(text)), " ")
+}
+
+// stripArticle removes the leading article unless it is the only term.
+func stripArticle(terms []string) []string {
+	if len(terms) > 1 && articles[terms[0]] {
+		return terms[1:]
+	}
+	return terms
+}
+
+func parseYear(term string) (int, bool) {
+	if len(term) != 4 {
+		return 0, false
+	}
+	year, err := strconv.Atoi(term)
+	if err != nil || year < minYear || year > time.Now().Year()+5 {
+		return 0, false
+	}
+	return year, true
+}
+
+// Distance is the number of inserted, deleted, replaced and swapped adjacent letters between the terms.
+func Distance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	d := make([][]int, len(s)+1)
+	for i := range d {
+		d[i] = make([]int, len(t)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(s); i++ {
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			d[i][j] = minimum(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				d[i][j] = minimum(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(s)][len(t)]
+}
+
+func minimum(values ...int) int {
+	result := values[0]
+	for _, v := range values[1:] {
+		if v < result {
+			result = v
+		}
+	}
+	return result
+}
@@ -0,0 +1,52 @@
+package normalize
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFold(t *testing.T) {
+	assert.Equal(t, "amelie", Fold("Amélie"))
+	assert.Equal(t, "le martien de noel", Fold("Le Martien de NOËL"))
+	assert.Equal(t, "the martian", Fold("The Martian"))
+}
+
+func TestTerms(t *testing.T) {
+	assert.Equal(t, []string{"santo", "vs", "the", "martians", "1966"}, Terms("Santo vs. the Martians (1966)"))
+	assert.Equal(t, []string{"mercano", "el", "marciano"}, Terms("Mercano, el marciano"))
+	assert.Empty(t, Terms(" - "))
+}
+
+func TestQuery(t *testing.T) {
+	assert.Equal(t, Normalized{Text: "marshian"}, Query("the marshian"))
+	assert.Equal(t, Normalized{Text: "martian", Year: 2015}, Query("Martian 2015"))
+	assert.Equal(t, Normalized{Text: "martian", Year: 2015}, Query("The Martian (2015)"))
+	assert.Equal(t, Normalized{Text: "planet of the apes"}, Query("Planet of the Apes!"))
+	assert.Equal(t, Normalized{Text: "amelie"}, Query("Amélie"))
+
+	// the only term is kept
+	assert.Equal(t, Normalized{Text: "2012"}, Query("2012"))
+	assert.Equal(t, Normalized{Text: "the"}, Query("The"))
+	assert.Equal(t, Normalized{Text: "a", Year: 2015}, Query("A 2015"))
+
+	// numbers which are not years stay in the query
+	assert.Equal(t, Normalized{Text: "blade runner 9999"}, Query("Blade Runner 9999"))
+	assert.Equal(t, Normalized{Text: "apollo 13"}, Query("Apollo 13"))
+	assert.Equal(t, Normalized{}, Query("?!"))
+}
+
+func TestTitle(t *testing.T) {
+	assert.Equal(t, "martian", Title("The Martian"))
+	assert.Equal(t, "blade runner 2049", Title("Blade Runner 2049"))
+	assert.Equal(t, "amelie", Title("Amélie"))
+	assert.Equal(t, "the", Title("The"))
+}
+
+func TestDistance(t *testing.T) {
+	assert.Equal(t, 0, Distance("martian", "martian"))
+	assert.Equal(t, 2, Distance("marshian", "martian"))
+	assert.Equal(t, 1, Distance("matrain", "martain"))
+	assert.Equal(t, 1, Distance("amelie", "amélie"))
+	assert.Equal(t, 3, Distance("", "abc"))
+}
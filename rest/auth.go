@@ -0,0 +1,217 @@
+package rest
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	log "github.com/plar/movie-service/logging"
+)
+
+const (
+	APIKeyHeader = "X-Api-Key"
+
+	quotaDayLayout = "2006-01-02"
+)
+
+// APIKey describes a client of the HTTP API and its limits.
+type APIKey struct {
+	Key    string `json:"key"`
+	Client string `json:"client"`
+
+	// Requests per second and burst size, 0 - unlimited
+	RateLimit float64 `json:"rate_limit,omitempty"`
+	Burst     int     `json:"burst,omitempty"`
+
+	// Requests per UTC day, 0 - unlimited
+	DailyQuota int `json:"daily_quota,omitempty"`
+
+	// Allowed exchanges and routing key prefixes, "prefix*" patterns are supported, empty - no restrictions
+	AllowedExchanges   []string `json:"exchanges,omitempty"`
+	AllowedRoutingKeys []string `json:"routing_keys,omitempty"`
+}
+
+type keyState struct {
+	APIKey
+	limiter *rate.Limiter
+	day     string
+	used    int
+}
+
+// authenticator checks API keys and enforces per key rate limits and daily quotas.
+// Authentication is disabled when there are no keys.
+type authenticator struct {
+	sync.Mutex
+	keys    map[string]*keyState
+	metrics *Metrics
+	now     func() time.Time
+}
+
+func newAuthenticator(keys []APIKey, metrics *Metrics) *authenticator {
+	auth := &authenticator{metrics: metrics, now: time.Now}
+	auth.SetKeys(keys)
+	return auth
+}
+
+// SetKeys replaces the keys, usage of the keys which are not changed is preserved.
+func (self *authenticator) SetKeys(keys []APIKey) {
+	self.Lock()
+	defer self.Unlock()
+
+	states := make(map[string]*keyState, len(keys))
+	for _, key := range keys {
+		limit, burst := rate.Inf, key.Burst
+		if key.RateLimit > 0 {
+			limit = rate.Limit(key.RateLimit)
+			if burst <= 0 {
+				burst = int(math.Ceil(key.RateLimit))
+			}
+		}
+
+		state := &keyState{APIKey: key, limiter: rate.NewLimiter(limit, burst)}
+		if old, exists := self.keys[key.Key]; exists {
+			state.day, state.used = old.day, old.used
+			if old.RateLimit == key.RateLimit && old.Burst == key.Burst {
+				state.limiter = old.limiter
+			}
+		}
+		states[key.Key] = state
+	}
+	self.keys = states
+}
+
+func (self *authenticator) Enabled() bool {
+	self.Lock()
+	defer self.Unlock()
+	return len(self.keys) > 0
+}
+
+// Client returns the client name of the request, it does not check any limits.
+func (self *authenticator) Client(r *http.Request) string {
+	self.Lock()
+	defer self.Unlock()
+
+	if len(self.keys) == 0 {
+		if client := r.Header.Get(ClientIdHeader); len(client) > 0 {
+			return client
+		}
+		return unknownClient
+	}
+
+	if state, exists := self.keys[r.Header.Get(APIKeyHeader)]; exists {
+		return state.Client
+	}
+	return unknownClient
+}
+
+// KeyClient returns the client of the API key of the request, unknownClient when auth is disabled,
+// unlike Client it never trusts X-Client-Id, so it is bounded by the configured keys.
+func (self *authenticator) KeyClient(r *http.Request) string {
+	self.Lock()
+	defer self.Unlock()
+
+	if state, exists := self.keys[r.Header.Get(APIKeyHeader)]; exists {
+		return state.Client
+	}
+	return unknownClient
+}
+
+// Authorize checks the key of the request and takes one request from its rate limit and quota.
+func (self *authenticator) Authorize(r *http.Request) (string, *APIError) {
+	self.Lock()
+	defer self.Unlock()
+
+	if len(self.keys) == 0 {
+		return "", nil
+	}
+
+	key := r.Header.Get(APIKeyHeader)
+	if len(key) == 0 {
+		return unknownClient, NewAPIError(http.StatusUnauthorized, CodeUnauthorized, "API key is required",
+			FieldError{APIKeyHeader, "header is missing"})
+	}
+
+	state, exists := self.keys[key]
+	if !exists {
+		return unknownClient, NewAPIError(http.StatusUnauthorized, CodeUnauthorized, "API key is invalid",
+			FieldError{APIKeyHeader, "unknown API key"})
+	}
+
+	// the quota is checked first, the request rejected by it does not spend a rate limit token
+	now := self.now()
+	day := now.UTC().Format(quotaDayLayout)
+	if state.day != day {
+		state.day, state.used = day, 0
+	}
+	if state.DailyQuota > 0 && state.used >= state.DailyQuota {
+		return state.Client, NewAPIError(http.StatusTooManyRequests, CodeQuotaExceeded, "Daily quota exceeded")
+	}
+
+	if !state.limiter.AllowN(now, 1) {
+		return state.Client, NewAPIError(http.StatusTooManyRequests, CodeRateLimited, "Rate limit exceeded")
+	}
+	state.used++
+
+	return state.Client, nil
+}
+
+// retryAfter returns seconds until the client can retry the rejected request.
+func (self *authenticator) retryAfter(apiErr *APIError) int {
+	if apiErr.Code != CodeQuotaExceeded {
+		return 1
+	}
+	now := self.now().UTC()
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return int(math.Ceil(tomorrow.Sub(now).Seconds()))
+}
+
+func (self *authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, apiErr := self.Authorize(r)
+		if apiErr != nil {
+			log.With(log.Fields{log.FieldClient: client}).Warnf("Request rejected, path=%s, error=%s", r.URL.Path, apiErr)
+			self.metrics.AuthRejected(client, apiErr.Code)
+			if apiErr.Status == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", strconv.Itoa(self.retryAfter(apiErr)))
+			}
+			writeError(w, requestId(r), apiErr)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// keyRestrictions adds exchange and routing key restrictions of the keys to the validation options.
+// Clients without restrictions keep access to everything when the options had no restrictions.
+func keyRestrictions(opts ValidationOptions, keys []APIKey) ValidationOptions {
+	merged := opts
+	merged.AllowedExchanges = mergePatterns(opts.AllowedExchanges, keys, func(key APIKey) []string {
+		return key.AllowedExchanges
+	})
+	merged.AllowedRoutingKeys = mergePatterns(opts.AllowedRoutingKeys, keys, func(key APIKey) []string {
+		return key.AllowedRoutingKeys
+	})
+	return merged
+}
+
+func mergePatterns(patterns map[string][]string, keys []APIKey, keyPatterns func(APIKey) []string) map[string][]string {
+	merged := make(map[string][]string, len(patterns))
+	for client, clientPatterns := range patterns {
+		merged[client] = clientPatterns
+	}
+
+	for _, key := range keys {
+		if len(keyPatterns(key)) > 0 {
+			merged[key.Client] = keyPatterns(key)
+		}
+	}
+
+	if len(patterns) == 0 && len(merged) > 0 {
+		merged[anyClient] = []string{anyClient}
+	}
+	return merged
+}
@@ -0,0 +1,226 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAuthRequest(key string) *http.Request {
+	req, _ := http.NewRequest("POST", "http://movie-search.devel/movies", nil)
+	if len(key) > 0 {
+		req.Header.Set(APIKeyHeader, key)
+	}
+	return req
+}
+
+func TestAuthenticatorDisabled(t *testing.T) {
+	auth := newAuthenticator(nil, NewMetrics())
+	assert.False(t, auth.Enabled())
+
+	req := newTestAuthRequest("")
+	client, apiErr := auth.Authorize(req)
+	assert.Nil(t, apiErr)
+	assert.Equal(t, "", client)
+
+	assert.Equal(t, unknownClient, auth.Client(req))
+	req.Header.Set(ClientIdHeader, "catalog")
+	assert.Equal(t, "catalog", auth.Client(req))
+	assert.Equal(t, unknownClient, auth.KeyClient(req))
+}
+
+func TestAuthenticatorUnauthorized(t *testing.T) {
+	auth := newAuthenticator([]APIKey{{Key: "secret", Client: "billing"}}, NewMetrics())
+	assert.True(t, auth.Enabled())
+
+	client, apiErr := auth.Authorize(newTestAuthRequest(""))
+	assert.Equal(t, unknownClient, client)
+	assert.Equal(t, http.StatusUnauthorized, apiErr.Status)
+	assert.Equal(t, CodeUnauthorized, apiErr.Code)
+	assert.Equal(t, "API key is required", apiErr.Message)
+
+	client, apiErr = auth.Authorize(newTestAuthRequest("wrong"))
+	assert.Equal(t, unknownClient, client)
+	assert.Equal(t, "API key is invalid", apiErr.Message)
+
+	// X-Client-Id is ignored when auth is enabled
+	req := newTestAuthRequest("secret")
+	req.Header.Set(ClientIdHeader, "catalog")
+	client, apiErr = auth.Authorize(req)
+	assert.Nil(t, apiErr)
+	assert.Equal(t, "billing", client)
+	assert.Equal(t, "billing", auth.Client(req))
+	assert.Equal(t, "billing", auth.KeyClient(req))
+}
+
+func TestAuthenticatorRateLimit(t *testing.T) {
+	now := time.Date(2016, 1, 2, 10, 0, 0, 0, time.UTC)
+	auth := newAuthenticator([]APIKey{{Key: "secret", Client: "billing", RateLimit: 1, Burst: 2}}, NewMetrics())
+	auth.now = func() time.Time { return now }
+
+	req := newTestAuthRequest("secret")
+	_, apiErr := auth.Authorize(req)
+	assert.Nil(t, apiErr)
+	_, apiErr = auth.Authorize(req)
+	assert.Nil(t, apiErr)
+
+	client, apiErr := auth.Authorize(req)
+	assert.Equal(t, "billing", client)
+	assert.Equal(t, http.StatusTooManyRequests, apiErr.Status)
+	assert.Equal(t, CodeRateLimited, apiErr.Code)
+	assert.Equal(t, 1, auth.retryAfter(apiErr))
+
+	now = now.Add(time.Second)
+	_, apiErr = auth.Authorize(req)
+	assert.Nil(t, apiErr)
+}
+
+func TestAuthenticatorDailyQuota(t *testing.T) {
+	now := time.Date(2016, 1, 2, 23, 0, 0, 0, time.UTC)
+	auth := newAuthenticator([]APIKey{{Key: "secret", Client: "billing", DailyQuota: 2}}, NewMetrics())
+	auth.now = func() time.Time { return now }
+
+	req := newTestAuthRequest("secret")
+	for i := 0; i < 2; i++ {
+		_, apiErr := auth.Authorize(req)
+		assert.Nil(t, apiErr)
+	}
+
+	_, apiErr := auth.Authorize(req)
+	assert.Equal(t, http.StatusTooManyRequests, apiErr.Status)
+	assert.Equal(t, CodeQuotaExceeded, apiErr.Code)
+	assert.Equal(t, 3600, auth.retryAfter(apiErr))
+
+	// usage is kept when keys are reloaded
+	auth.SetKeys([]APIKey{{Key: "secret", Client: "billing", DailyQuota: 2}})
+	_, apiErr = auth.Authorize(req)
+	assert.Equal(t, CodeQuotaExceeded, apiErr.Code)
+
+	// the next day
+	now = now.Add(time.Hour)
+	_, apiErr = auth.Authorize(req)
+	assert.Nil(t, apiErr)
+}
+
+func TestAuthenticatorQuotaKeepsRateLimit(t *testing.T) {
+	now := time.Date(2016, 1, 2, 23, 59, 59, 900000000, time.UTC)
+	auth := newAuthenticator([]APIKey{{Key: "secret", Client: "billing", RateLimit: 1, Burst: 2, DailyQuota: 1}}, NewMetrics())
+	auth.now = func() time.Time { return now }
+
+	req := newTestAuthRequest("secret")
+	_, apiErr := auth.Authorize(req)
+	assert.Nil(t, apiErr)
+
+	// requests over the quota do not spend the rate limit tokens
+	for i := 0; i < 2; i++ {
+		_, apiErr = auth.Authorize(req)
+		assert.Equal(t, CodeQuotaExceeded, apiErr.Code)
+	}
+
+	// the next day the remaining token is still there
+	now = now.Add(100 * time.Millisecond)
+	_, apiErr = auth.Authorize(req)
+	assert.Nil(t, apiErr)
+}
+
+func TestAuthenticatorMiddleware(t *testing.T) {
+	metrics := NewMetrics()
+	auth := newAuthenticator([]APIKey{{Key: "secret", Client: "billing", DailyQuota: 1}}, metrics)
+	handler := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newTestAuthRequest("wrong"))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assertErrorResponse(t, recorder, ErrorResponse{Meta: Meta{
+		Status: ERROR,
+		Code:   CodeUnauthorized,
+		Error:  "API key is invalid",
+		Errors: []FieldError{{APIKeyHeader, "unknown API key"}},
+	}})
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newTestAuthRequest("secret"))
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newTestAuthRequest("secret"))
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.NotEmpty(t, recorder.Header().Get("Retry-After"))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.authRejections.WithLabelValues(unknownClient, CodeUnauthorized)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.authRejections.WithLabelValues("billing", CodeQuotaExceeded)))
+}
+
+func TestKeyRestrictions(t *testing.T) {
+	keys := []APIKey{
+		{Key: "secret1", Client: "billing", AllowedExchanges: []string{"billing.*"}, AllowedRoutingKeys: []string{"invoices.*"}},
+		{Key: "secret2", Client: "catalog"},
+	}
+
+	// no restrictions in the config, the other clients keep access to everything
+	opts := keyRestrictions(ValidationOptions{ProbeExchange: true}, keys)
+	assert.True(t, opts.ProbeExchange)
+	assert.Equal(t, map[string][]string{"billing": {"billing.*"}, anyClient: {anyClient}}, opts.AllowedExchanges)
+	assert.Equal(t, map[string][]string{"billing": {"invoices.*"}, anyClient: {anyClient}}, opts.AllowedRoutingKeys)
+
+	validator := NewRequestValidator(opts, DefaultPublishOptions(), nil)
+	assert.Nil(t, validator.Validate("billing", &Request{ExchangeName: "billing.results", RoutingKey: "invoices.search"}))
+	assert.Nil(t, validator.Validate("catalog", &Request{ExchangeName: "movies", RoutingKey: "search"}))
+
+	verr := validator.Validate("billing", &Request{ExchangeName: "billing.results", RoutingKey: "search"})
+	assert.Equal(t, http.StatusForbidden, verr.Status)
+	assert.Equal(t, CodeRoutingKeyNotAllowed, verr.Code)
+	assert.Equal(t, []FieldError{{"routing_key", "routing key 'search' is not allowed for the client"}}, verr.Fields)
+
+	// key restrictions override the config
+	config := map[string][]string{"billing": {"movies"}}
+	opts = keyRestrictions(ValidationOptions{AllowedExchanges: config}, keys)
+	assert.Equal(t, map[string][]string{"billing": {"billing.*"}}, opts.AllowedExchanges)
+	assert.Equal(t, map[string][]string{"billing": {"movies"}}, config)
+}
+
+func TestMovieServerSearchAPIKey(t *testing.T) {
+	metrics := NewMetrics()
+	ctx := NewTestMovieServerContext()
+	ctx.Metrics = metrics
+	ctx.APIKeys = []APIKey{{Key: "secret", Client: "billing", AllowedExchanges: []string{"billing.*"}}}
+	server, _ := NewMovieServer(ctx)
+
+	reqBody, _ := json.Marshal(Request{ExchangeName: "billing.results", RoutingKey: "search"})
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "http://movie-search.devel/movies?q=martian", bytes.NewReader(reqBody))
+	server.Router().ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "http://movie-search.devel/movies?q=martian", bytes.NewReader(reqBody))
+	req.Header.Set(APIKeyHeader, "secret")
+	server.Router().ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	reqBody, _ = json.Marshal(Request{ExchangeName: "movies", RoutingKey: "search"})
+	recorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "http://movie-search.devel/movies?q=martian", bytes.NewReader(reqBody))
+	req.Header.Set(APIKeyHeader, "secret")
+	server.Router().ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+
+	// service endpoints do not require API key
+	recorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "http://movie-search.devel/healthz", nil)
+	server.Router().ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.httpRequests.WithLabelValues("/movies", "POST", "401", unknownClient)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.httpRequests.WithLabelValues("/movies", "POST", "200", "billing")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.httpRequests.WithLabelValues("/movies", "POST", "403", "billing")))
+}
@@ -0,0 +1,347 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/plar/movie-service/audit"
+	log "github.com/plar/movie-service/logging"
+	"github.com/plar/movie-service/tracing"
+)
+
+// Publish modes of the batch search
+const (
+	PublishEach      = "each"      // every item is published on its own once it is searched
+	PublishAggregate = "aggregate" // all items are published in one message once all of them are searched
+)
+
+const (
+	spanBatch = "batch.search"
+
+	// fieldBatchIndex is the log field with the index of the batch search item
+	fieldBatchIndex = "batch_index"
+)
+
+type BatchOptions struct {
+	// Max number of the queries or ids of one batch
+	MaxItems int
+
+	// Max number of the items of one batch searched at once, the request can lower it
+	Concurrency int
+}
+
+func DefaultBatchOptions() BatchOptions {
+	return BatchOptions{
+		MaxItems:    100,
+		Concurrency: 4,
+	}
+}
+
+func (self BatchOptions) Validate() error {
+	switch {
+	case self.MaxItems < 1:
+		return fmt.Errorf("Batch max items must be positive, got %d", self.MaxItems)
+	case self.Concurrency < 1 || self.Concurrency > MaxWorkers:
+		return fmt.Errorf("Batch concurrency must be between 1 and %d, got %d", MaxWorkers, self.Concurrency)
+	}
+	return nil
+}
+
+// BatchRequest is the body of the batch search, the results are published with its Request envelope.
+type BatchRequest struct {
+	Request
+	Queries     []string `json:"queries,omitempty"`
+	Ids         []string `json:"ids,omitempty"`         // provider ids of the movies, instead of the queries
+	Publish     string   `json:"publish,omitempty"`     // PublishEach or PublishAggregate, empty - PublishEach
+	Concurrency int      `json:"concurrency,omitempty"` // 0 - the configured one
+}
+
+// BatchQuery is the batch search job, the items share the source and the filter of the request URL.
+type BatchQuery struct {
+	Items       []SearchQuery `json:"items"`
+	Publish     string        `json:"publish"`
+	Concurrency int           `json:"concurrency"`
+}
+
+// BatchItem identifies the item of the batch search in the published response.
+type BatchItem struct {
+	Index int    `json:"index"`
+	Total int    `json:"total"`
+	Query string `json:"query,omitempty"`
+	Id    string `json:"id,omitempty"`
+}
+
+// BatchItemResponse is the response of one item of the aggregated batch search, meta has the item status.
+type BatchItemResponse struct {
+	BatchItem
+	SearchResponse
+}
+
+// NewBatchResponse creates the aggregated response of the batch search.
+func NewBatchResponse(requestId string, items []BatchItemResponse) *SearchResponse {
+	return &SearchResponse{Meta: Meta{RequestId: requestId, Status: SUCCESS}, Data: SearchData{Items: items}}
+}
+
+func (self *jobFactory) NewBatch(ctx context.Context, req Request, batch BatchQuery, dispatched func(), done func(error)) {
+	logger := log.With(log.Fields{
+		log.FieldRequestId:  req.RequestId,
+		log.FieldExchange:   req.ExchangeName,
+		log.FieldRoutingKey: req.RoutingKey,
+	})
+	ctx, span := tracing.Tracer().Start(ctx, spanBatch, trace.WithAttributes(
+		attrRequestId.String(req.RequestId), attrBatchSize.Int(len(batch.Items))))
+
+	concurrency := batch.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	slots := make(chan struct{}, concurrency)
+	items := make([]BatchItemResponse, len(batch.Items))
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var failed error // the first publish error
+	fail := func(err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if failed == nil {
+			failed = err
+		}
+	}
+
+	for i, query := range batch.Items {
+		i, query := i, query
+		item := BatchItem{Index: i, Total: len(batch.Items), Query: query.Text, Id: query.Id}
+		logger := logger.With(queryFields(query)).With(log.Fields{fieldBatchIndex: i})
+		_, wait := tracing.Tracer().Start(ctx, spanWorkerQueue, trace.WithAttributes(attrRequestId.String(req.RequestId), attrBatchIndex.Int(i)))
+
+		job := func(id int) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			logger := logger.With(log.Fields{log.FieldWorkerId: id})
+			wait.SetAttributes(attrWorkerId.Int(id))
+			wait.End()
+
+			resp := self.run(ctx, req, query, logger)
+			if batch.Publish == PublishAggregate {
+				items[i] = BatchItemResponse{item, *resp}
+				return
+			}
+			resp.Meta.Batch = &item
+			if err := self.publish(ctx, req, resp, logger); err != nil {
+				fail(err)
+			}
+		}
+
+		wg.Add(1)
+		worker := self.acquire(slots)
+		if worker == nil || !self.send(worker, job) {
+			// the batch stays in the journal, the dispatched items are published again on replay
+			wg.Done()
+			if worker != nil {
+				<-slots
+			}
+			err := errors.New("Batch dropped, service is shutting down")
+			logger.Warnf("Batch dropped, service is shutting down, dispatched=%d", i)
+			tracing.End(wait, err)
+			wg.Wait()
+			tracing.End(span, err)
+			return
+		}
+		if dispatched != nil {
+			dispatched()
+		}
+	}
+	wg.Wait()
+
+	if batch.Publish == PublishAggregate {
+		if err := self.publish(ctx, req, NewBatchResponse(req.RequestId, items), logger); err != nil {
+			fail(err)
+		}
+	}
+	logger.Debugf("Batch completed, items=%d", len(batch.Items))
+	tracing.End(span, failed)
+	if done != nil {
+		done(failed)
+	}
+}
+
+// parseBatch checks the body of the batch search and converts it to the job of the source and filter.
+func parseBatch(body BatchRequest, options BatchOptions, source string, filter *SearchFilter) (BatchQuery, *APIError) {
+	var fields []FieldError
+	var items []SearchQuery
+	switch {
+	case len(body.Queries) > 0 && len(body.Ids) > 0:
+		fields = append(fields, FieldError{"ids", "cannot be combined with queries"})
+	case len(body.Queries) == 0 && len(body.Ids) == 0:
+		fields = append(fields, FieldError{"queries", "queries or ids are required"})
+	case len(body.Queries)+len(body.Ids) > options.MaxItems:
+		fields = append(fields, FieldError{"queries", fmt.Sprintf("cannot have more than %d items", options.MaxItems)})
+	}
+
+	for i, query := range body.Queries {
+		if len(query) == 0 {
+			fields = append(fields, FieldError{fmt.Sprintf("queries[%d]", i), "cannot be empty"})
+		}
+		items = append(items, SearchQuery{Text: query, Source: source, Filter: filter})
+	}
+	for i, id := range body.Ids {
+		if len(id) == 0 {
+			fields = append(fields, FieldError{fmt.Sprintf("ids[%d]", i), "cannot be empty"})
+		}
+		items = append(items, SearchQuery{Id: id, Source: source, Filter: filter})
+	}
+
+	publish := body.Publish
+	switch publish {
+	case "":
+		publish = PublishEach
+	case PublishEach, PublishAggregate:
+	default:
+		fields = append(fields, FieldError{"publish", "must be each or aggregate"})
+	}
+
+	concurrency := body.Concurrency
+	switch {
+	case concurrency == 0 || concurrency > options.Concurrency:
+		concurrency = options.Concurrency
+	case concurrency < 0:
+		fields = append(fields, FieldError{"concurrency", "cannot be negative"})
+	}
+
+	if len(fields) > 0 {
+		return BatchQuery{}, NewAPIError(http.StatusUnprocessableEntity, CodeInvalidBatch, "Invalid batch", fields...)
+	}
+	return BatchQuery{Items: items, Publish: publish, Concurrency: concurrency}, nil
+}
+
+// SearchBatch accepts the batch of the queries or ids, the items are searched in the background.
+func (self *movieServer) SearchBatch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if !self.admit() {
+		writeError(w, requestId(r), NewAPIError(http.StatusServiceUnavailable, CodeShuttingDown, "Service is shutting down"))
+		return
+	}
+	defer self.release()
+
+	source, apiErr := self.searchSource(r)
+	if apiErr != nil {
+		writeError(w, requestId(r), apiErr)
+		return
+	}
+
+	filter, apiErr := ParseSearchFilter(r.URL.Query())
+	if apiErr != nil {
+		writeError(w, requestId(r), apiErr)
+		return
+	}
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, requestId(r), NewAPIError(http.StatusInternalServerError, CodeBodyReadFailed, "Cannot read request body"))
+		return
+	}
+
+	var body BatchRequest
+	if err := json.Unmarshal(data, &body); err != nil {
+		writeError(w, requestId(r), NewAPIError(http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("Cannot decode request body: %v", err)))
+		return
+	}
+	req := body.Request
+
+	client := self.auth.Client(r)
+	span := trace.SpanFromContext(r.Context())
+	span.SetAttributes(attrRequestId.String(req.RequestId), attrClient.String(client))
+	logger := log.With(log.Fields{log.FieldRequestId: req.RequestId, log.FieldClient: client})
+
+	batch, apiErr := parseBatch(body, self.batchOptions(), source, filter)
+	if apiErr != nil {
+		logger.Warnf("Batch rejected, error=%s", apiErr)
+		self.auditReceived(req, "", client, apiErr)
+		writeError(w, req.RequestId, apiErr)
+		return
+	}
+	span.SetAttributes(attrBatchSize.Int(len(batch.Items)))
+	summary := fmt.Sprintf("batch of %d", len(batch.Items))
+
+	_, _, validator := self.settings()
+	if verr := validator.Validate(client, &req); verr != nil {
+		logger.With(log.Fields{log.FieldExchange: req.ExchangeName, log.FieldRoutingKey: req.RoutingKey}).
+			Warnf("Request rejected, error=%s", verr)
+		self.auditReceived(req, summary, client, verr)
+		writeError(w, req.RequestId, verr)
+		return
+	}
+
+	// the job outlives the request, it keeps the span only
+	ctx := trace.ContextWithSpan(context.Background(), span)
+	done, err := self.acceptBatch(ctx, req, batch)
+	if err != nil {
+		self.auditReceived(req, summary, client, err)
+		writeError(w, req.RequestId, NewAPIError(http.StatusInternalServerError, CodeJournalFailed, "Cannot store the job"))
+		return
+	}
+	self.auditReceived(req, summary, client, nil)
+
+	resp := Response{
+		RequestId:    req.RequestId,
+		Method:       "movies/batch",
+		Source:       source,
+		Items:        len(batch.Items),
+		ExchangeName: req.ExchangeName,
+		RoutingKey:   req.RoutingKey,
+	}
+
+	data, err = json.Marshal(resp)
+	if err != nil {
+		writeError(w, req.RequestId, NewAPIError(http.StatusInternalServerError, CodeEncodeFailed, "Cannot encode response body"))
+		return
+	}
+	w.Write(data)
+	logger.Debugf("Batch accepted, items=%d, publish=%s", len(batch.Items), batch.Publish)
+
+	// the batch runs longer than the request, the response is completed before it is dispatched
+	self.audit.Log(audit.Event{Event: audit.EventQueued, RequestId: req.RequestId})
+	self.queueBatch(ctx, req, batch, done)
+}
+
+// queueBatch runs the batch in the background, every item is a pending job until it is handed to a worker.
+// The items are counted before it returns, so the drain of the shutdown waits for them.
+func (self *movieServer) queueBatch(ctx context.Context, req Request, batch BatchQuery, done func(error)) {
+	queued := int64(len(batch.Items))
+	atomic.AddInt64(&self.pendingJobs, queued)
+	for range batch.Items {
+		self.metrics.JobQueued()
+	}
+	dispatched := func() {
+		atomic.AddInt64(&queued, -1)
+		atomic.AddInt64(&self.pendingJobs, -1)
+		self.metrics.JobDispatched()
+	}
+
+	go func() {
+		self.jobFactory.NewBatch(ctx, req, batch, dispatched, done)
+		// the items of the dropped batch are not waiting anymore
+		for left := atomic.LoadInt64(&queued); left > 0; left-- {
+			dispatched()
+		}
+	}()
+}
+
+// batchOptions returns the batch settings which can be changed by Reload.
+func (self *movieServer) batchOptions() BatchOptions {
+	self.settingsLock.RLock()
+	defer self.settingsLock.RUnlock()
+	return self.batch
+}
@@ -0,0 +1,349 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	wq "github.com/plar/movie-service/workerqueue"
+)
+
+// batchClient searches and looks up the movies of the map, the items of the batch call it concurrently.
+type batchClient struct {
+	sync.Mutex
+	movies    map[string][]Movie // query -> movies, the missing queries fail
+	release   chan struct{}      // optional, the searches wait for it
+	active    int
+	maxActive int
+}
+
+func (self *batchClient) Search(query string) ([]Movie, error) {
+	self.Lock()
+	self.active++
+	if self.active > self.maxActive {
+		self.maxActive = self.active
+	}
+	self.Unlock()
+
+	if self.release != nil {
+		<-self.release
+	}
+
+	self.Lock()
+	defer self.Unlock()
+	self.active--
+	movies, ok := self.movies[query]
+	if !ok {
+		return nil, errors.New("api error, response code: 500")
+	}
+	return movies, nil
+}
+
+func (self *batchClient) Movie(id string) (*Movie, error) {
+	for _, movies := range self.movies {
+		for _, movie := range movies {
+			if movie.Id == id {
+				return &movie, nil
+			}
+		}
+	}
+	return nil, ErrMovieNotFound
+}
+
+func (self *batchClient) activeSearches() (int, int) {
+	self.Lock()
+	defer self.Unlock()
+	return self.active, self.maxActive
+}
+
+// batchQueue keeps the published responses, the items of the batch publish them concurrently.
+type batchQueue struct {
+	sync.Mutex
+	responses []*SearchResponse
+	err       error
+}
+
+func (self *batchQueue) PublishSearchResponse(ctx context.Context, req *Request, resp *SearchResponse) error {
+	self.Lock()
+	defer self.Unlock()
+	self.responses = append(self.responses, resp)
+	return self.err
+}
+
+func newBatchFactory(t *testing.T, mq MessageQueue, client Client, workers int) (JobFactory, func()) {
+	workerQueue := make(wq.WorkerQueue, workers)
+	pool, err := wq.NewPool(workerQueue, workers)
+	assert.NoError(t, err)
+	return NewJobFactory(mq, client, workerQueue), pool.Stop
+}
+
+func runBatch(factory JobFactory, batch BatchQuery) error {
+	var published error
+	factory.NewBatch(context.Background(), Request{RequestId: "RequestId"}, batch, nil, func(err error) { published = err })
+	return published
+}
+
+var batchMovies = map[string][]Movie{
+	"martian": {{Id: "771380589", Title: "The Martian", Year: 2015}},
+	"alien":   {},
+}
+
+func TestBatchOptionsValidate(t *testing.T) {
+	assert.NoError(t, DefaultBatchOptions().Validate())
+	assert.EqualError(t, BatchOptions{Concurrency: 1}.Validate(), "Batch max items must be positive, got 0")
+	assert.EqualError(t, BatchOptions{MaxItems: 1, Concurrency: 257}.Validate(), "Batch concurrency must be between 1 and 256, got 257")
+}
+
+func TestParseBatch(t *testing.T) {
+	options := BatchOptions{MaxItems: 3, Concurrency: 4}
+	filter := &SearchFilter{MinCriticsScore: 60}
+
+	batch, apiErr := parseBatch(BatchRequest{Queries: []string{"martian", "alien"}}, options, SourceUpstream, filter)
+	assert.Nil(t, apiErr)
+	assert.Equal(t, BatchQuery{Items: []SearchQuery{
+		{Text: "martian", Source: SourceUpstream, Filter: filter},
+		{Text: "alien", Source: SourceUpstream, Filter: filter},
+	}, Publish: PublishEach, Concurrency: 4}, batch)
+
+	// the request can lower the configured concurrency only
+	batch, apiErr = parseBatch(BatchRequest{Ids: []string{"771380589"}, Publish: PublishAggregate, Concurrency: 2}, options, SourceLocal, nil)
+	assert.Nil(t, apiErr)
+	assert.Equal(t, BatchQuery{Items: []SearchQuery{{Id: "771380589", Source: SourceLocal}}, Publish: PublishAggregate, Concurrency: 2}, batch)
+
+	batch, apiErr = parseBatch(BatchRequest{Ids: []string{"771380589"}, Concurrency: 10}, options, SourceUpstream, nil)
+	assert.Nil(t, apiErr)
+	assert.Equal(t, 4, batch.Concurrency)
+
+	_, apiErr = parseBatch(BatchRequest{}, options, SourceUpstream, nil)
+	assert.Equal(t, NewAPIError(http.StatusUnprocessableEntity, CodeInvalidBatch, "Invalid batch",
+		FieldError{"queries", "queries or ids are required"}), apiErr)
+
+	_, apiErr = parseBatch(BatchRequest{Queries: []string{"martian", ""}, Ids: []string{"1"}, Publish: "all", Concurrency: -1}, options, SourceUpstream, nil)
+	assert.Equal(t, []FieldError{
+		{"ids", "cannot be combined with queries"},
+		{"queries[1]", "cannot be empty"},
+		{"publish", "must be each or aggregate"},
+		{"concurrency", "cannot be negative"},
+	}, apiErr.Fields)
+
+	_, apiErr = parseBatch(BatchRequest{Ids: []string{"1", "2", "3", "4"}}, options, SourceUpstream, nil)
+	assert.Equal(t, []FieldError{{"queries", "cannot have more than 3 items"}}, apiErr.Fields)
+}
+
+func TestNewBatchPublishesEach(t *testing.T) {
+	mq := &batchQueue{}
+	factory, stop := newBatchFactory(t, mq, &batchClient{movies: batchMovies}, 2)
+	defer stop()
+
+	err := runBatch(factory, BatchQuery{Items: []SearchQuery{{Text: "martian"}, {Text: "alien"}, {Text: "matrix"}}, Publish: PublishEach, Concurrency: 2})
+	assert.NoError(t, err)
+	if !assert.Equal(t, 3, len(mq.responses)) {
+		return
+	}
+
+	published := make(map[int]*SearchResponse)
+	for _, resp := range mq.responses {
+		published[resp.Meta.Batch.Index] = resp
+	}
+	assert.Equal(t, &BatchItem{Index: 0, Total: 3, Query: "martian"}, published[0].Meta.Batch)
+	assert.Equal(t, SUCCESS, published[0].Meta.Status)
+	assert.Equal(t, batchMovies["martian"], published[0].Data.Movies)
+	assert.Equal(t, SUCCESS, published[1].Meta.Status)
+	assert.Empty(t, published[1].Data.Movies)
+	assert.Equal(t, ERROR, published[2].Meta.Status)
+	assert.Equal(t, "api error, response code: 500", published[2].Meta.Error)
+
+	// the batch is not completed when an item is not published
+	mq.err = errors.New("channel closed")
+	err = runBatch(factory, BatchQuery{Items: []SearchQuery{{Text: "martian"}, {Text: "alien"}}, Publish: PublishEach, Concurrency: 1})
+	assert.EqualError(t, err, "channel closed")
+	assert.Equal(t, 5, len(mq.responses))
+}
+
+func TestNewBatchPublishesAggregate(t *testing.T) {
+	mq := &batchQueue{}
+	factory, stop := newBatchFactory(t, mq, &batchClient{movies: batchMovies}, 2)
+	defer stop()
+
+	err := runBatch(factory, BatchQuery{Items: []SearchQuery{{Id: "771380589"}, {Id: "404"}, {Text: "matrix"}}, Publish: PublishAggregate, Concurrency: 2})
+	assert.NoError(t, err)
+	if !assert.Equal(t, 1, len(mq.responses)) {
+		return
+	}
+
+	resp := mq.responses[0]
+	assert.Equal(t, Meta{RequestId: "RequestId", Status: SUCCESS}, resp.Meta)
+	assert.Nil(t, resp.Data.Movies)
+	assert.Equal(t, []BatchItemResponse{
+		{BatchItem{Index: 0, Total: 3, Id: "771380589"}, *NewSearchResponseSuccess("RequestId", batchMovies["martian"])},
+		{BatchItem{Index: 1, Total: 3, Id: "404"}, *NewSearchResponseError("RequestId", ErrMovieNotFound)},
+		{BatchItem{Index: 2, Total: 3, Query: "matrix"}, *NewSearchResponseError("RequestId", errors.New("api error, response code: 500"))},
+	}, resp.Data.Items)
+	assert.Equal(t, CodeMovieNotFound, resp.Data.Items[1].Meta.Code)
+
+	// the items are published in one message
+	data, _ := json.Marshal(resp)
+	assert.Contains(t, string(data), `{"index":1,"total":3,"id":"404","meta":{"request_id":"RequestId","status":"error","code":"MOVIE_NOT_FOUND","error":"Movie not found"},"data":{"movies":null}}`)
+}
+
+func TestNewBatchConcurrency(t *testing.T) {
+	client := &batchClient{movies: batchMovies, release: make(chan struct{})}
+	factory, stop := newBatchFactory(t, &batchQueue{}, client, 4)
+	defer stop()
+
+	published := make(chan error, 1)
+	go func() {
+		published <- runBatch(factory, BatchQuery{Items: []SearchQuery{{Text: "martian"}, {Text: "alien"}, {Text: "martian"}, {Text: "alien"}, {Text: "martian"}},
+			Publish: PublishAggregate, Concurrency: 2})
+	}()
+
+	assert.Eventually(t, func() bool {
+		active, _ := client.activeSearches()
+		return active == 2
+	}, time.Second, time.Millisecond)
+
+	// the free workers are not used by the batch
+	time.Sleep(20 * time.Millisecond)
+	active, _ := client.activeSearches()
+	assert.Equal(t, 2, active)
+
+	close(client.release)
+	assert.NoError(t, <-published)
+	_, maxActive := client.activeSearches()
+	assert.Equal(t, 2, maxActive)
+}
+
+func TestNewBatchDropped(t *testing.T) {
+	mq := &batchQueue{}
+	factory := NewJobFactory(mq, &batchClient{movies: batchMovies}, make(wq.WorkerQueue))
+	factory.Close()
+
+	completed := false
+	factory.NewBatch(context.Background(), Request{RequestId: "RequestId"}, BatchQuery{Items: []SearchQuery{{Text: "martian"}}, Concurrency: 1},
+		nil, func(err error) { completed = true })
+	assert.False(t, completed)
+	assert.Empty(t, mq.responses)
+}
+
+// dispatchingJobFactory hands the first item of the batch to a worker and drops the rest once released.
+type dispatchingJobFactory struct {
+	testJobFactory
+	release chan struct{}
+}
+
+func (self *dispatchingJobFactory) NewBatch(ctx context.Context, req Request, batch BatchQuery, dispatched func(), done func(error)) {
+	dispatched()
+	<-self.release
+}
+
+func TestQueueBatchPendingItems(t *testing.T) {
+	ctx := NewTestMovieServerContext()
+	ctx.Metrics = NewMetrics()
+	server, _ := NewMovieServer(ctx)
+	impl := server.(*movieServer)
+	defer impl.pool.Stop()
+	factory := &dispatchingJobFactory{release: make(chan struct{})}
+	impl.jobFactory = factory
+
+	// every item is pending until it is handed to a worker
+	items := []SearchQuery{{Text: "martian"}, {Text: "alien"}, {Text: "matrix"}}
+	impl.queueBatch(context.Background(), Request{RequestId: "RequestId"}, BatchQuery{Items: items, Concurrency: 1}, nil)
+	assert.Eventually(t, func() bool { return atomic.LoadInt64(&impl.pendingJobs) == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, 2.0, testutil.ToFloat64(ctx.Metrics.jobsPending))
+
+	// the dropped items are not pending anymore
+	close(factory.release)
+	assert.Eventually(t, func() bool { return atomic.LoadInt64(&impl.pendingJobs) == 0 }, time.Second, time.Millisecond)
+	assert.Equal(t, 0.0, testutil.ToFloat64(ctx.Metrics.jobsPending))
+}
+
+func batchRequest(server *movieServer, path string, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", "http://movie-search.devel"+path, bytes.NewReader(data))
+	recorder := httptest.NewRecorder()
+	server.Router().ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestMovieServerSearchBatch(t *testing.T) {
+	factory := &recordingJobFactory{}
+	ctx := NewTestMovieServerContext()
+	ctx.JobFactory = factory
+	ctx.BatchOptions = &BatchOptions{MaxItems: 2, Concurrency: 3}
+	s, err := NewMovieServer(ctx)
+	assert.NoError(t, err)
+	server := s.(*movieServer)
+	defer server.Quit()
+
+	req := Request{RequestId: "RequestId", ExchangeName: "movies", RoutingKey: "search"}
+	recorder := batchRequest(server, "/movies/batch?min_critics_score=60", BatchRequest{Request: req, Queries: []string{"martian", "alien"}, Publish: PublishAggregate})
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var resp Response
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &resp))
+	assert.Equal(t, Response{RequestId: "RequestId", Method: "movies/batch", Source: SourceUpstream, Items: 2, ExchangeName: "movies", RoutingKey: "search"}, resp)
+
+	assert.Eventually(t, func() bool { return len(factory.recorded()) == 1 }, time.Second, time.Millisecond)
+	filter := &SearchFilter{MinCriticsScore: 60}
+	batch := factory.recorded()[0]
+	assert.Equal(t, req, batch.req)
+	assert.Equal(t, BatchQuery{Items: []SearchQuery{
+		{Text: "martian", Source: SourceUpstream, Filter: filter},
+		{Text: "alien", Source: SourceUpstream, Filter: filter},
+	}, Publish: PublishAggregate, Concurrency: 3}, batch.batch)
+
+	recorder = batchRequest(server, "/movies/batch", BatchRequest{Request: req, Ids: []string{"1", "2", "3"}})
+	assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"code":"INVALID_BATCH"`)
+
+	recorder = batchRequest(server, "/movies/batch?source=local", BatchRequest{Request: req, Ids: []string{"1"}})
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"code":"CATALOG_DISABLED"`)
+
+	recorder = batchRequest(server, "/movies/batch", BatchRequest{Request: Request{RequestId: "RequestId"}, Ids: []string{"1"}})
+	assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"code":"INVALID_REQUEST"`)
+	assert.Equal(t, 1, len(factory.recorded()))
+}
+
+func TestJournalReplayBatch(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "movie-service-journal")
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "jobs.db")
+
+	server, factory := newTestJournalServer(t, fileName)
+	req := Request{RequestId: "batch", ExchangeName: "movies", RoutingKey: "search"}
+	assert.Equal(t, http.StatusOK, batchRequest(server, "/movies/batch", BatchRequest{Request: req, Ids: []string{"771380589"}}).Code)
+	assert.Eventually(t, func() bool { return len(factory.recorded()) == 1 }, time.Second, time.Millisecond)
+	factory.recorded()[0].done(errors.New("channel closed"))
+	server.Quit()
+
+	server, factory = newTestJournalServer(t, fileName)
+	server.ReplayJournal()
+	assert.Eventually(t, func() bool { return len(factory.recorded()) == 1 }, time.Second, time.Millisecond)
+	batches := factory.recorded()
+	if assert.Equal(t, 1, len(batches)) {
+		assert.Equal(t, req, batches[0].req)
+		assert.Equal(t, BatchQuery{Items: []SearchQuery{{Id: "771380589", Source: SourceUpstream}}, Publish: PublishEach, Concurrency: 4}, batches[0].batch)
+		batches[0].done(nil)
+	}
+	assert.Empty(t, factory.searches)
+	server.Quit()
+
+	server, factory = newTestJournalServer(t, fileName)
+	server.ReplayJournal()
+	assert.Empty(t, factory.recorded())
+	server.Quit()
+}
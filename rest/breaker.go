@@ -0,0 +1,247 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/plar/movie-service/logging"
+)
+
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // calls go to the provider
+	BreakerHalfOpen                     // one trial call at a time decides whether to close the circuit
+	BreakerOpen                         // calls fail immediately
+)
+
+var breakerStateNames = []string{"closed", "half-open", "open"}
+
+func (self BreakerState) String() string {
+	if self < BreakerClosed || self > BreakerOpen {
+		return fmt.Sprintf("state(%d)", int(self))
+	}
+	return breakerStateNames[self]
+}
+
+type BreakerOptions struct {
+	// Consecutive failed calls which open the circuit, 0 - breaker is disabled
+	FailureThreshold int
+
+	// How long the open circuit rejects calls before a trial call is let through
+	OpenTimeout time.Duration
+
+	// Successful trial calls which close the half-open circuit
+	SuccessThreshold int
+}
+
+func DefaultBreakerOptions() BreakerOptions {
+	return BreakerOptions{
+		FailureThreshold: 5,
+		OpenTimeout:      30 * time.Second,
+		SuccessThreshold: 1,
+	}
+}
+
+func (self BreakerOptions) Validate() error {
+	switch {
+	case self.FailureThreshold < 0:
+		return fmt.Errorf("Breaker failure threshold cannot be negative, got %d", self.FailureThreshold)
+	case self.FailureThreshold == 0:
+		return nil
+	case self.OpenTimeout <= 0:
+		return fmt.Errorf("Breaker open timeout must be positive, got %s", self.OpenTimeout)
+	case self.SuccessThreshold < 1:
+		return fmt.Errorf("Breaker success threshold must be positive, got %d", self.SuccessThreshold)
+	}
+	return nil
+}
+
+// CircuitOpenError is returned instead of calling the provider while the circuit is not closed.
+type CircuitOpenError struct {
+	Provider string
+	State    BreakerState
+	RetryIn  time.Duration // 0 - a trial call is in progress
+}
+
+func (self *CircuitOpenError) Error() string {
+	msg := fmt.Sprintf("Upstream provider '%s' is unavailable, circuit breaker is %s", self.Provider, self.State)
+	if self.RetryIn > 0 {
+		msg += fmt.Sprintf(", retry in %s", self.RetryIn.Round(time.Second))
+	}
+	return msg
+}
+
+// breakerClient stops calling the provider after FailureThreshold consecutive failures,
+// so workers are not blocked by a provider which is down.
+type breakerClient struct {
+	sync.Mutex
+	provider  string
+	client    Client
+	options   BreakerOptions
+	metrics   *Metrics
+	now       func() time.Time
+	state     BreakerState
+	failures  int
+	successes int
+	openedAt  time.Time
+	trial     bool // half-open circuit lets one call through at a time
+}
+
+// allow returns error when the call must not reach the provider.
+func (self *breakerClient) allow() error {
+	self.Lock()
+	defer self.Unlock()
+
+	switch self.state {
+	case BreakerOpen:
+		retryIn := self.options.OpenTimeout - self.now().Sub(self.openedAt)
+		if retryIn > 0 {
+			return &CircuitOpenError{Provider: self.provider, State: BreakerOpen, RetryIn: retryIn}
+		}
+		self.setState(BreakerHalfOpen)
+		fallthrough
+	case BreakerHalfOpen:
+		if self.trial {
+			return &CircuitOpenError{Provider: self.provider, State: BreakerHalfOpen}
+		}
+		self.trial = true
+	}
+	return nil
+}
+
+// record updates the state with the result of the call which was allowed.
+func (self *breakerClient) record(err error) {
+	self.Lock()
+	defer self.Unlock()
+
+	if self.state == BreakerHalfOpen {
+		self.trial = false
+		if err != nil {
+			self.open()
+			return
+		}
+		self.successes++
+		if self.successes >= self.options.SuccessThreshold {
+			self.setState(BreakerClosed)
+		}
+		return
+	}
+
+	if err == nil {
+		self.failures = 0
+		return
+	}
+	self.failures++
+	if self.options.FailureThreshold > 0 && self.failures >= self.options.FailureThreshold && self.state == BreakerClosed {
+		self.open()
+	}
+}
+
+func (self *breakerClient) open() {
+	self.openedAt = self.now()
+	self.setState(BreakerOpen)
+}
+
+// setState resets the counters of the new state, the caller holds the lock.
+func (self *breakerClient) setState(state BreakerState) {
+	if state == self.state {
+		return
+	}
+	log.Warnf("Upstream circuit breaker is %s, provider=%s, previous=%s, failures=%d", state, self.provider, self.state, self.failures)
+	self.state = state
+	self.failures, self.successes, self.trial = 0, 0, false
+	self.metrics.CircuitStateChanged(self.provider, state)
+}
+
+func (self *breakerClient) Search(query string) ([]Movie, error) {
+	if err := self.allow(); err != nil {
+		self.metrics.CircuitRejected(self.provider)
+		return nil, err
+	}
+	movies, err := self.client.Search(query)
+	self.record(err)
+	return movies, err
+}
+
+func (self *breakerClient) Movie(id string) (*Movie, error) {
+	return self.lookup(func() (*Movie, error) { return getMovie(self.client, id) })
+}
+
+func (self *breakerClient) MovieByImdb(imdbId string) (*Movie, error) {
+	return self.lookup(func() (*Movie, error) { return getMovieByImdb(self.client, imdbId) })
+}
+
+// lookup calls the provider when the circuit allows it, unknown movies are not failures of the provider.
+func (self *breakerClient) lookup(get func() (*Movie, error)) (*Movie, error) {
+	if err := self.allow(); err != nil {
+		self.metrics.CircuitRejected(self.provider)
+		return nil, err
+	}
+	movie, err := get()
+	if errors.Is(err, ErrMovieNotFound) {
+		self.record(nil)
+	} else {
+		self.record(err)
+	}
+	return movie, err
+}
+
+func (self *breakerClient) Ping() error {
+	if pinger, ok := self.client.(Pinger); ok {
+		return pinger.Ping()
+	}
+	return nil
+}
+
+func (self *breakerClient) State() BreakerState {
+	self.Lock()
+	defer self.Unlock()
+	return self.state
+}
+
+// Check reports error while the circuit rejects calls, used by the readiness probe. The circuit whose
+// open timeout expired is ready, the next call is the trial, so the readiness does not wait for the traffic.
+func (self *breakerClient) Check() error {
+	self.Lock()
+	defer self.Unlock()
+
+	switch self.state {
+	case BreakerOpen:
+		if retryIn := self.options.OpenTimeout - self.now().Sub(self.openedAt); retryIn > 0 {
+			return &CircuitOpenError{Provider: self.provider, State: BreakerOpen, RetryIn: retryIn}
+		}
+	case BreakerHalfOpen:
+		if self.trial {
+			return &CircuitOpenError{Provider: self.provider, State: BreakerHalfOpen}
+		}
+	}
+	return nil
+}
+
+// SetOptions applies new thresholds, disabled breaker closes the circuit.
+func (self *breakerClient) SetOptions(options BreakerOptions) {
+	self.Lock()
+	defer self.Unlock()
+
+	self.options = options
+	if options.FailureThreshold == 0 {
+		self.setState(BreakerClosed)
+	}
+}
+
+// Reset closes the circuit, e.g. after the provider client is replaced.
+func (self *breakerClient) Reset() {
+	self.Lock()
+	defer self.Unlock()
+
+	self.setState(BreakerClosed)
+	self.failures = 0
+}
+
+func newBreakerClient(provider string, client Client, options BreakerOptions, metrics *Metrics) *breakerClient {
+	metrics.CircuitState(provider, BreakerClosed)
+	return &breakerClient{provider: provider, client: client, options: options, metrics: metrics, now: time.Now}
+}
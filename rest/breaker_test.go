@@ -0,0 +1,227 @@
+package rest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// scriptedClient returns the next error of the script on every search, nil when the script is over.
+type scriptedClient struct {
+	errs  []error
+	calls int
+	block chan struct{} // optional, search waits for it
+}
+
+func (self *scriptedClient) Search(query string) ([]Movie, error) {
+	if self.block != nil {
+		<-self.block
+	}
+	self.calls++
+	if len(self.errs) == 0 {
+		return []Movie{{Id: "771380589", Title: "The Martian"}}, nil
+	}
+	err := self.errs[0]
+	self.errs = self.errs[1:]
+	return nil, err
+}
+
+type testClock struct {
+	now time.Time
+}
+
+func (self *testClock) Now() time.Time {
+	return self.now
+}
+
+func newTestBreaker(client Client, options BreakerOptions) (*breakerClient, *testClock, *Metrics) {
+	clock := &testClock{now: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)}
+	metrics := NewMetrics()
+	breaker := newBreakerClient(rottenTomatoesProvider, client, options, metrics)
+	breaker.now = clock.Now
+	return breaker, clock, metrics
+}
+
+func TestBreakerOptionsValidate(t *testing.T) {
+	assert.NoError(t, DefaultBreakerOptions().Validate())
+	assert.NoError(t, BreakerOptions{}.Validate())
+	assert.EqualError(t, BreakerOptions{FailureThreshold: -1}.Validate(), "Breaker failure threshold cannot be negative, got -1")
+	assert.EqualError(t, BreakerOptions{FailureThreshold: 1, SuccessThreshold: 1}.Validate(), "Breaker open timeout must be positive, got 0s")
+	assert.EqualError(t, BreakerOptions{FailureThreshold: 1, OpenTimeout: time.Second}.Validate(), "Breaker success threshold must be positive, got 0")
+}
+
+func TestBreakerOpensAfterFailures(t *testing.T) {
+	apiErr := errors.New("api error, response code: 500")
+	client := &scriptedClient{errs: []error{apiErr, nil, apiErr, apiErr, apiErr}}
+	breaker, clock, metrics := newTestBreaker(client, BreakerOptions{FailureThreshold: 3, OpenTimeout: 30 * time.Second, SuccessThreshold: 1})
+
+	// success resets the consecutive failures
+	for i := 0; i < 4; i++ {
+		breaker.Search("martian")
+	}
+	assert.Equal(t, BreakerClosed, breaker.State())
+	assert.NoError(t, breaker.Check())
+
+	_, err := breaker.Search("martian")
+	assert.Equal(t, apiErr, err)
+	assert.Equal(t, BreakerOpen, breaker.State())
+
+	clock.now = clock.now.Add(10 * time.Second)
+	movies, err := breaker.Search("martian")
+	assert.Nil(t, movies)
+	assert.Equal(t, &CircuitOpenError{Provider: rottenTomatoesProvider, State: BreakerOpen, RetryIn: 20 * time.Second}, err)
+	assert.EqualError(t, err, "Upstream provider 'rottentomatoes' is unavailable, circuit breaker is open, retry in 20s")
+	assert.EqualError(t, breaker.Check(), "Upstream provider 'rottentomatoes' is unavailable, circuit breaker is open, retry in 20s")
+	assert.Equal(t, 5, client.calls)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.circuitState.WithLabelValues(rottenTomatoesProvider)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.circuitTransitions.WithLabelValues(rottenTomatoesProvider, "open")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.circuitRejections.WithLabelValues(rottenTomatoesProvider)))
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	apiErr := errors.New("api error, response code: 500")
+	client := &scriptedClient{errs: []error{apiErr, apiErr}}
+	breaker, clock, metrics := newTestBreaker(client, BreakerOptions{FailureThreshold: 1, OpenTimeout: 30 * time.Second, SuccessThreshold: 2})
+
+	breaker.Search("martian")
+	assert.Equal(t, BreakerOpen, breaker.State())
+
+	// failed trial opens the circuit again
+	clock.now = clock.now.Add(30 * time.Second)
+	_, err := breaker.Search("martian")
+	assert.Equal(t, apiErr, err)
+	assert.Equal(t, BreakerOpen, breaker.State())
+	assert.Equal(t, 2, client.calls)
+
+	// successful trials close the circuit
+	clock.now = clock.now.Add(30 * time.Second)
+	movies, err := breaker.Search("martian")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(movies))
+	assert.Equal(t, BreakerHalfOpen, breaker.State())
+	assert.NoError(t, breaker.Check())
+
+	breaker.Search("martian")
+	assert.Equal(t, BreakerClosed, breaker.State())
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.circuitState.WithLabelValues(rottenTomatoesProvider)))
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.circuitTransitions.WithLabelValues(rottenTomatoesProvider, "half-open")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.circuitTransitions.WithLabelValues(rottenTomatoesProvider, "closed")))
+}
+
+func TestBreakerHalfOpenSingleTrial(t *testing.T) {
+	client := &scriptedClient{errs: []error{errors.New("api error, response code: 500")}}
+	breaker, clock, _ := newTestBreaker(client, BreakerOptions{FailureThreshold: 1, OpenTimeout: time.Second, SuccessThreshold: 1})
+	breaker.Search("martian")
+
+	clock.now = clock.now.Add(time.Second)
+	client.block = make(chan struct{})
+	trial := make(chan error)
+	go func() {
+		_, err := breaker.Search("martian")
+		trial <- err
+	}()
+
+	// other searches fail while the trial is in progress
+	assert.Eventually(t, func() bool { return breaker.State() == BreakerHalfOpen }, time.Second, time.Millisecond)
+	_, err := breaker.Search("martian")
+	assert.Equal(t, &CircuitOpenError{Provider: rottenTomatoesProvider, State: BreakerHalfOpen}, err)
+	assert.EqualError(t, breaker.Check(), "Upstream provider 'rottentomatoes' is unavailable, circuit breaker is half-open")
+
+	close(client.block)
+	assert.NoError(t, <-trial)
+	assert.Equal(t, BreakerClosed, breaker.State())
+	assert.Equal(t, 2, client.calls)
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	client := &scriptedClient{errs: []error{errors.New("timeout"), errors.New("timeout"), errors.New("timeout")}}
+	breaker, _, _ := newTestBreaker(client, BreakerOptions{FailureThreshold: 1, OpenTimeout: time.Minute, SuccessThreshold: 1})
+
+	breaker.Search("martian")
+	assert.Equal(t, BreakerOpen, breaker.State())
+
+	breaker.SetOptions(BreakerOptions{})
+	assert.Equal(t, BreakerClosed, breaker.State())
+	for i := 0; i < 2; i++ {
+		_, err := breaker.Search("martian")
+		assert.EqualError(t, err, "timeout")
+	}
+	assert.Equal(t, BreakerClosed, breaker.State())
+	assert.Equal(t, 3, client.calls)
+}
+
+func TestBreakerReset(t *testing.T) {
+	client := &scriptedClient{errs: []error{errors.New("timeout")}}
+	breaker, _, _ := newTestBreaker(client, BreakerOptions{FailureThreshold: 1, OpenTimeout: time.Minute, SuccessThreshold: 1})
+
+	breaker.Search("martian")
+	assert.Equal(t, BreakerOpen, breaker.State())
+	breaker.Reset()
+	assert.Equal(t, BreakerClosed, breaker.State())
+
+	_, err := breaker.Search("martian")
+	assert.NoError(t, err)
+}
+
+func TestSearchResponseErrorCode(t *testing.T) {
+	resp := NewSearchResponseError("RequestId", &CircuitOpenError{Provider: rottenTomatoesProvider, State: BreakerHalfOpen})
+	assert.Equal(t, CodeUpstreamUnavailable, resp.Meta.Code)
+
+	resp = NewSearchResponseError("RequestId", ErrMovieNotFound)
+	assert.Equal(t, CodeMovieNotFound, resp.Meta.Code)
+
+	resp = NewSearchResponseError("RequestId", errors.New("api error, response code: 500"))
+	assert.Empty(t, resp.Meta.Code)
+}
+
+// movieClient looks up the movies it has, the searches find nothing.
+type movieClient struct {
+	movies map[string]Movie
+	err    error // returned by every lookup when set
+	calls  int
+}
+
+func (self *movieClient) Search(query string) ([]Movie, error) {
+	return nil, nil
+}
+
+func (self *movieClient) Movie(id string) (*Movie, error) {
+	self.calls++
+	if self.err != nil {
+		return nil, self.err
+	}
+	movie, ok := self.movies[id]
+	if !ok {
+		return nil, ErrMovieNotFound
+	}
+	return &movie, nil
+}
+
+func TestBreakerMovie(t *testing.T) {
+	client := &movieClient{movies: map[string]Movie{"771380589": {Id: "771380589", Title: "The Martian"}}}
+	breaker, _, _ := newTestBreaker(client, BreakerOptions{FailureThreshold: 2, OpenTimeout: 30 * time.Second, SuccessThreshold: 1})
+
+	movie, err := breaker.Movie("771380589")
+	assert.NoError(t, err)
+	assert.Equal(t, "The Martian", movie.Title)
+
+	// unknown ids are not failures of the provider
+	for i := 0; i < 3; i++ {
+		_, err = breaker.Movie("404")
+		assert.Equal(t, ErrMovieNotFound, err)
+	}
+	assert.Equal(t, BreakerClosed, breaker.State())
+
+	client.err = errors.New("api error, response code: 500")
+	breaker.Movie("771380589")
+	breaker.Movie("771380589")
+	assert.Equal(t, BreakerOpen, breaker.State())
+
+	_, err = breaker.Movie("771380589")
+	assert.IsType(t, &CircuitOpenError{}, err)
+	assert.Equal(t, 6, client.calls)
+}
@@ -0,0 +1,51 @@
+package rest
+
+import (
+	"crypto/tls"
+
+	"github.com/streadway/amqp"
+)
+
+// Channel is the part of the AMQP channel used to declare exchanges and publish responses.
+type Channel interface {
+	exchangeDeclarer
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Close() error
+}
+
+// Connection is a connection to the message broker.
+type Connection interface {
+	Channel() (Channel, error)
+	Close() error
+}
+
+// Dialer opens connections to the message broker, TLS config is used for amqps:// URIs only.
+type Dialer func(uri string, config *tls.Config) (Connection, error)
+
+// amqpConnection adapts *amqp.Connection to Connection.
+type amqpConnection struct {
+	*amqp.Connection
+}
+
+func (self amqpConnection) Channel() (Channel, error) {
+	ch, err := self.Connection.Channel()
+	if err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// DialMessageQueue connects to RabbitMQ, it is the default Dialer.
+func DialMessageQueue(uri string, config *tls.Config) (Connection, error) {
+	if config != nil {
+		// amqp sets server name of the passed config
+		config = config.Clone()
+	}
+	conn, err := amqp.DialTLS(uri, config)
+	if err != nil {
+		return nil, err
+	}
+	return amqpConnection{conn}, nil
+}
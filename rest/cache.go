@@ -0,0 +1,267 @@
+package rest
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/plar/movie-service/logging"
+)
+
+// searchCache is the cache label of the search results in metrics
+const searchCache = "search"
+
+type CacheOptions struct {
+	// Max age of the cached results served when the provider fails, 0 - no cache
+	MaxStale time.Duration
+
+	// Max number of cached queries, the least recently used ones are evicted
+	MaxEntries int
+
+	// How often the queries answered from the cache are searched again in the background
+	RefreshInterval time.Duration
+}
+
+func DefaultCacheOptions() CacheOptions {
+	return CacheOptions{
+		MaxStale:        24 * time.Hour,
+		MaxEntries:      10000,
+		RefreshInterval: time.Minute,
+	}
+}
+
+func (self CacheOptions) Validate() error {
+	switch {
+	case self.MaxStale < 0:
+		return fmt.Errorf("Cache max stale cannot be negative, got %s", self.MaxStale)
+	case self.MaxStale == 0:
+		return nil
+	case self.MaxEntries < 1:
+		return fmt.Errorf("Cache max entries must be positive, got %d", self.MaxEntries)
+	case self.RefreshInterval <= 0:
+		return fmt.Errorf("Cache refresh interval must be positive, got %s", self.RefreshInterval)
+	}
+	return nil
+}
+
+// StaleSearcher is implemented by the clients which fall back to cached results when the provider fails.
+type StaleSearcher interface {
+	// SearchStale returns stale and age of the cached results served instead of the failed search.
+	SearchStale(query string) (movies []Movie, stale bool, age time.Duration, err error)
+}
+
+type cacheEntry struct {
+	key    string
+	movies []Movie
+	stored time.Time
+}
+
+// cachingClient keeps the last results of every query and serves them while the provider fails,
+// the failed queries are searched again in the background until the cache is refreshed.
+type cachingClient struct {
+	sync.Mutex
+	client     Client
+	options    CacheOptions
+	metrics    *Metrics
+	now        func() time.Time
+	entries    map[string]*list.Element
+	lru        *list.List        // front - the most recently used
+	pending    map[string]string // key -> query of the queries to refresh
+	refreshing bool
+	closed     chan struct{}
+	closeOnce  sync.Once
+}
+
+func cacheKey(query string) string {
+	return strings.ToLower(strings.TrimSpace(query))
+}
+
+func (self *cachingClient) Search(query string) ([]Movie, error) {
+	movies, _, _, err := self.SearchStale(query)
+	return movies, err
+}
+
+func (self *cachingClient) SearchStale(query string) ([]Movie, bool, time.Duration, error) {
+	movies, err := self.client.Search(query)
+	if err == nil {
+		self.store(query, movies)
+		return movies, false, 0, nil
+	}
+
+	entry, age, ok := self.lookup(query)
+	if !ok {
+		self.metrics.ObserveCache(searchCache, CacheMiss)
+		return nil, false, 0, err
+	}
+	self.metrics.ObserveCache(searchCache, CacheHit)
+	self.scheduleRefresh(query)
+	return entry.movies, true, age, nil
+}
+
+// Movie looks up the movie with the wrapped client, the movies are not cached.
+func (self *cachingClient) Movie(id string) (*Movie, error) {
+	return getMovie(self.client, id)
+}
+
+func (self *cachingClient) MovieByImdb(imdbId string) (*Movie, error) {
+	return getMovieByImdb(self.client, imdbId)
+}
+
+func (self *cachingClient) Ping() error {
+	if pinger, ok := self.client.(Pinger); ok {
+		return pinger.Ping()
+	}
+	return nil
+}
+
+func (self *cachingClient) store(query string, movies []Movie) {
+	self.Lock()
+	defer self.Unlock()
+
+	if self.options.MaxStale == 0 {
+		return
+	}
+
+	key := cacheKey(query)
+	delete(self.pending, key)
+	if element, ok := self.entries[key]; ok {
+		element.Value = &cacheEntry{key, movies, self.now()}
+		self.lru.MoveToFront(element)
+		return
+	}
+	self.entries[key] = self.lru.PushFront(&cacheEntry{key, movies, self.now()})
+	self.evict()
+}
+
+// evict removes the least recently used entries over the limit, the caller holds the lock.
+func (self *cachingClient) evict() {
+	for self.lru.Len() > self.options.MaxEntries {
+		entry := self.lru.Remove(self.lru.Back()).(*cacheEntry)
+		delete(self.entries, entry.key)
+		delete(self.pending, entry.key)
+	}
+}
+
+// lookup returns the entry which is not older than MaxStale.
+func (self *cachingClient) lookup(query string) (*cacheEntry, time.Duration, bool) {
+	self.Lock()
+	defer self.Unlock()
+
+	element, ok := self.entries[cacheKey(query)]
+	if !ok {
+		return nil, 0, false
+	}
+	entry := element.Value.(*cacheEntry)
+	age := self.now().Sub(entry.stored)
+	if age > self.options.MaxStale {
+		self.lru.Remove(element)
+		delete(self.entries, entry.key)
+		return nil, 0, false
+	}
+	self.lru.MoveToFront(element)
+	return entry, age, true
+}
+
+func (self *cachingClient) scheduleRefresh(query string) {
+	self.Lock()
+	defer self.Unlock()
+
+	self.pending[cacheKey(query)] = query
+	if !self.refreshing {
+		self.refreshing = true
+		go self.refreshLoop()
+	}
+}
+
+// refreshLoop searches the pending queries every RefreshInterval, it exits when there are none.
+func (self *cachingClient) refreshLoop() {
+	for {
+		self.Lock()
+		interval := self.options.RefreshInterval
+		self.Unlock()
+
+		select {
+		case <-self.closed:
+			return
+		case <-time.After(interval):
+		}
+
+		if !self.refresh() {
+			return
+		}
+	}
+}
+
+// refresh searches the pending queries until the first failure, false - nothing is left to refresh.
+func (self *cachingClient) refresh() bool {
+	for _, query := range self.pendingQueries() {
+		movies, err := self.client.Search(query)
+		if err != nil {
+			log.With(log.Fields{log.FieldQuery: query}).Debugf("Cannot refresh cached results, error=%s", err)
+			break
+		}
+		self.store(query, movies)
+	}
+
+	self.Lock()
+	defer self.Unlock()
+	if len(self.pending) == 0 {
+		self.refreshing = false
+		return false
+	}
+	return true
+}
+
+// pendingQueries returns the queries to refresh, the expired ones are dropped.
+func (self *cachingClient) pendingQueries() []string {
+	self.Lock()
+	defer self.Unlock()
+
+	queries := make([]string, 0, len(self.pending))
+	for key, query := range self.pending {
+		element, ok := self.entries[key]
+		if !ok || self.now().Sub(element.Value.(*cacheEntry).stored) > self.options.MaxStale {
+			delete(self.pending, key)
+			continue
+		}
+		queries = append(queries, query)
+	}
+	return queries
+}
+
+// SetOptions applies new limits, the cache is cleared when it is disabled.
+func (self *cachingClient) SetOptions(options CacheOptions) {
+	self.Lock()
+	defer self.Unlock()
+
+	self.options = options
+	if options.MaxStale == 0 {
+		self.entries = make(map[string]*list.Element)
+		self.lru.Init()
+		self.pending = make(map[string]string)
+		return
+	}
+	self.evict()
+}
+
+// Close stops the background refresh.
+func (self *cachingClient) Close() {
+	self.closeOnce.Do(func() {
+		close(self.closed)
+	})
+}
+
+func newCachingClient(client Client, options CacheOptions, metrics *Metrics) *cachingClient {
+	return &cachingClient{
+		client:  client,
+		options: options,
+		metrics: metrics,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		pending: make(map[string]string),
+		closed:  make(chan struct{}),
+	}
+}
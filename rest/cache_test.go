@@ -0,0 +1,159 @@
+package rest
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// switchingClient fails while the error is set, otherwise returns movies titled by the query.
+type switchingClient struct {
+	sync.Mutex
+	err     error
+	queries []string
+}
+
+func (self *switchingClient) Search(query string) ([]Movie, error) {
+	self.Lock()
+	defer self.Unlock()
+	self.queries = append(self.queries, query)
+	if self.err != nil {
+		return nil, self.err
+	}
+	return []Movie{{Id: "1", Title: query}}, nil
+}
+
+func (self *switchingClient) Fail(err error) {
+	self.Lock()
+	defer self.Unlock()
+	self.err = err
+}
+
+func (self *switchingClient) Queries() []string {
+	self.Lock()
+	defer self.Unlock()
+	return append([]string(nil), self.queries...)
+}
+
+func newTestCache(client Client, options CacheOptions) (*cachingClient, *testClock, *Metrics) {
+	clock := &testClock{now: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)}
+	metrics := NewMetrics()
+	cache := newCachingClient(client, options, metrics)
+	cache.now = clock.Now
+	return cache, clock, metrics
+}
+
+func TestCacheOptionsValidate(t *testing.T) {
+	assert.NoError(t, DefaultCacheOptions().Validate())
+	assert.NoError(t, CacheOptions{}.Validate())
+	assert.EqualError(t, CacheOptions{MaxStale: -time.Second}.Validate(), "Cache max stale cannot be negative, got -1s")
+	assert.EqualError(t, CacheOptions{MaxStale: time.Hour, RefreshInterval: time.Minute}.Validate(), "Cache max entries must be positive, got 0")
+	assert.EqualError(t, CacheOptions{MaxStale: time.Hour, MaxEntries: 1}.Validate(), "Cache refresh interval must be positive, got 0s")
+}
+
+func TestCacheServesStaleResults(t *testing.T) {
+	client := &switchingClient{}
+	cache, clock, metrics := newTestCache(client, CacheOptions{MaxStale: 24 * time.Hour, MaxEntries: 10, RefreshInterval: time.Hour})
+	defer cache.Close()
+
+	movies, stale, age, err := cache.SearchStale("Martian")
+	assert.NoError(t, err)
+	assert.False(t, stale)
+	assert.Equal(t, time.Duration(0), age)
+	assert.Equal(t, []Movie{{Id: "1", Title: "Martian"}}, movies)
+
+	apiErr := errors.New("api error, response code: 500")
+	client.Fail(apiErr)
+	clock.now = clock.now.Add(3 * time.Hour)
+
+	// queries are matched ignoring case and spaces
+	movies, stale, age, err = cache.SearchStale(" martian")
+	assert.NoError(t, err)
+	assert.True(t, stale)
+	assert.Equal(t, 3*time.Hour, age)
+	assert.Equal(t, []Movie{{Id: "1", Title: "Martian"}}, movies)
+
+	_, err = cache.Search("Alien")
+	assert.Equal(t, apiErr, err)
+
+	// too old results are not served
+	clock.now = clock.now.Add(22 * time.Hour)
+	_, stale, _, err = cache.SearchStale("Martian")
+	assert.Equal(t, apiErr, err)
+	assert.False(t, stale)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.cacheRequests.WithLabelValues(searchCache, CacheHit)))
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.cacheRequests.WithLabelValues(searchCache, CacheMiss)))
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	client := &switchingClient{}
+	cache, _, _ := newTestCache(client, CacheOptions{MaxStale: time.Hour, MaxEntries: 2, RefreshInterval: time.Hour})
+	defer cache.Close()
+
+	cache.Search("Martian")
+	cache.Search("Alien")
+	cache.Search("Martian")
+	cache.Search("Matrix")
+
+	client.Fail(errors.New("timeout"))
+	_, stale, _, _ := cache.SearchStale("Martian")
+	assert.True(t, stale)
+	_, stale, _, _ = cache.SearchStale("Matrix")
+	assert.True(t, stale)
+	_, stale, _, _ = cache.SearchStale("Alien")
+	assert.False(t, stale)
+
+	cache.SetOptions(CacheOptions{MaxStale: time.Hour, MaxEntries: 1, RefreshInterval: time.Hour})
+	_, stale, _, _ = cache.SearchStale("Martian")
+	assert.False(t, stale)
+
+	// disabled cache keeps nothing
+	cache.SetOptions(CacheOptions{})
+	_, stale, _, _ = cache.SearchStale("Matrix")
+	assert.False(t, stale)
+}
+
+func TestCacheRefreshesInBackground(t *testing.T) {
+	client := &switchingClient{}
+	cache, clock, _ := newTestCache(client, CacheOptions{MaxStale: time.Hour, MaxEntries: 10, RefreshInterval: 10 * time.Millisecond})
+	defer cache.Close()
+
+	cache.Search("Martian")
+	client.Fail(errors.New("timeout"))
+	clock.now = clock.now.Add(time.Minute)
+	_, stale, _, _ := cache.SearchStale("Martian")
+	assert.True(t, stale)
+
+	// the refresh is retried until the provider answers
+	assert.Eventually(t, func() bool { return len(client.Queries()) >= 4 }, time.Second, time.Millisecond)
+	client.Fail(nil)
+	assert.Eventually(t, func() bool {
+		cache.Lock()
+		defer cache.Unlock()
+		return !cache.refreshing
+	}, time.Second, time.Millisecond)
+
+	client.Fail(errors.New("timeout"))
+	_, stale, age, _ := cache.SearchStale("Martian")
+	assert.True(t, stale)
+	assert.Equal(t, time.Duration(0), age)
+}
+
+func TestCacheCloseStopsRefresh(t *testing.T) {
+	client := &switchingClient{err: errors.New("timeout")}
+	cache, _, _ := newTestCache(client, CacheOptions{MaxStale: time.Hour, MaxEntries: 10, RefreshInterval: time.Millisecond})
+
+	cache.store("Martian", nil)
+	cache.SearchStale("Martian")
+	cache.Close()
+	time.Sleep(20 * time.Millisecond)
+
+	queries := len(client.Queries())
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, queries, len(client.Queries()))
+}
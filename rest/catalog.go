@@ -0,0 +1,143 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/plar/movie-service/catalog"
+	log "github.com/plar/movie-service/logging"
+)
+
+const (
+	// localProvider is the provider label of the searches answered from the local catalog
+	localProvider = "local"
+
+	// max number of movies returned by the local search
+	localSearchLimit = 30
+
+	// max number of the spelling corrections searched when the query has no results
+	maxSuggestions = 3
+)
+
+// catalogingClient stores the movies found by the provider in the local catalog.
+type catalogingClient struct {
+	client Client
+	store  catalog.Catalog
+}
+
+func (self *catalogingClient) Search(query string) ([]Movie, error) {
+	movies, err := self.client.Search(query)
+	if err == nil && len(movies) > 0 {
+		// the search succeeds even when the movies cannot be stored
+		if err := storeMovies(self.store, movies); err != nil {
+			log.With(log.Fields{log.FieldQuery: query}).Errorf("Cannot store movies in the catalog, error=%s", err)
+		}
+	}
+	return movies, err
+}
+
+func (self *catalogingClient) Movie(id string) (*Movie, error) {
+	return self.stored(getMovie(self.client, id))
+}
+
+func (self *catalogingClient) MovieByImdb(imdbId string) (*Movie, error) {
+	return self.stored(getMovieByImdb(self.client, imdbId))
+}
+
+// stored stores the looked up movie, the lookup succeeds even when the movie cannot be stored.
+func (self *catalogingClient) stored(movie *Movie, err error) (*Movie, error) {
+	if err == nil {
+		if err := storeMovies(self.store, []Movie{*movie}); err != nil {
+			log.With(log.Fields{fieldMovieId: movie.Id}).Errorf("Cannot store movies in the catalog, error=%s", err)
+		}
+	}
+	return movie, err
+}
+
+func (self *catalogingClient) Ping() error {
+	if pinger, ok := self.client.(Pinger); ok {
+		return pinger.Ping()
+	}
+	return nil
+}
+
+func storeMovies(store catalog.Catalog, movies []Movie) error {
+	docs := make([]catalog.Document, 0, len(movies))
+	for _, movie := range movies {
+		data, err := json.Marshal(movie)
+		if err != nil {
+			return err
+		}
+
+		cast := make([]string, 0, len(movie.Cast))
+		for _, member := range movie.Cast {
+			cast = append(cast, member.Name)
+		}
+		docs = append(docs, catalog.Document{Id: movie.Id, Title: movie.Title, Cast: cast, Synopsis: movie.Synopsis, Data: data})
+	}
+	return store.Put(docs)
+}
+
+// localClient answers the searches from the local catalog, the provider is never called.
+type localClient struct {
+	store catalog.Catalog
+}
+
+func (self *localClient) Search(query string) ([]Movie, error) {
+	matches, err := self.store.Search(query, localSearchLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	var movies []Movie
+	for _, match := range matches {
+		var movie Movie
+		if err := json.Unmarshal(match.Data, &movie); err != nil {
+			return nil, fmt.Errorf("Cannot decode catalog movie %s: %s", match.Id, err)
+		}
+		movies = append(movies, movie)
+	}
+	return movies, nil
+}
+
+func (self *localClient) Movie(id string) (*Movie, error) {
+	data, err := self.store.Get(id)
+	if errors.Is(err, catalog.ErrNotFound) {
+		return nil, ErrMovieNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var movie Movie
+	if err := json.Unmarshal(data, &movie); err != nil {
+		return nil, fmt.Errorf("Cannot decode catalog movie %s: %s", id, err)
+	}
+	return &movie, nil
+}
+
+func (self *localClient) Suggest(query string) ([]string, error) {
+	return self.store.Suggest(query, maxSuggestions)
+}
+
+// NewLocalClient creates client which searches the movies stored in the catalog.
+func NewLocalClient(store catalog.Catalog) Client {
+	return &localClient{store: store}
+}
+
+// searchSource returns the source requested by the source parameter of the search URL.
+func (self *movieServer) searchSource(r *http.Request) (string, *APIError) {
+	switch source := r.URL.Query().Get("source"); source {
+	case "", SourceUpstream:
+		return SourceUpstream, nil
+	case SourceLocal:
+		if self.catalog == nil {
+			return "", NewAPIError(http.StatusServiceUnavailable, CodeCatalogDisabled, "Local catalog is not configured")
+		}
+		return SourceLocal, nil
+	default:
+		return "", NewAPIError(http.StatusBadRequest, CodeInvalidSource, fmt.Sprintf("Unknown source '%s'", source),
+			FieldError{"source", "must be upstream or local"})
+	}
+}
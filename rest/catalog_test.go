@@ -0,0 +1,152 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/plar/movie-service/audit"
+	"github.com/plar/movie-service/catalog"
+	wq "github.com/plar/movie-service/workerqueue"
+)
+
+func openTestCatalog(t *testing.T) (catalog.Catalog, func()) {
+	dir, err := ioutil.TempDir("", "movie-service-catalog")
+	assert.NoError(t, err)
+	store, err := catalog.Open(filepath.Join(dir, "catalog.db"))
+	assert.NoError(t, err)
+	return store, func() {
+		store.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+var martian = Movie{
+	Id:       "771380589",
+	Title:    "The Martian",
+	Ratings:  Ratings{CriticsRating: "Certified Fresh", CriticsScore: 92},
+	Year:     2015,
+	Synopsis: "During a manned mission to Mars, Astronaut Mark Watney is presumed dead.",
+	Cast:     []CastMember{{Name: "Matt Damon", Characters: []string{"Mark Watney"}}},
+}
+
+type fixedClient struct {
+	movies []Movie
+	err    error
+}
+
+func (self *fixedClient) Search(query string) ([]Movie, error) {
+	return self.movies, self.err
+}
+
+func TestCatalogingClientStoresMovies(t *testing.T) {
+	store, cleanup := openTestCatalog(t)
+	defer cleanup()
+
+	client := &catalogingClient{client: &fixedClient{movies: []Movie{martian}}, store: store}
+	movies, err := client.Search("martian")
+	assert.NoError(t, err)
+	assert.Equal(t, []Movie{martian}, movies)
+
+	// failed searches do not change the catalog
+	client.client = &fixedClient{err: errors.New("timeout")}
+	_, err = client.Search("martian")
+	assert.EqualError(t, err, "timeout")
+
+	local := NewLocalClient(store)
+	for _, query := range []string{"Martian", "matt damon", "MARS"} {
+		movies, err = local.Search(query)
+		assert.NoError(t, err)
+		assert.Equal(t, []Movie{martian}, movies, query)
+	}
+
+	movies, err = local.Search("alien")
+	assert.NoError(t, err)
+	assert.Nil(t, movies)
+}
+
+func TestCatalogingClientStoresMovie(t *testing.T) {
+	store, cleanup := openTestCatalog(t)
+	defer cleanup()
+	local := NewLocalClient(store).(MovieGetter)
+
+	_, err := local.Movie(martian.Id)
+	assert.Equal(t, ErrMovieNotFound, err)
+
+	client := &catalogingClient{client: &movieClient{movies: map[string]Movie{martian.Id: martian}}, store: store}
+	movie, err := client.Movie(martian.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, &martian, movie)
+
+	movie, err = local.Movie(martian.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, &martian, movie)
+}
+
+func TestNewSearchLocalSource(t *testing.T) {
+	store, cleanup := openTestCatalog(t)
+	defer cleanup()
+	assert.NoError(t, storeMovies(store, []Movie{martian}))
+
+	workerQueue := make(wq.WorkerQueue, 1)
+	pool, _ := wq.NewPool(workerQueue, 1)
+	defer pool.Stop()
+
+	mq := &testmqAndClientImpl{}
+	auditLog := &recordingAuditLogger{}
+	factory := NewJobFactoryWithCatalog(mq, mq, NewLocalClient(store), workerQueue, auditLog)
+
+	published := make(chan error)
+	req := Request{RequestId: "RequestId"}
+	factory.NewSearch(context.Background(), req, SearchQuery{Text: "damon", Source: SourceLocal}, func(err error) { published <- err })
+	assert.NoError(t, <-published)
+
+	// the upstream client is not called
+	assert.Empty(t, mq.query)
+	assert.Equal(t, NewSearchResponseSuccess("RequestId", []Movie{martian}), mq.resp)
+	assert.Equal(t, localProvider, auditLog.Events()[0].Provider)
+
+	// local searches fail without the catalog
+	factory = NewJobFactoryWithAudit(mq, mq, workerQueue, audit.NewNopLogger())
+	factory.NewSearch(context.Background(), req, SearchQuery{Text: "damon", Source: SourceLocal}, func(err error) { published <- err })
+	assert.NoError(t, <-published)
+	assert.Equal(t, NewSearchResponseError("RequestId", errors.New("Local catalog is not configured")), mq.resp)
+}
+
+func TestSearchSource(t *testing.T) {
+	store, cleanup := openTestCatalog(t)
+	defer cleanup()
+
+	search := func(server *movieServer, source string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(Request{RequestId: "RequestId", ExchangeName: "movies", RoutingKey: "search"})
+		req, _ := http.NewRequest("POST", "http://movie-search.devel/movies?q=martian&source="+source, bytes.NewReader(body))
+		recorder := httptest.NewRecorder()
+		server.Router().ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	server := &movieServer{catalog: store}
+	source, apiErr := server.searchSource(httptest.NewRequest("POST", "/movies?q=martian&source=local", nil))
+	assert.Nil(t, apiErr)
+	assert.Equal(t, SourceLocal, source)
+
+	ctx := NewTestMovieServerContext()
+	ms, _ := NewMovieServer(ctx)
+	recorder := search(ms.(*movieServer), "local")
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assertErrorResponse(t, recorder, ErrorResponse{Meta: Meta{Status: ERROR, Code: CodeCatalogDisabled, Error: "Local catalog is not configured"}})
+
+	recorder = search(ms.(*movieServer), "remote")
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assertErrorResponse(t, recorder, ErrorResponse{Meta: Meta{Status: ERROR, Code: CodeInvalidSource, Error: "Unknown source 'remote'",
+		Errors: []FieldError{{"source", "must be upstream or local"}}}})
+}
@@ -1,18 +1,75 @@
 package rest
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/rojters/rottentomatoes"
 )
 
+const (
+	rottenTomatoesHost    = "api.rottentomatoes.com"
+	rottenTomatoesPingURL = "http://" + rottenTomatoesHost + "/api/public/v1.0.json"
+	rottenTomatoesURL     = "http://" + rottenTomatoesHost + "/api/public/v1.0"
+
+	// DefaultUpstreamTimeout limits one request to the provider, so a hung provider fails the call and opens the circuit
+	DefaultUpstreamTimeout = 10 * time.Second
+)
+
+// fieldMovieId is the log field with the provider id of the looked up movie
+const fieldMovieId = "movie_id"
+
+// ErrMovieNotFound is returned by MovieGetter when the provider has no movie with the id.
+var ErrMovieNotFound = errors.New("Movie not found")
+
 type Client interface {
 	Search(query string) ([]Movie, error)
 }
 
+// Pinger is implemented by the clients which can cheaply check that provider is reachable.
+type Pinger interface {
+	Ping() error
+}
+
+// MovieGetter is implemented by the clients which can look up the movie by the provider id.
+type MovieGetter interface {
+	Movie(id string) (*Movie, error)
+}
+
+// ImdbGetter is implemented by the clients which can look up the movie by its IMDb id.
+type ImdbGetter interface {
+	MovieByImdb(imdbId string) (*Movie, error)
+}
+
+// getMovieByImdb looks up the movie with the client, error when the client cannot look up IMDb ids.
+func getMovieByImdb(client Client, imdbId string) (*Movie, error) {
+	getter, ok := client.(ImdbGetter)
+	if !ok {
+		return nil, errors.New("IMDb lookup is not supported by the provider")
+	}
+	return getter.MovieByImdb(imdbId)
+}
+
+// getMovie looks up the movie with the client, error when the client cannot look up movies.
+func getMovie(client Client, id string) (*Movie, error) {
+	getter, ok := client.(MovieGetter)
+	if !ok {
+		return nil, errors.New("Movie lookup is not supported by the provider")
+	}
+	return getter.Movie(id)
+}
+
 type client struct {
-	client *rottentomatoes.Client
+	client     *rottentomatoes.Client
+	httpClient *http.Client
+	apiKey     string
 }
 
 func rottenRatingsToRatings(r rottentomatoes.Ratings) Ratings {
@@ -24,10 +81,18 @@ func rottenRatingsToRatings(r rottentomatoes.Ratings) Ratings {
 	}
 }
 
+func rottenCastToCast(cast []rottentomatoes.Cast) []CastMember {
+	var members []CastMember
+	for _, c := range cast {
+		members = append(members, CastMember{Name: c.Name, Characters: c.Characters})
+	}
+	return members
+}
+
 func (c *client) Search(query string) ([]Movie, error) {
 	resp, err := c.client.Search.MovieSearch(query, nil)
 	if err != nil {
-		return nil, err
+		return nil, redactURL(err)
 	}
 
 	if resp.Total == 0 {
@@ -36,19 +101,197 @@ func (c *client) Search(query string) ([]Movie, error) {
 
 	var movies []Movie
 	for _, movie := range resp.Movies {
-		movies = append(movies, Movie{
-			Id:      strconv.FormatInt(int64(movie.Id), 10),
-			Title:   movie.Title,
-			Ratings: rottenRatingsToRatings(movie.Ratings),
-		})
+		movies = append(movies, rottenMovieToMovie(strconv.FormatInt(int64(movie.Id), 10), movie))
 	}
 
 	return movies, nil
 }
 
+func rottenMovieToMovie(id string, movie rottentomatoes.Movie) Movie {
+	return Movie{
+		Id:               id,
+		Title:            movie.Title,
+		Ratings:          rottenRatingsToRatings(movie.Ratings),
+		Year:             movie.Year,
+		MpaaRating:       movie.MpaaRating,
+		CriticsConsensus: movie.CriticsConsensus,
+		Synopsis:         movie.Synopsis,
+		Cast:             rottenCastToCast(movie.AbridgedCast),
+		ImdbId:           movie.AlternateIds.Imdb,
+	}
+}
+
+// rottenMovie is the movie info response, its id is a number unlike the ids of the search results.
+type rottenMovie struct {
+	rottentomatoes.Movie
+	Id json.Number `json:"id"`
+}
+
+func (c *client) Movie(id string) (*Movie, error) {
+	return c.movieInfo(rottenTomatoesURL + "/movies/" + url.PathEscape(id) + ".json?apikey=" + url.QueryEscape(c.apiKey))
+}
+
+// MovieByImdb looks up the movie with the alias API, "tt" prefix of the id is optional.
+func (c *client) MovieByImdb(imdbId string) (*Movie, error) {
+	id := strings.TrimPrefix(strings.ToLower(imdbId), "tt")
+	return c.movieInfo(rottenTomatoesURL + "/movie_alias.json?type=imdb&id=" + url.QueryEscape(id) + "&apikey=" + url.QueryEscape(c.apiKey))
+}
+
+// movieInfo decodes the movie info response of the URL, ErrMovieNotFound when the movie is unknown.
+func (c *client) movieInfo(u string) (*Movie, error) {
+	resp, err := c.get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrMovieNotFound
+	default:
+		return nil, fmt.Errorf("api error, response code: %d", resp.StatusCode)
+	}
+
+	var found rottenMovie
+	if err := json.NewDecoder(resp.Body).Decode(&found); err != nil {
+		return nil, err
+	}
+	movie := rottenMovieToMovie(found.Id.String(), found.Movie)
+	return &movie, nil
+}
+
+// get requests the URL with the API key, the key is removed from the request error.
+func (c *client) get(u string) (*http.Response, error) {
+	resp, err := c.httpClient.Get(u)
+	return resp, redactURL(err)
+}
+
+// redactURL removes the query with the API key from the URL of the request error,
+// the error ends up in the logs, the audit, the spans and the published responses.
+func redactURL(err error) error {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return err
+	}
+	redacted := *urlErr
+	if i := strings.IndexByte(redacted.URL, '?'); i >= 0 {
+		redacted.URL = redacted.URL[:i]
+	}
+	return &redacted
+}
+
+func (c *client) Ping() error {
+	resp, err := c.get(rottenTomatoesPingURL + "?apikey=" + url.QueryEscape(c.apiKey))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("api error, response code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// switchableClient delegates to the client which can be replaced at runtime, e.g. after API key change.
+type switchableClient struct {
+	sync.RWMutex
+	client Client
+}
+
+func (self *switchableClient) current() Client {
+	self.RLock()
+	defer self.RUnlock()
+	return self.client
+}
+
+func (self *switchableClient) Switch(client Client) {
+	self.Lock()
+	defer self.Unlock()
+	self.client = client
+}
+
+func (self *switchableClient) Search(query string) ([]Movie, error) {
+	return self.current().Search(query)
+}
+
+func (self *switchableClient) Movie(id string) (*Movie, error) {
+	return getMovie(self.current(), id)
+}
+
+func (self *switchableClient) MovieByImdb(imdbId string) (*Movie, error) {
+	return getMovieByImdb(self.current(), imdbId)
+}
+
+func (self *switchableClient) Ping() error {
+	if pinger, ok := self.current().(Pinger); ok {
+		return pinger.Ping()
+	}
+	return nil
+}
+
+// baseURLTransport sends requests for the real API to another base URL, e.g. a fake-rt server.
+type baseURLTransport struct {
+	baseURL *url.URL
+	next    http.RoundTripper
+}
+
+func (self *baseURLTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != rottenTomatoesHost {
+		return self.next.RoundTrip(req)
+	}
+
+	// requests must not be modified by the transport
+	u := *req.URL
+	u.Scheme = self.baseURL.Scheme
+	u.Host = self.baseURL.Host
+	u.Path = strings.TrimSuffix(self.baseURL.Path, "/") + u.Path
+	rewritten := *req
+	rewritten.URL = &u
+	rewritten.Host = ""
+	return self.next.RoundTrip(&rewritten)
+}
+
+// parseBaseURL checks that base URL is an absolute http(s) URL.
+func parseBaseURL(baseURL string) (*url.URL, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return nil, fmt.Errorf("base URL must be http(s)://host[:port][/path], got '%s'", baseURL)
+	}
+	return u, nil
+}
+
+// NewClientWithBaseURL creates client of the API served on another base URL, empty baseURL - the real API.
+func NewClientWithBaseURL(httpClient *http.Client, apiKey, baseURL string) (Client, error) {
+	if len(baseURL) == 0 {
+		return NewClientWithHttp(httpClient, apiKey), nil
+	}
+
+	u, err := parseBaseURL(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	rewriting := &http.Client{Timeout: DefaultUpstreamTimeout}
+	if httpClient != nil {
+		*rewriting = *httpClient
+	}
+	next := rewriting.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	rewriting.Transport = &baseURLTransport{u, next}
+
+	return NewClientWithHttp(rewriting, apiKey), nil
+}
+
+// NewClientWithHttp creates client which sends requests with httpClient, nil - the client with DefaultUpstreamTimeout.
 func NewClientWithHttp(httpClient *http.Client, apiKey string) Client {
-	c := &client{rottentomatoes.NewClient(httpClient, apiKey)}
-	return c
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: DefaultUpstreamTimeout}
+	}
+	return &client{rottentomatoes.NewClient(httpClient, apiKey), httpClient, apiKey}
 }
 
 func NewClient(apiKey string) Client {
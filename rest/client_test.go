@@ -6,8 +6,11 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/plar/movie-service/fakert"
 )
 
 func httpTestClient(httpCode int, body []byte) (*httptest.Server, *http.Client) {
@@ -59,6 +62,13 @@ func TestClientSearchMartian(t *testing.T) {
 			AudienceScore:  92,
 		},
 	})
+
+	// enriched fields
+	assert.Equal(t, 2015, movies[0].Year)
+	assert.Equal(t, "PG-13", movies[0].MpaaRating)
+	assert.Contains(t, movies[0].Synopsis, "During a manned mission to Mars")
+	assert.Equal(t, 5, len(movies[0].Cast))
+	assert.Equal(t, CastMember{Name: "Matt Damon", Characters: []string{"Mark Watney"}}, movies[0].Cast[0])
 }
 
 func TestClientSearchEmpty(t *testing.T) {
@@ -90,3 +100,144 @@ func TestClientSearchError(t *testing.T) {
 	assert.Error(t, err)
 	assert.EqualError(t, err, "api error, response code: 404")
 }
+
+func TestClientPing(t *testing.T) {
+	server, httpClient := httpTestClient(http.StatusOK, []byte("{}"))
+	defer server.Close()
+
+	client := NewClientWithHttp(httpClient, "APIKEY")
+	pinger, ok := client.(Pinger)
+	assert.True(t, ok)
+	assert.NoError(t, pinger.Ping())
+}
+
+func TestClientPingError(t *testing.T) {
+	server, httpClient := httpTestClient(http.StatusForbidden, []byte("non-json-body"))
+	defer server.Close()
+
+	client := NewClientWithHttp(httpClient, "APIKEY")
+	assert.EqualError(t, client.(Pinger).Ping(), "api error, response code: 403")
+}
+
+func TestClientRedactsAPIKey(t *testing.T) {
+	server, httpClient := httpTestClient(http.StatusOK, []byte("{}"))
+	server.Close()
+
+	client := NewClientWithHttp(httpClient, "APIKEY")
+	_, err := client.(MovieGetter).Movie("771380589")
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "APIKEY")
+	assert.Contains(t, err.Error(), "/movies/771380589.json")
+
+	_, err = client.(ImdbGetter).MovieByImdb("tt3659388")
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "APIKEY")
+
+	err = client.(Pinger).Ping()
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "APIKEY")
+
+	_, err = client.Search("martian")
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "APIKEY")
+}
+
+func TestClientWithBaseURL(t *testing.T) {
+	fake, server := fakert.NewTestServer(fakert.Options{FixturesDir: "../fixtures", APIKey: "APIKEY"})
+	defer server.Close()
+
+	client, err := NewClientWithBaseURL(nil, "APIKEY", server.URL+"/")
+	assert.NoError(t, err)
+	assert.NoError(t, client.(Pinger).Ping())
+
+	movies, err := client.Search("Martian")
+	assert.NoError(t, err)
+	assert.Equal(t, 21, len(movies))
+	assert.Equal(t, "771380589", movies[0].Id)
+
+	fake.FailNext(http.StatusForbidden, 1)
+	_, err = client.Search("Martian")
+	assert.EqualError(t, err, "api error, response code: 403")
+
+	client, err = NewClientWithBaseURL(nil, "WRONGKEY", server.URL)
+	assert.NoError(t, err)
+	assert.EqualError(t, client.(Pinger).Ping(), "api error, response code: 403")
+	assert.Equal(t, 4, fake.Requests())
+}
+
+func TestUpstreamTimeoutOpensCircuit(t *testing.T) {
+	// the provider accepts the connection and never answers
+	hung := make(chan struct{})
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-hung:
+		case <-r.Context().Done():
+		}
+	}))
+	defer provider.Close()
+	defer close(hung)
+
+	ctx := NewTestMovieServerContext()
+	ctx.RottenTomatoesURL = provider.URL
+	ctx.UpstreamTimeout = 50 * time.Millisecond
+	ctx.BreakerOptions = &BreakerOptions{FailureThreshold: 1, OpenTimeout: time.Minute, SuccessThreshold: 1}
+	server, err := NewMovieServer(ctx)
+	assert.NoError(t, err)
+	impl := server.(*movieServer)
+	defer impl.pool.Stop()
+
+	started := time.Now()
+	_, err = impl.client.Search("martian")
+	assert.Error(t, err)
+	assert.Less(t, time.Since(started), time.Second)
+	assert.Equal(t, BreakerOpen, impl.breaker.State())
+}
+
+func TestClientWithInvalidBaseURL(t *testing.T) {
+	_, err := NewClientWithBaseURL(nil, "APIKEY", "127.0.0.1:8081")
+	assert.EqualError(t, err, "base URL must be http(s)://host[:port][/path], got '127.0.0.1:8081'")
+
+	client, err := NewClientWithBaseURL(nil, "APIKEY", "")
+	assert.NoError(t, err)
+	assert.NotNil(t, client)
+}
+
+func TestClientMovie(t *testing.T) {
+	fake, server := fakert.NewTestServer(fakert.Options{FixturesDir: "../fixtures", APIKey: "APIKEY"})
+	defer server.Close()
+
+	client, err := NewClientWithBaseURL(nil, "APIKEY", server.URL)
+	assert.NoError(t, err)
+	upstream := &switchableClient{client: client}
+
+	movie, err := upstream.Movie("771380589")
+	assert.NoError(t, err)
+	assert.Equal(t, "771380589", movie.Id)
+	assert.Equal(t, "The Martian", movie.Title)
+	assert.Equal(t, 2015, movie.Year)
+	assert.Equal(t, 92, movie.Ratings.CriticsScore)
+	assert.Equal(t, CastMember{Name: "Matt Damon", Characters: []string{"Mark Watney"}}, movie.Cast[0])
+
+	assert.Equal(t, "3659388", movie.ImdbId)
+
+	_, err = upstream.Movie("404")
+	assert.Equal(t, ErrMovieNotFound, err)
+
+	for _, imdbId := range []string{"tt3659388", "3659388"} {
+		movie, err = upstream.MovieByImdb(imdbId)
+		assert.NoError(t, err)
+		assert.Equal(t, "771380589", movie.Id)
+	}
+	_, err = upstream.MovieByImdb("tt0133093")
+	assert.Equal(t, ErrMovieNotFound, err)
+
+	fake.FailNext(http.StatusInternalServerError, 1)
+	_, err = upstream.Movie("771380589")
+	assert.EqualError(t, err, "api error, response code: 500")
+
+	// the clients without the lookup
+	_, err = getMovie(&queryClient{}, "771380589")
+	assert.EqualError(t, err, "Movie lookup is not supported by the provider")
+	_, err = getMovieByImdb(&queryClient{}, "tt3659388")
+	assert.EqualError(t, err, "IMDb lookup is not supported by the provider")
+}
@@ -0,0 +1,96 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const (
+	RequestIdHeader = "X-Request-Id"
+)
+
+// Stable machine-readable error codes of the HTTP API
+const (
+	CodeEmptyQuery           = "EMPTY_QUERY"
+	CodeBodyReadFailed       = "BODY_READ_FAILED"
+	CodeInvalidBody          = "INVALID_BODY"
+	CodeInvalidRequest       = "INVALID_REQUEST"
+	CodeInvalidPublish       = "INVALID_PUBLISH_OPTIONS"
+	CodeExchangeNotAllowed   = "EXCHANGE_NOT_ALLOWED"
+	CodeExchangeNotAvailable = "EXCHANGE_NOT_AVAILABLE"
+	CodeEncodeFailed         = "ENCODE_FAILED"
+	CodeNotImplemented       = "NOT_IMPLEMENTED"
+	CodeNotFound             = "NOT_FOUND"
+	CodeMethodNotAllowed     = "METHOD_NOT_ALLOWED"
+	CodeUnauthorized         = "UNAUTHORIZED"
+	CodeRateLimited          = "RATE_LIMITED"
+	CodeQuotaExceeded        = "QUOTA_EXCEEDED"
+	CodeRoutingKeyNotAllowed = "ROUTING_KEY_NOT_ALLOWED"
+	CodeShuttingDown         = "SHUTTING_DOWN"
+	CodeJournalFailed        = "JOURNAL_FAILED"
+	CodeUpstreamUnavailable  = "UPSTREAM_UNAVAILABLE"
+	CodeInvalidSource        = "INVALID_SOURCE"
+	CodeCatalogDisabled      = "CATALOG_DISABLED"
+	CodeInvalidFilter        = "INVALID_FILTER"
+	CodeInvalidBatch         = "INVALID_BATCH"
+	CodeMovieNotFound        = "MOVIE_NOT_FOUND"
+	CodeInvalidLookup        = "INVALID_LOOKUP"
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// APIError is an error reported to the HTTP API client.
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+	Fields  []FieldError
+}
+
+func (self *APIError) Error() string {
+	return self.Message
+}
+
+func NewAPIError(status int, code, message string, fields ...FieldError) *APIError {
+	return &APIError{Status: status, Code: code, Message: message, Fields: fields}
+}
+
+func NewErrorResponse(requestId string, err *APIError) *ErrorResponse {
+	return &ErrorResponse{Meta: Meta{
+		RequestId: requestId,
+		Status:    ERROR,
+		Code:      err.Code,
+		Error:     err.Message,
+		Errors:    err.Fields,
+	}}
+}
+
+// requestId returns request id from the request header, used when the request body is not decoded yet.
+func requestId(r *http.Request) string {
+	return r.Header.Get(RequestIdHeader)
+}
+
+func writeError(w http.ResponseWriter, requestId string, apiErr *APIError) {
+	w.Header().Set("Content-Type", "application/json")
+
+	body, err := json.Marshal(NewErrorResponse(requestId, apiErr))
+	if err != nil {
+		// should never happen, the error response contains strings only
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"meta":{"status":"error","code":"` + CodeEncodeFailed + `"}}`))
+		return
+	}
+	w.WriteHeader(apiErr.Status)
+	w.Write(body)
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, requestId(r), NewAPIError(http.StatusNotFound, CodeNotFound, "Not found"))
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, requestId(r), NewAPIError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed"))
+}
@@ -0,0 +1,32 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIError(t *testing.T) {
+	err := NewAPIError(http.StatusUnprocessableEntity, CodeInvalidRequest, "Invalid request", FieldError{"routing_key", "cannot be empty"})
+	assert.EqualError(t, err, "Invalid request")
+	assert.Equal(t, http.StatusUnprocessableEntity, err.Status)
+	assert.Equal(t, []FieldError{{"routing_key", "cannot be empty"}}, err.Fields)
+}
+
+func TestWriteError(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	writeError(recorder, "unique-request-id", NewAPIError(http.StatusUnprocessableEntity, CodeInvalidRequest, "Invalid request",
+		FieldError{"routing_key", "cannot be empty"}))
+
+	assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"meta": {
+		"request_id": "unique-request-id",
+		"status": "error",
+		"code": "INVALID_REQUEST",
+		"error": "Invalid request",
+		"errors": [{"field": "routing_key", "message": "cannot be empty"}]
+	}}`, recorder.Body.String())
+}
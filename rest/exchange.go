@@ -0,0 +1,183 @@
+package rest
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	ExchangeDeclareActive  = "active"  // declare exchange, create it if it does not exist
+	ExchangeDeclarePassive = "passive" // check that the pre-provisioned exchange exists
+	ExchangeDeclareNone    = "none"    // do not touch the exchange at all
+
+	DeliveryModeTransient  = "transient"
+	DeliveryModePersistent = "persistent"
+
+	MaxPriority = 9 // highest AMQP message priority
+)
+
+var exchangeTypes = map[string]bool{
+	amqp.ExchangeDirect:  true,
+	amqp.ExchangeFanout:  true,
+	amqp.ExchangeTopic:   true,
+	amqp.ExchangeHeaders: true,
+}
+
+// PublishOptions describes how the response exchange is declared and
+// which properties are attached to the published message.
+type PublishOptions struct {
+	ExchangeType       string
+	ExchangeDeclare    string
+	ExchangeDurable    bool
+	ExchangeAutoDelete bool
+
+	DeliveryMode string
+	Expiration   string
+	Priority     uint8
+
+	CorrelationId string
+	Headers       map[string]string
+}
+
+// DefaultPublishOptions returns durable topic exchange and transient messages.
+func DefaultPublishOptions() PublishOptions {
+	return PublishOptions{
+		ExchangeType:    amqp.ExchangeTopic,
+		ExchangeDeclare: ExchangeDeclareActive,
+		ExchangeDurable: true,
+		DeliveryMode:    DeliveryModeTransient,
+	}
+}
+
+// Validate checks that the options can be used to declare exchange and publish message.
+func (self PublishOptions) Validate() error {
+	if !exchangeTypes[self.ExchangeType] {
+		return fmt.Errorf("Unknown exchange type '%s'", self.ExchangeType)
+	}
+
+	switch self.ExchangeDeclare {
+	case ExchangeDeclareActive, ExchangeDeclarePassive, ExchangeDeclareNone:
+	default:
+		return fmt.Errorf("Unknown exchange declare mode '%s'", self.ExchangeDeclare)
+	}
+
+	switch self.DeliveryMode {
+	case DeliveryModeTransient, DeliveryModePersistent:
+	default:
+		return fmt.Errorf("Unknown delivery mode '%s'", self.DeliveryMode)
+	}
+
+	if len(self.Expiration) > 0 {
+		if ttl, err := strconv.ParseUint(self.Expiration, 10, 32); err != nil || ttl == 0 {
+			return fmt.Errorf("Expiration must be a positive number of milliseconds, got '%s'", self.Expiration)
+		}
+	}
+
+	if self.Priority > MaxPriority {
+		return fmt.Errorf("Priority must be between 0 and %d, got %d", MaxPriority, self.Priority)
+	}
+
+	return nil
+}
+
+// declareStrictness orders the declare modes, the request can only make the configured mode stricter,
+// so clients cannot create exchanges when the operator pre-provisions them.
+var declareStrictness = map[string]int{
+	ExchangeDeclareActive:  0,
+	ExchangeDeclarePassive: 1,
+	ExchangeDeclareNone:    2,
+}
+
+// Resolve applies request overrides on top of the configured options.
+func (self PublishOptions) Resolve(req *Request) (PublishOptions, error) {
+	opts := self
+	if len(req.ExchangeType) > 0 {
+		opts.ExchangeType = req.ExchangeType
+	}
+	if len(req.ExchangeDeclare) > 0 {
+		strictness, known := declareStrictness[req.ExchangeDeclare]
+		if known && strictness < declareStrictness[self.ExchangeDeclare] {
+			return opts, fmt.Errorf("Exchange declare mode '%s' is less strict than the configured '%s'", req.ExchangeDeclare, self.ExchangeDeclare)
+		}
+		opts.ExchangeDeclare = req.ExchangeDeclare
+	}
+	if len(req.DeliveryMode) > 0 {
+		opts.DeliveryMode = req.DeliveryMode
+	}
+	if len(req.Expiration) > 0 {
+		opts.Expiration = req.Expiration
+	}
+	if req.Priority != nil {
+		opts.Priority = *req.Priority
+	}
+	if len(req.CorrelationId) > 0 {
+		opts.CorrelationId = req.CorrelationId
+	}
+
+	opts.Headers = make(map[string]string, len(self.Headers)+len(req.Headers))
+	for k, v := range self.Headers {
+		opts.Headers[k] = v
+	}
+	for k, v := range req.Headers {
+		opts.Headers[k] = v
+	}
+
+	return opts, opts.Validate()
+}
+
+type exchangeDeclarer interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	ExchangeDeclarePassive(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+}
+
+// DeclareExchange declares the exchange according to the options.
+// The default exchange ("") always exists and cannot be declared.
+func (self PublishOptions) DeclareExchange(ch exchangeDeclarer, name string) error {
+	if len(name) == 0 {
+		return nil
+	}
+
+	switch self.ExchangeDeclare {
+	case ExchangeDeclareActive:
+		return ch.ExchangeDeclare(name, self.ExchangeType, self.ExchangeDurable, self.ExchangeAutoDelete, false, false, nil)
+	case ExchangeDeclarePassive:
+		return ch.ExchangeDeclarePassive(name, self.ExchangeType, self.ExchangeDurable, self.ExchangeAutoDelete, false, false, nil)
+	}
+	return nil
+}
+
+// Publishing creates AMQP message for the request with the given body.
+func (self PublishOptions) Publishing(req *Request, body []byte, now time.Time) amqp.Publishing {
+	correlationId := self.CorrelationId
+	if len(correlationId) == 0 {
+		correlationId = req.RequestId
+	}
+
+	headers := amqp.Table{}
+	for k, v := range self.Headers {
+		headers[k] = v
+	}
+	headers["request_id"] = req.RequestId
+	headers["correlation_id"] = correlationId
+	headers["timestamp"] = now.Unix()
+
+	deliveryMode := amqp.Transient
+	if self.DeliveryMode == DeliveryModePersistent {
+		deliveryMode = amqp.Persistent
+	}
+
+	return amqp.Publishing{
+		Headers:       headers,
+		ContentType:   "application/json",
+		DeliveryMode:  deliveryMode,
+		Priority:      self.Priority,
+		CorrelationId: correlationId,
+		Expiration:    self.Expiration,
+		MessageId:     req.RequestId,
+		Timestamp:     now,
+		Body:          body,
+	}
+}
@@ -0,0 +1,176 @@
+package rest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+type testExchangeDeclarer struct {
+	simulateError error
+
+	method  string
+	name    string
+	kind    string
+	durable bool
+}
+
+func (self *testExchangeDeclarer) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	self.method, self.name, self.kind, self.durable = "declare", name, kind, durable
+	return self.simulateError
+}
+
+func (self *testExchangeDeclarer) ExchangeDeclarePassive(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	self.method, self.name, self.kind, self.durable = "passive", name, kind, durable
+	return self.simulateError
+}
+
+func TestDefaultPublishOptions(t *testing.T) {
+	opts := DefaultPublishOptions()
+	assert.NoError(t, opts.Validate())
+	assert.Equal(t, "topic", opts.ExchangeType)
+	assert.Equal(t, ExchangeDeclareActive, opts.ExchangeDeclare)
+	assert.True(t, opts.ExchangeDurable)
+	assert.Equal(t, DeliveryModeTransient, opts.DeliveryMode)
+}
+
+func TestPublishOptionsValidate(t *testing.T) {
+	opts := DefaultPublishOptions()
+	opts.ExchangeType = "x-custom"
+	assert.EqualError(t, opts.Validate(), "Unknown exchange type 'x-custom'")
+
+	opts = DefaultPublishOptions()
+	opts.ExchangeDeclare = "maybe"
+	assert.EqualError(t, opts.Validate(), "Unknown exchange declare mode 'maybe'")
+
+	opts = DefaultPublishOptions()
+	opts.DeliveryMode = "durable"
+	assert.EqualError(t, opts.Validate(), "Unknown delivery mode 'durable'")
+
+	opts = DefaultPublishOptions()
+	opts.Expiration = "1m"
+	assert.EqualError(t, opts.Validate(), "Expiration must be a positive number of milliseconds, got '1m'")
+
+	opts = DefaultPublishOptions()
+	opts.Priority = 10
+	assert.EqualError(t, opts.Validate(), "Priority must be between 0 and 9, got 10")
+}
+
+func TestPublishOptionsResolve(t *testing.T) {
+	defaults := DefaultPublishOptions()
+	defaults.Headers = map[string]string{"service": "movie-service", "env": "devel"}
+
+	priority := uint8(5)
+	req := Request{
+		RequestId:       "RequestId",
+		ExchangeType:    "headers",
+		ExchangeDeclare: ExchangeDeclarePassive,
+		DeliveryMode:    DeliveryModePersistent,
+		Expiration:      "60000",
+		Priority:        &priority,
+		CorrelationId:   "CorrelationId",
+		Headers:         map[string]string{"env": "staging"},
+	}
+
+	opts, err := defaults.Resolve(&req)
+	assert.NoError(t, err)
+	assert.Equal(t, "headers", opts.ExchangeType)
+	assert.Equal(t, ExchangeDeclarePassive, opts.ExchangeDeclare)
+	assert.True(t, opts.ExchangeDurable)
+	assert.Equal(t, DeliveryModePersistent, opts.DeliveryMode)
+	assert.Equal(t, "60000", opts.Expiration)
+	assert.Equal(t, uint8(5), opts.Priority)
+	assert.Equal(t, "CorrelationId", opts.CorrelationId)
+	assert.Equal(t, map[string]string{"service": "movie-service", "env": "staging"}, opts.Headers)
+
+	// configured options are not changed
+	assert.Equal(t, "devel", defaults.Headers["env"])
+
+	_, err = defaults.Resolve(&Request{ExchangeType: "unknown"})
+	assert.EqualError(t, err, "Unknown exchange type 'unknown'")
+}
+
+func TestPublishOptionsResolveDeclareMode(t *testing.T) {
+	configured := DefaultPublishOptions()
+	configured.ExchangeDeclare = ExchangeDeclarePassive
+
+	// the request can make the mode stricter only
+	opts, err := configured.Resolve(&Request{ExchangeDeclare: ExchangeDeclareNone})
+	assert.NoError(t, err)
+	assert.Equal(t, ExchangeDeclareNone, opts.ExchangeDeclare)
+	opts, err = configured.Resolve(&Request{ExchangeDeclare: ExchangeDeclarePassive})
+	assert.NoError(t, err)
+	assert.Equal(t, ExchangeDeclarePassive, opts.ExchangeDeclare)
+
+	_, err = configured.Resolve(&Request{ExchangeDeclare: ExchangeDeclareActive})
+	assert.EqualError(t, err, "Exchange declare mode 'active' is less strict than the configured 'passive'")
+
+	configured.ExchangeDeclare = ExchangeDeclareNone
+	_, err = configured.Resolve(&Request{ExchangeDeclare: ExchangeDeclarePassive})
+	assert.EqualError(t, err, "Exchange declare mode 'passive' is less strict than the configured 'none'")
+}
+
+func TestPublishOptionsDeclareExchange(t *testing.T) {
+	opts := DefaultPublishOptions()
+
+	ch := &testExchangeDeclarer{}
+	assert.NoError(t, opts.DeclareExchange(ch, "ExchangeName"))
+	assert.Equal(t, "declare", ch.method)
+	assert.Equal(t, "ExchangeName", ch.name)
+	assert.Equal(t, "topic", ch.kind)
+	assert.True(t, ch.durable)
+
+	ch = &testExchangeDeclarer{}
+	opts.ExchangeDeclare = ExchangeDeclarePassive
+	assert.NoError(t, opts.DeclareExchange(ch, "ExchangeName"))
+	assert.Equal(t, "passive", ch.method)
+
+	ch = &testExchangeDeclarer{}
+	opts.ExchangeDeclare = ExchangeDeclareNone
+	assert.NoError(t, opts.DeclareExchange(ch, "ExchangeName"))
+	assert.Equal(t, "", ch.method)
+
+	// default exchange cannot be declared
+	ch = &testExchangeDeclarer{}
+	opts.ExchangeDeclare = ExchangeDeclareActive
+	assert.NoError(t, opts.DeclareExchange(ch, ""))
+	assert.Equal(t, "", ch.method)
+
+	ch = &testExchangeDeclarer{simulateError: errors.New("PRECONDITION_FAILED")}
+	assert.EqualError(t, opts.DeclareExchange(ch, "ExchangeName"), "PRECONDITION_FAILED")
+}
+
+func TestPublishOptionsPublishing(t *testing.T) {
+	opts := DefaultPublishOptions()
+	opts.Headers = map[string]string{"service": "movie-service"}
+	now := time.Unix(1445000000, 0)
+	req := Request{RequestId: "RequestId"}
+
+	msg := opts.Publishing(&req, []byte("{}"), now)
+	assert.Equal(t, "application/json", msg.ContentType)
+	assert.Equal(t, amqp.Transient, msg.DeliveryMode)
+	assert.Equal(t, "RequestId", msg.CorrelationId)
+	assert.Equal(t, "RequestId", msg.MessageId)
+	assert.Equal(t, now, msg.Timestamp)
+	assert.Equal(t, []byte("{}"), msg.Body)
+	assert.Equal(t, amqp.Table{
+		"service":        "movie-service",
+		"request_id":     "RequestId",
+		"correlation_id": "RequestId",
+		"timestamp":      int64(1445000000),
+	}, msg.Headers)
+
+	opts.DeliveryMode = DeliveryModePersistent
+	opts.CorrelationId = "CorrelationId"
+	opts.Expiration = "1000"
+	opts.Priority = 3
+	msg = opts.Publishing(&req, nil, now)
+	assert.Equal(t, amqp.Persistent, msg.DeliveryMode)
+	assert.Equal(t, "CorrelationId", msg.CorrelationId)
+	assert.Equal(t, "CorrelationId", msg.Headers["correlation_id"])
+	assert.Equal(t, "1000", msg.Expiration)
+	assert.Equal(t, uint8(3), msg.Priority)
+}
@@ -1,39 +1,241 @@
 package rest
 
-import wq "github.com/plar/movie-service/workerqueue"
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/plar/movie-service/audit"
+	log "github.com/plar/movie-service/logging"
+	"github.com/plar/movie-service/tracing"
+	wq "github.com/plar/movie-service/workerqueue"
+)
 
 type JobFactory interface {
-	NewSearch(req Request, query string)
+	// NewSearch runs the search on a free worker, done (optional) gets the publish result.
+	// Spans of the job are children of the span of ctx.
+	NewSearch(ctx context.Context, req Request, query SearchQuery, done func(error))
+
+	// NewBatch runs the searches of the batch on at most batch.Concurrency workers at once,
+	// it returns when all of them are completed, dispatched (optional) is called for every item
+	// handed to a worker, done (optional) gets the first publish error.
+	NewBatch(ctx context.Context, req Request, batch BatchQuery, dispatched func(), done func(error))
+
+	// Close drops jobs waiting for a free worker and all new jobs.
+	Close()
 }
 
 type jobFactory struct {
 	messageQueue MessageQueue
 	client       Client
+	local        Client // nil - no local catalog
 	workerQueue  wq.WorkerQueue
+	audit        audit.Logger
+	closed       chan struct{}
+	closeOnce    sync.Once
 }
 
 type testJobFactory struct {
 }
 
-func (self *jobFactory) NewSearch(req Request, query string) {
-	worker := <-self.workerQueue
-	worker <- func(id int) {
-		var resp *SearchResponse
-		movies, err := self.client.Search(query)
-		if err == nil {
-			resp = NewSearchResponseSuccess(req.RequestId, movies)
-		} else {
-			resp = NewSearchResponseError(req.RequestId, err)
+func (self *jobFactory) NewSearch(ctx context.Context, req Request, query SearchQuery, done func(error)) {
+	logger := log.With(log.Fields{
+		log.FieldRequestId:  req.RequestId,
+		log.FieldExchange:   req.ExchangeName,
+		log.FieldRoutingKey: req.RoutingKey,
+	}).With(queryFields(query))
+
+	_, wait := tracing.Tracer().Start(ctx, spanWorkerQueue, trace.WithAttributes(attrRequestId.String(req.RequestId)))
+
+	job := func(id int) {
+		logger := logger.With(log.Fields{log.FieldWorkerId: id})
+		wait.SetAttributes(attrWorkerId.Int(id))
+		wait.End()
+
+		resp := self.run(ctx, req, query, logger)
+		err := self.publish(ctx, req, resp, logger)
+		if done != nil {
+			done(err)
+		}
+	}
+
+	if worker := self.acquire(nil); worker == nil || !self.send(worker, job) {
+		logger.Warnf("Job dropped, service is shutting down")
+		tracing.End(wait, errors.New("Job dropped, service is shutting down"))
+	}
+}
+
+// acquire waits for a free slot of the batch, nil - no slots, and then for a free worker,
+// nil - the factory is closed. The closed factory never hands out a worker, even a free one.
+func (self *jobFactory) acquire(slots chan struct{}) wq.Inbound {
+	select {
+	case <-self.closed:
+		return nil
+	default:
+	}
+
+	if slots != nil {
+		select {
+		case <-self.closed:
+			return nil
+		case slots <- struct{}{}:
 		}
-		self.messageQueue.PublishSearchResponse(&req, resp)
 	}
+
+	select {
+	case <-self.closed:
+		if slots != nil {
+			<-slots
+		}
+		return nil
+	case worker := <-self.workerQueue:
+		return worker
+	}
+}
+
+// send hands the job to the acquired worker, false - the factory is closed and the worker may be stopped.
+func (self *jobFactory) send(worker wq.Inbound, job wq.Work) bool {
+	select {
+	case worker <- job:
+		return true
+	case <-self.closed:
+		return false
+	}
+}
+
+// queryFields returns the log fields of the query, the id of the looked up movie or the searched text.
+func queryFields(query SearchQuery) log.Fields {
+	switch {
+	case len(query.Id) > 0:
+		return log.Fields{fieldMovieId: query.Id}
+	case query.Lookup != nil && len(query.Lookup.ImdbId) > 0:
+		return log.Fields{fieldImdbId: query.Lookup.ImdbId}
+	case query.Lookup != nil:
+		return log.Fields{log.FieldQuery: query.Lookup.Title}
+	}
+	return log.Fields{log.FieldQuery: query.Text}
+}
+
+// run searches the query and returns the response to publish, it is called by the worker.
+func (self *jobFactory) run(ctx context.Context, req Request, query SearchQuery, logger log.Logger) *SearchResponse {
+	var resp *SearchResponse
+	started := time.Now()
+	provider := rottenTomatoesProvider
+	if query.Source == SourceLocal {
+		provider = localProvider
+	}
+	attrs := []attribute.KeyValue{attrProvider.String(provider), attrQuery.String(query.Text)}
+	switch {
+	case len(query.Id) > 0:
+		attrs[1] = attrMovieId.String(query.Id)
+	case query.Lookup != nil && len(query.Lookup.ImdbId) > 0:
+		attrs[1] = attrImdbId.String(query.Lookup.ImdbId)
+	case query.Lookup != nil:
+		attrs[1] = attrQuery.String(query.Lookup.Title)
+	}
+	_, upstream := tracing.Tracer().Start(ctx, spanUpstream, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	result, err := self.search(query)
+	movies := result.movies
+	upstream.SetAttributes(attrResults.Int(len(movies)), attrStale.Bool(result.stale))
+	if result.rewrite != nil {
+		upstream.SetAttributes(attrRewritten.String(result.rewrite.Query))
+		logger = logger.With(log.Fields{fieldRewrittenQuery: result.rewrite.Query})
+	}
+	tracing.End(upstream, err)
+	self.auditUpstream(req, provider, started, len(movies), err)
+	switch {
+	case query.Source == SourceLocal && err == nil:
+		logger.With(latency(started)).Debugf("Local search completed, results=%d", len(movies))
+		resp = NewSearchResponseSuccess(req.RequestId, movies)
+	case query.Source == SourceLocal:
+		logger.With(latency(started)).Warnf("Local search failed, error=%s", err)
+		resp = NewSearchResponseError(req.RequestId, err)
+	case err == nil && result.stale:
+		logger.With(latency(started)).Warnf("Upstream search failed, cached results are served, results=%d, age=%s", len(movies), result.age)
+		resp = NewSearchResponseStale(req.RequestId, movies, result.age)
+	case err == nil:
+		logger.With(latency(started)).Debugf("Upstream search completed, results=%d", len(movies))
+		resp = NewSearchResponseSuccess(req.RequestId, movies)
+	default:
+		logger.With(latency(started)).Warnf("Upstream search failed, error=%s", err)
+		resp = NewSearchResponseError(req.RequestId, err)
+	}
+	if err == nil {
+		resp.Meta.Rewrite = result.rewrite
+		resp.Meta.Filter, resp.Meta.Excluded = query.Filter, result.excluded
+		resp.Meta.Match = result.match
+	}
+	return resp
+}
+
+// publish sends the response to the exchange of the request.
+func (self *jobFactory) publish(ctx context.Context, req Request, resp *SearchResponse, logger log.Logger) error {
+	started := time.Now()
+	err := self.messageQueue.PublishSearchResponse(ctx, &req, resp)
+	if err != nil {
+		logger.With(latency(started)).Errorf("Cannot publish message, error=%s", err)
+	} else {
+		logger.With(latency(started)).Debugf("Search response published")
+	}
+	self.auditPublished(req, err)
+	return err
+}
+
+// latency returns the latency field in milliseconds since started.
+func latency(started time.Time) log.Fields {
+	return log.Fields{log.FieldLatency: time.Since(started).Milliseconds()}
+}
+
+func (self *jobFactory) auditUpstream(req Request, provider string, started time.Time, results int, err error) {
+	event := audit.Event{Event: audit.EventUpstream, RequestId: req.RequestId, Provider: provider, Outcome: audit.OutcomeSuccess}
+	event.Latency(time.Since(started))
+	if err != nil {
+		event.Outcome, event.Error = audit.OutcomeError, err.Error()
+	} else {
+		event.Results = &results
+	}
+	self.audit.Log(event)
 }
 
-func (self *testJobFactory) NewSearch(req Request, query string) {
+func (self *jobFactory) auditPublished(req Request, err error) {
+	event := audit.Event{Event: audit.EventPublished, RequestId: req.RequestId, Exchange: req.ExchangeName, RoutingKey: req.RoutingKey, Outcome: audit.OutcomeSuccess}
+	if err != nil {
+		event.Outcome, event.Error = audit.OutcomeError, err.Error()
+	}
+	self.audit.Log(event)
+}
+
+func (self *jobFactory) Close() {
+	self.closeOnce.Do(func() {
+		close(self.closed)
+	})
+}
+
+func (self *testJobFactory) NewSearch(ctx context.Context, req Request, query SearchQuery, done func(error)) {
+}
+
+func (self *testJobFactory) NewBatch(ctx context.Context, req Request, batch BatchQuery, dispatched func(), done func(error)) {
+}
+
+func (self *testJobFactory) Close() {
 }
 
 func NewJobFactory(mq MessageQueue, client Client, workerQueue wq.WorkerQueue) JobFactory {
-	return &jobFactory{mq, client, workerQueue}
+	return NewJobFactoryWithAudit(mq, client, workerQueue, audit.NewNopLogger())
+}
+
+// NewJobFactoryWithAudit creates job factory which writes upstream and publish events to the audit log.
+func NewJobFactoryWithAudit(mq MessageQueue, client Client, workerQueue wq.WorkerQueue, auditLog audit.Logger) JobFactory {
+	return NewJobFactoryWithCatalog(mq, client, nil, workerQueue, auditLog)
+}
+
+// NewJobFactoryWithCatalog creates job factory which answers the SourceLocal searches with the local client, nil - no catalog.
+func NewJobFactoryWithCatalog(mq MessageQueue, client, local Client, workerQueue wq.WorkerQueue, auditLog audit.Logger) JobFactory {
+	return &jobFactory{messageQueue: mq, client: client, local: local, workerQueue: workerQueue, audit: auditLog, closed: make(chan struct{})}
 }
 
 func NewTestJobFactory() JobFactory {
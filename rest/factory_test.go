@@ -1,17 +1,49 @@
 package rest
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/plar/movie-service/audit"
+	log "github.com/plar/movie-service/logging"
 	wq "github.com/plar/movie-service/workerqueue"
 )
 
+// recordingAuditLogger keeps audit events in memory.
+type recordingAuditLogger struct {
+	sync.Mutex
+	events []audit.Event
+}
+
+func (self *recordingAuditLogger) Log(event audit.Event) {
+	self.Lock()
+	defer self.Unlock()
+	self.events = append(self.events, event)
+}
+
+func (self *recordingAuditLogger) Close() error {
+	return nil
+}
+
+func (self *recordingAuditLogger) Events() []audit.Event {
+	self.Lock()
+	defer self.Unlock()
+	return append([]audit.Event(nil), self.events...)
+}
+
 type testmqAndClientImpl struct {
-	simulateSearchError error
+	simulateSearchError  error
+	simulatePublishError error
 
 	query string
 	req   *Request
@@ -35,10 +67,10 @@ func (self *testmqAndClientImpl) Search(query string) ([]Movie, error) {
 	}
 }
 
-func (self *testmqAndClientImpl) PublishSearchResponse(req *Request, resp *SearchResponse) error {
+func (self *testmqAndClientImpl) PublishSearchResponse(ctx context.Context, req *Request, resp *SearchResponse) error {
 	self.req = req
 	self.resp = resp
-	return nil
+	return self.simulatePublishError
 }
 
 func TestNewJobFactory(t *testing.T) {
@@ -65,7 +97,11 @@ func TestNewSearch(t *testing.T) {
 	factory := NewJobFactory(mqAndClient, mqAndClient, workerQueue)
 
 	req := Request{RequestId: "RequestId", ExchangeName: "ExchangeName", RoutingKey: "RoutingKey"}
-	factory.NewSearch(req, "test-query")
+	published := make(chan error, 1)
+	factory.NewSearch(context.Background(), req, SearchQuery{Text: "test-query"}, func(err error) {
+		published <- err
+	})
+	assert.NoError(t, <-published)
 
 	// wait for finish
 FINISH:
@@ -81,7 +117,8 @@ FINISH:
 		}
 	}
 
-	assert.Equal(t, mqAndClient.query, "test-query")
+	// the punctuation is stripped from the searched query
+	assert.Equal(t, mqAndClient.query, "test query")
 	assert.Equal(t, 1, len(mqAndClient.resp.Data.Movies))
 	assert.Equal(t, req, *mqAndClient.req)
 	assert.Equal(t, mqAndClient.resp.Meta.RequestId, req.RequestId)
@@ -105,7 +142,7 @@ func TestNewSearchWithError(t *testing.T) {
 	factory := NewJobFactory(mqAndClient, mqAndClient, workerQueue)
 
 	req := Request{RequestId: "RequestId", ExchangeName: "ExchangeName", RoutingKey: "RoutingKey"}
-	factory.NewSearch(req, "test-query")
+	factory.NewSearch(context.Background(), req, SearchQuery{Text: "test-query"}, nil)
 
 	// wait for finish
 FINISH:
@@ -121,7 +158,7 @@ FINISH:
 		}
 	}
 
-	assert.Equal(t, mqAndClient.query, "test-query")
+	assert.Equal(t, mqAndClient.query, "test query")
 	assert.Equal(t, 0, len(mqAndClient.resp.Data.Movies))
 	assert.Equal(t, req, *mqAndClient.req)
 	assert.Equal(t, mqAndClient.resp.Meta.RequestId, req.RequestId)
@@ -129,3 +166,76 @@ FINISH:
 	assert.Equal(t, mqAndClient.resp.Meta.Status, ERROR)
 
 }
+
+func TestNewSearchAudit(t *testing.T) {
+	auditLog := &recordingAuditLogger{}
+	workerQueue := make(wq.WorkerQueue, 1)
+	worker, _ := wq.NewWorker(1, workerQueue)
+	worker.Start()
+	defer worker.Stop()
+
+	mqAndClient := &testmqAndClientImpl{}
+	factory := NewJobFactoryWithAudit(mqAndClient, mqAndClient, workerQueue, auditLog)
+
+	req := Request{RequestId: "RequestId", ExchangeName: "ExchangeName", RoutingKey: "RoutingKey"}
+	published := make(chan error, 1)
+	factory.NewSearch(context.Background(), req, SearchQuery{Text: "test-query"}, func(err error) { published <- err })
+	<-published
+
+	mqAndClient.simulateSearchError = errors.New("API is not available")
+	factory.NewSearch(context.Background(), req, SearchQuery{Text: "test-query"}, func(err error) { published <- err })
+	<-published
+
+	events := auditLog.Events()
+	assert.Equal(t, 4, len(events))
+
+	assert.Equal(t, audit.EventUpstream, events[0].Event)
+	assert.Equal(t, "RequestId", events[0].RequestId)
+	assert.Equal(t, rottenTomatoesProvider, events[0].Provider)
+	assert.Equal(t, 1, *events[0].Results)
+	assert.Equal(t, audit.OutcomeSuccess, events[0].Outcome)
+
+	assert.Equal(t, audit.Event{Event: audit.EventPublished, RequestId: "RequestId", Exchange: "ExchangeName", RoutingKey: "RoutingKey", Outcome: audit.OutcomeSuccess}, events[1])
+
+	assert.Equal(t, audit.OutcomeError, events[2].Outcome)
+	assert.Equal(t, "API is not available", events[2].Error)
+	assert.Nil(t, events[2].Results)
+	assert.Equal(t, audit.EventPublished, events[3].Event)
+}
+
+func TestNewSearchLogFields(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "movie-service-log")
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "log.jsonl")
+	assert.NoError(t, log.Configure(log.Options{Format: log.FormatJSON, Level: "debug", File: fileName}))
+	defer log.Configure(log.Options{Format: log.FormatSeelog})
+
+	workerQueue := make(wq.WorkerQueue, 1)
+	worker, _ := wq.NewWorker(7, workerQueue)
+	worker.Start()
+	defer worker.Stop()
+
+	mqAndClient := &testmqAndClientImpl{simulatePublishError: errors.New("channel/connection is not open")}
+	factory := NewJobFactory(mqAndClient, mqAndClient, workerQueue)
+
+	req := Request{RequestId: "RequestId", ExchangeName: "ExchangeName", RoutingKey: "RoutingKey"}
+	published := make(chan error, 1)
+	factory.NewSearch(context.Background(), req, SearchQuery{Text: "test-query"}, func(err error) { published <- err })
+	assert.Error(t, <-published)
+
+	data, err := ioutil.ReadFile(fileName)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Equal(t, 2, len(lines))
+
+	var line map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &line))
+	assert.Equal(t, "error", line["level"])
+	assert.Equal(t, "Cannot publish message, error=channel/connection is not open", line["msg"])
+	assert.Equal(t, "RequestId", line[log.FieldRequestId])
+	assert.Equal(t, "test-query", line[log.FieldQuery])
+	assert.Equal(t, 7.0, line[log.FieldWorkerId])
+	assert.Equal(t, "ExchangeName", line[log.FieldExchange])
+	assert.Equal(t, "RoutingKey", line[log.FieldRoutingKey])
+	assert.Contains(t, line, log.FieldLatency)
+}
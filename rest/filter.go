@@ -0,0 +1,186 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Sort keys of the search results
+const (
+	SortCriticsScore  = "critics_score"
+	SortAudienceScore = "audience_score"
+	SortYear          = "year"
+	SortTitle         = "title"
+
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+
+	certifiedFresh = "Certified Fresh"
+)
+
+// MPAA ratings of the provider
+var mpaaRatings = []string{"G", "PG", "PG-13", "R", "NC-17", "Unrated"}
+
+// SearchFilter narrows and orders the search results before they are published.
+type SearchFilter struct {
+	MinCriticsScore  int      `json:"min_critics_score,omitempty"`
+	MinAudienceScore int      `json:"min_audience_score,omitempty"`
+	YearFrom         int      `json:"year_from,omitempty"`
+	YearTo           int      `json:"year_to,omitempty"`
+	Mpaa             []string `json:"mpaa,omitempty"` // any of the ratings
+	CertifiedFresh   bool     `json:"certified_fresh,omitempty"`
+	Sort             string   `json:"sort,omitempty"`  // empty - the order of the provider
+	Order            string   `json:"order,omitempty"` // asc or desc, empty - desc for scores and year, asc for title
+}
+
+// Apply returns the movies which pass the filter in the requested order, the movies are not modified.
+func (self *SearchFilter) Apply(movies []Movie) []Movie {
+	var result []Movie
+	for _, movie := range movies {
+		if self.accepts(movie) {
+			result = append(result, movie)
+		}
+	}
+
+	if less := self.less(); less != nil {
+		sort.SliceStable(result, func(i, j int) bool { return less(result[i], result[j]) })
+	}
+	return result
+}
+
+func (self *SearchFilter) accepts(movie Movie) bool {
+	switch {
+	case self.MinCriticsScore > 0 && movie.Ratings.CriticsScore < self.MinCriticsScore:
+		return false
+	case self.MinAudienceScore > 0 && movie.Ratings.AudienceScore < self.MinAudienceScore:
+		return false
+	case self.YearFrom > 0 && movie.Year < self.YearFrom:
+		return false
+	case self.YearTo > 0 && (movie.Year == 0 || movie.Year > self.YearTo):
+		return false
+	case self.CertifiedFresh && movie.Ratings.CriticsRating != certifiedFresh:
+		return false
+	}
+
+	if len(self.Mpaa) == 0 {
+		return true
+	}
+	for _, rating := range self.Mpaa {
+		if strings.EqualFold(rating, movie.MpaaRating) {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *SearchFilter) less() func(a, b Movie) bool {
+	var ascending func(a, b Movie) bool
+	switch self.Sort {
+	case SortCriticsScore:
+		ascending = func(a, b Movie) bool { return a.Ratings.CriticsScore < b.Ratings.CriticsScore }
+	case SortAudienceScore:
+		ascending = func(a, b Movie) bool { return a.Ratings.AudienceScore < b.Ratings.AudienceScore }
+	case SortYear:
+		ascending = func(a, b Movie) bool { return a.Year < b.Year }
+	case SortTitle:
+		ascending = func(a, b Movie) bool { return strings.ToLower(a.Title) < strings.ToLower(b.Title) }
+	default:
+		return nil
+	}
+
+	if self.Order == OrderDesc {
+		return func(a, b Movie) bool { return ascending(b, a) }
+	}
+	return ascending
+}
+
+// ParseSearchFilter reads the filter parameters of the search URL, nil - no filter is requested.
+// The order defaults to desc for the scores and year and asc for the title.
+func ParseSearchFilter(values url.Values) (*SearchFilter, *APIError) {
+	var filter SearchFilter
+	var fields []FieldError
+	requested := false
+
+	integer := func(name string, lowest, highest int, message string) int {
+		value := values.Get(name)
+		if len(value) == 0 {
+			return 0
+		}
+		requested = true
+		n, err := strconv.Atoi(value)
+		if err != nil || n < lowest || n > highest {
+			fields = append(fields, FieldError{name, message})
+			return 0
+		}
+		return n
+	}
+	filter.MinCriticsScore = integer("min_critics_score", 0, 100, "must be an integer between 0 and 100")
+	filter.MinAudienceScore = integer("min_audience_score", 0, 100, "must be an integer between 0 and 100")
+	filter.YearFrom = integer("year_from", 1, 9999, "must be a year")
+	filter.YearTo = integer("year_to", 1, 9999, "must be a year")
+	if filter.YearFrom > 0 && filter.YearTo > 0 && filter.YearFrom > filter.YearTo {
+		fields = append(fields, FieldError{"year_to", "cannot be before year_from"})
+	}
+
+	for _, value := range values["mpaa"] {
+		requested = true
+		for _, rating := range strings.Split(value, ",") {
+			if known := mpaaRating(strings.TrimSpace(rating)); len(known) > 0 {
+				filter.Mpaa = append(filter.Mpaa, known)
+			} else {
+				fields = append(fields, FieldError{"mpaa", fmt.Sprintf("must be one of %s, got '%s'", strings.Join(mpaaRatings, ", "), rating)})
+			}
+		}
+	}
+
+	if value := values.Get("certified_fresh"); len(value) > 0 {
+		requested = true
+		var err error
+		if filter.CertifiedFresh, err = strconv.ParseBool(value); err != nil {
+			fields = append(fields, FieldError{"certified_fresh", "must be true or false"})
+		}
+	}
+
+	switch filter.Sort = values.Get("sort"); filter.Sort {
+	case "":
+	case SortTitle:
+		filter.Order = OrderAsc
+	case SortCriticsScore, SortAudienceScore, SortYear:
+		filter.Order = OrderDesc
+	default:
+		fields = append(fields, FieldError{"sort", "must be one of critics_score, audience_score, year, title"})
+	}
+	switch order := values.Get("order"); order {
+	case "":
+	case OrderAsc, OrderDesc:
+		if len(filter.Sort) == 0 {
+			fields = append(fields, FieldError{"order", "requires sort"})
+		}
+		filter.Order = order
+	default:
+		fields = append(fields, FieldError{"order", "must be asc or desc"})
+	}
+	requested = requested || len(filter.Sort) > 0
+
+	if len(fields) > 0 {
+		return nil, NewAPIError(http.StatusBadRequest, CodeInvalidFilter, "Invalid search filter", fields...)
+	}
+	if !requested {
+		return nil, nil
+	}
+	return &filter, nil
+}
+
+// mpaaRating returns the known rating ignoring case, empty if it is unknown.
+func mpaaRating(rating string) string {
+	for _, known := range mpaaRatings {
+		if strings.EqualFold(known, rating) {
+			return known
+		}
+	}
+	return ""
+}
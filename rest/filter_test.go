@@ -0,0 +1,106 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	wq "github.com/plar/movie-service/workerqueue"
+)
+
+var filterMovies = []Movie{
+	{Id: "1", Title: "The Martian", Year: 2015, MpaaRating: "PG-13", Ratings: Ratings{CriticsRating: "Certified Fresh", CriticsScore: 92, AudienceScore: 92}},
+	{Id: "2", Title: "Martian Land", Year: 2015, MpaaRating: "Unrated", Ratings: Ratings{CriticsScore: -1, AudienceScore: 50}},
+	{Id: "3", Title: "Martian Child", Year: 2007, MpaaRating: "PG", Ratings: Ratings{CriticsRating: "Rotten", CriticsScore: 33, AudienceScore: 72}},
+	{Id: "4", Title: "Martians Go Home", Year: 1990, MpaaRating: "PG-13", Ratings: Ratings{CriticsScore: -1, AudienceScore: 18}},
+	{Id: "5", Title: "Unknown", MpaaRating: "Unrated", Ratings: Ratings{CriticsScore: -1}},
+}
+
+func movieIds(movies []Movie) []string {
+	var ids []string
+	for _, movie := range movies {
+		ids = append(ids, movie.Id)
+	}
+	return ids
+}
+
+func parseFilter(query string) (*SearchFilter, *APIError) {
+	values, _ := url.ParseQuery(query)
+	return ParseSearchFilter(values)
+}
+
+func TestParseSearchFilter(t *testing.T) {
+	filter, apiErr := parseFilter("q=martian")
+	assert.Nil(t, apiErr)
+	assert.Nil(t, filter)
+
+	filter, apiErr = parseFilter("min_critics_score=60&min_audience_score=0&year_from=1990&year_to=2015&mpaa=pg-13,PG&mpaa=g&certified_fresh=true&sort=year")
+	assert.Nil(t, apiErr)
+	assert.Equal(t, &SearchFilter{MinCriticsScore: 60, YearFrom: 1990, YearTo: 2015, Mpaa: []string{"PG-13", "PG", "G"},
+		CertifiedFresh: true, Sort: SortYear, Order: OrderDesc}, filter)
+
+	filter, apiErr = parseFilter("sort=title")
+	assert.Nil(t, apiErr)
+	assert.Equal(t, &SearchFilter{Sort: SortTitle, Order: OrderAsc}, filter)
+
+	filter, apiErr = parseFilter("sort=critics_score&order=asc")
+	assert.Nil(t, apiErr)
+	assert.Equal(t, &SearchFilter{Sort: SortCriticsScore, Order: OrderAsc}, filter)
+
+	_, apiErr = parseFilter("min_critics_score=101&min_audience_score=x&year_from=2015&year_to=1990&mpaa=XXX&certified_fresh=yes&sort=rating&order=up")
+	assert.Equal(t, NewAPIError(http.StatusBadRequest, CodeInvalidFilter, "Invalid search filter",
+		FieldError{"min_critics_score", "must be an integer between 0 and 100"},
+		FieldError{"min_audience_score", "must be an integer between 0 and 100"},
+		FieldError{"year_to", "cannot be before year_from"},
+		FieldError{"mpaa", "must be one of G, PG, PG-13, R, NC-17, Unrated, got 'XXX'"},
+		FieldError{"certified_fresh", "must be true or false"},
+		FieldError{"sort", "must be one of critics_score, audience_score, year, title"},
+		FieldError{"order", "must be asc or desc"},
+	), apiErr)
+
+	_, apiErr = parseFilter("order=desc")
+	assert.Equal(t, []FieldError{{"order", "requires sort"}}, apiErr.Fields)
+}
+
+func TestSearchFilterApply(t *testing.T) {
+	assert.Equal(t, []string{"1", "3"}, movieIds((&SearchFilter{MinCriticsScore: 30}).Apply(filterMovies)))
+	assert.Equal(t, []string{"1", "3"}, movieIds((&SearchFilter{MinAudienceScore: 72}).Apply(filterMovies)))
+	assert.Equal(t, []string{"1", "2", "3"}, movieIds((&SearchFilter{YearFrom: 2000}).Apply(filterMovies)))
+	assert.Equal(t, []string{"3", "4"}, movieIds((&SearchFilter{YearTo: 2010}).Apply(filterMovies)))
+	assert.Equal(t, []string{"1", "3", "4"}, movieIds((&SearchFilter{Mpaa: []string{"PG-13", "PG"}}).Apply(filterMovies)))
+	assert.Equal(t, []string{"1"}, movieIds((&SearchFilter{CertifiedFresh: true}).Apply(filterMovies)))
+	assert.Empty(t, (&SearchFilter{MinCriticsScore: 100}).Apply(filterMovies))
+
+	// the order of the equal movies is kept
+	assert.Equal(t, []string{"1", "2", "3", "4", "5"}, movieIds((&SearchFilter{Sort: SortYear, Order: OrderDesc}).Apply(filterMovies)))
+	assert.Equal(t, []string{"5", "4", "3", "1", "2"}, movieIds((&SearchFilter{Sort: SortYear, Order: OrderAsc}).Apply(filterMovies)))
+	assert.Equal(t, []string{"1", "3", "2", "4", "5"}, movieIds((&SearchFilter{Sort: SortCriticsScore, Order: OrderDesc}).Apply(filterMovies)))
+	assert.Equal(t, []string{"5", "4", "2", "3", "1"}, movieIds((&SearchFilter{Sort: SortAudienceScore, Order: OrderAsc}).Apply(filterMovies)))
+	assert.Equal(t, []string{"3", "2", "4", "1", "5"}, movieIds((&SearchFilter{Sort: SortTitle, Order: OrderAsc}).Apply(filterMovies)))
+
+	// the movies are not modified
+	assert.Equal(t, "1", filterMovies[0].Id)
+}
+
+func TestNewSearchPublishesFilter(t *testing.T) {
+	workerQueue := make(wq.WorkerQueue, 1)
+	worker, _ := wq.NewWorker(1, workerQueue)
+	worker.Start()
+	defer worker.Stop()
+
+	mq := &testmqAndClientImpl{}
+	client := &queryClient{movies: map[string][]Movie{"martian": filterMovies}}
+	factory := NewJobFactory(mq, client, workerQueue)
+
+	filter := &SearchFilter{MinCriticsScore: 30, Sort: SortCriticsScore, Order: OrderAsc}
+	published := make(chan error, 1)
+	factory.NewSearch(context.Background(), Request{RequestId: "RequestId"}, SearchQuery{Text: "martian", Filter: filter}, func(err error) { published <- err })
+	<-published
+
+	assert.Equal(t, []string{"3", "1"}, movieIds(mq.resp.Data.Movies))
+	assert.Equal(t, filter, mq.resp.Meta.Filter)
+	assert.Equal(t, 3, mq.resp.Meta.Excluded)
+}
@@ -0,0 +1,168 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type HealthOptions struct {
+	// How long the result of the AMQP connectivity probe is cached
+	MessageQueueProbeInterval time.Duration
+
+	// How long the result of the upstream provider probe is cached
+	UpstreamProbeInterval time.Duration
+
+	// Service is not ready when all workers are busy and this number of jobs wait for a worker
+	MaxPendingJobs int
+
+	// Worker pool is wedged when all workers are busy with their jobs longer than this timeout
+	WorkerStuckTimeout time.Duration
+}
+
+func DefaultHealthOptions() HealthOptions {
+	return HealthOptions{
+		MessageQueueProbeInterval: 10 * time.Second,
+		UpstreamProbeInterval:     60 * time.Second,
+		MaxPendingJobs:            totalWorkers,
+		WorkerStuckTimeout:        60 * time.Second,
+	}
+}
+
+type HealthCheck func() error
+
+type namedCheck struct {
+	name  string
+	check HealthCheck
+}
+
+// maxProbeTimeout bounds a single probe, so the readiness endpoint answers even when the probed service hangs.
+const maxProbeTimeout = 5 * time.Second
+
+// cachedCheck runs expensive probe at most once per ttl and gives up waiting for it after timeout.
+type cachedCheck struct {
+	sync.Mutex
+	ttl     time.Duration
+	timeout time.Duration
+	check   HealthCheck
+	checked time.Time
+	err     error
+
+	// result of the probe which has not finished yet, it is awaited instead of starting another one
+	running chan error
+}
+
+func (self *cachedCheck) Check() error {
+	self.Lock()
+	defer self.Unlock()
+
+	if !self.checked.IsZero() && time.Since(self.checked) < self.ttl {
+		return self.err
+	}
+
+	if self.running == nil {
+		running := make(chan error, 1)
+		go func() { running <- self.check() }()
+		self.running = running
+	}
+
+	timer := time.NewTimer(self.timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-self.running:
+		self.running = nil
+		self.err = err
+	case <-timer.C:
+		self.err = fmt.Errorf("probe did not finish in %s", self.timeout)
+	}
+	self.checked = time.Now()
+	return self.err
+}
+
+// newCachedCheck bounds the probe by its ttl, but never waits longer than maxProbeTimeout.
+func newCachedCheck(ttl time.Duration, check HealthCheck) HealthCheck {
+	timeout := maxProbeTimeout
+	if ttl > 0 && ttl < timeout {
+		timeout = ttl
+	}
+	return newCachedCheckTimeout(ttl, timeout, check)
+}
+
+func newCachedCheckTimeout(ttl, timeout time.Duration, check HealthCheck) HealthCheck {
+	c := &cachedCheck{ttl: ttl, timeout: timeout, check: check}
+	return c.Check
+}
+
+type healthChecker struct {
+	liveness  []namedCheck
+	readiness []namedCheck
+}
+
+func (self *healthChecker) AddLiveness(name string, check HealthCheck) {
+	self.liveness = append(self.liveness, namedCheck{name, check})
+}
+
+func (self *healthChecker) AddReadiness(name string, check HealthCheck) {
+	self.readiness = append(self.readiness, namedCheck{name, check})
+}
+
+func (self *healthChecker) Live() *HealthResponse {
+	return runChecks(self.liveness)
+}
+
+func (self *healthChecker) Ready() *HealthResponse {
+	return runChecks(self.readiness)
+}
+
+func runChecks(checks []namedCheck) *HealthResponse {
+	resp := &HealthResponse{Status: SUCCESS, Checks: make(map[string]HealthCheckResult, len(checks))}
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			resp.Status = ERROR
+			resp.Checks[c.name] = HealthCheckResult{Status: ERROR, Error: err.Error()}
+		} else {
+			resp.Checks[c.name] = HealthCheckResult{Status: SUCCESS}
+		}
+	}
+	return resp
+}
+
+func writeHealthResponse(w http.ResponseWriter, resp *HealthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+
+	body, err := json.Marshal(resp)
+	if err != nil {
+		writeError(w, "", NewAPIError(http.StatusInternalServerError, CodeEncodeFailed, "Cannot encode response body"))
+		return
+	}
+
+	if resp.Status != SUCCESS {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(body)
+}
+
+// workerPoolSaturated reports error when there are no idle workers and too many jobs wait for them.
+func workerPoolSaturated(idle, pending, maxPending int) error {
+	if idle == 0 && pending >= maxPending {
+		return fmt.Errorf("worker pool is saturated, %d jobs are waiting for a worker", pending)
+	}
+	return nil
+}
+
+// workerPoolWedged reports error when every worker is busy with a single job longer than timeout.
+func workerPoolWedged(busy []time.Duration, timeout time.Duration) error {
+	if len(busy) == 0 {
+		return nil
+	}
+
+	for _, d := range busy {
+		if d < timeout {
+			return nil
+		}
+	}
+	return fmt.Errorf("all %d workers are busy longer than %s", len(busy), timeout)
+}
@@ -0,0 +1,125 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+
+	"github.com/plar/movie-service/journal"
+	log "github.com/plar/movie-service/logging"
+	"github.com/plar/movie-service/tracing"
+)
+
+// jobs which fail to publish this many replays are dropped from the journal
+const maxReplayAttempts = 3
+
+// fieldJournalId is the log field with the journal record id
+const fieldJournalId = "journal_id"
+
+// journaledJob is the journal record of an accepted search.
+type journaledJob struct {
+	Request Request `json:"request"`
+	SearchQuery
+	Batch *BatchQuery       `json:"batch,omitempty"` // the batch search, the query is empty then
+	Trace map[string]string `json:"trace,omitempty"` // replayed job continues the trace of the request
+}
+
+// acceptJob stores the job in the journal before the request is acknowledged,
+// the returned callback completes the job once it is published.
+func (self *movieServer) acceptJob(ctx context.Context, req Request, query SearchQuery) (func(error), error) {
+	return self.accept(ctx, journaledJob{Request: req, SearchQuery: query})
+}
+
+// acceptBatch stores the batch search in the journal, it is completed once all items are published.
+func (self *movieServer) acceptBatch(ctx context.Context, req Request, batch BatchQuery) (func(error), error) {
+	return self.accept(ctx, journaledJob{Request: req, Batch: &batch})
+}
+
+func (self *movieServer) accept(ctx context.Context, job journaledJob) (func(error), error) {
+	if self.journal == nil {
+		return nil, nil
+	}
+
+	req := job.Request
+	job.Trace = tracing.InjectMap(ctx)
+	data, err := json.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := self.journal.Append(data)
+	if err != nil {
+		log.With(log.Fields{log.FieldRequestId: req.RequestId}).Errorf("Cannot store job in the journal, error=%s", err)
+		return nil, err
+	}
+	return self.completeJob(id, req.RequestId), nil
+}
+
+// completeJob removes published job from the journal, unpublished one is replayed on the next start.
+func (self *movieServer) completeJob(id uint64, requestId string) func(error) {
+	logger := log.With(log.Fields{log.FieldRequestId: requestId, fieldJournalId: id})
+	return func(err error) {
+		if err != nil {
+			logger.Warnf("Job is not published, keep it in the journal, error=%s", err)
+			return
+		}
+		if err := self.journal.Complete(id); err != nil {
+			logger.Errorf("Cannot complete job in the journal, error=%s", err)
+		}
+	}
+}
+
+// ReplayJournal dispatches jobs which were accepted but not published by the previous run.
+func (self *movieServer) ReplayJournal() {
+	self.replay(self.unpublished())
+}
+
+// unpublished returns the jobs left by the previous run, it is called before the listener starts,
+// so the jobs accepted by this run are not replayed.
+func (self *movieServer) unpublished() []journal.Entry {
+	if self.journal == nil {
+		return nil
+	}
+
+	entries, err := self.journal.Replay()
+	if err != nil {
+		log.Errorf("Cannot replay the journal, error=%s", err)
+		return nil
+	}
+	if len(entries) > 0 {
+		log.Infof("Replay %d job(s) from the journal", len(entries))
+	}
+	return entries
+}
+
+// replay dispatches the jobs of the journal entries.
+func (self *movieServer) replay(entries []journal.Entry) {
+	for _, entry := range entries {
+		var job journaledJob
+		if err := json.Unmarshal(entry.Data, &job); err != nil {
+			log.With(log.Fields{fieldJournalId: entry.Id}).Errorf("Cannot decode journaled job, drop it, error=%s", err)
+			self.journal.Complete(entry.Id)
+			continue
+		}
+
+		logger := log.With(log.Fields{log.FieldRequestId: job.Request.RequestId, fieldJournalId: entry.Id})
+		if entry.Attempts > maxReplayAttempts {
+			logger.Errorf("Job is not published after %d replays, drop it", maxReplayAttempts)
+			self.journal.Complete(entry.Id)
+			continue
+		}
+
+		logger.Infof("Replay job, accepted=%s, attempt=%d", entry.Accepted, entry.Attempts)
+		ctx := tracing.ExtractMap(context.Background(), job.Trace)
+		if job.Batch != nil {
+			// the batch waits for its workers in the background, the next jobs are not blocked by it
+			self.queueBatch(ctx, job.Request, *job.Batch, self.completeJob(entry.Id, job.Request.RequestId))
+			continue
+		}
+		self.metrics.JobQueued()
+		atomic.AddInt64(&self.pendingJobs, 1)
+		self.jobFactory.NewSearch(ctx, job.Request, job.SearchQuery, self.completeJob(entry.Id, job.Request.RequestId))
+		atomic.AddInt64(&self.pendingJobs, -1)
+		self.metrics.JobDispatched()
+	}
+}
@@ -0,0 +1,174 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type recordedSearch struct {
+	ctx   context.Context
+	req   Request
+	query SearchQuery
+	done  func(error)
+}
+
+type recordedBatch struct {
+	req   Request
+	batch BatchQuery
+	done  func(error)
+}
+
+// recordingJobFactory keeps jobs instead of running them.
+type recordingJobFactory struct {
+	sync.Mutex
+	searches []recordedSearch
+	batches  []recordedBatch
+}
+
+func (self *recordingJobFactory) NewSearch(ctx context.Context, req Request, query SearchQuery, done func(error)) {
+	self.searches = append(self.searches, recordedSearch{ctx, req, query, done})
+}
+
+// NewBatch is called by the goroutine of the batch request, the batches are read with recorded.
+func (self *recordingJobFactory) NewBatch(ctx context.Context, req Request, batch BatchQuery, dispatched func(), done func(error)) {
+	self.Lock()
+	defer self.Unlock()
+	self.batches = append(self.batches, recordedBatch{req, batch, done})
+}
+
+func (self *recordingJobFactory) recorded() []recordedBatch {
+	self.Lock()
+	defer self.Unlock()
+	return append([]recordedBatch(nil), self.batches...)
+}
+
+func (self *recordingJobFactory) Close() {
+}
+
+func newTestJournalServer(t *testing.T, fileName string) (*movieServer, *recordingJobFactory) {
+	factory := &recordingJobFactory{}
+	ctx := NewTestMovieServerContext()
+	ctx.JournalFile = fileName
+	ctx.JobFactory = factory
+	server, err := NewMovieServer(ctx)
+	assert.NoError(t, err)
+	return server.(*movieServer), factory
+}
+
+func searchRequest(server *movieServer, requestId, query string) int {
+	reqBody, _ := json.Marshal(Request{RequestId: requestId, ExchangeName: "movies", RoutingKey: "search"})
+	req, _ := http.NewRequest("POST", "http://movie-search.devel/movies?q="+query, bytes.NewReader(reqBody))
+	recorder := httptest.NewRecorder()
+	server.Router().ServeHTTP(recorder, req)
+	return recorder.Code
+}
+
+func TestJournalReplayAfterRestart(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "movie-service-journal")
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "jobs.db")
+
+	server, factory := newTestJournalServer(t, fileName)
+	assert.Equal(t, http.StatusOK, searchRequest(server, "published", "martian"))
+	assert.Equal(t, http.StatusOK, searchRequest(server, "failed", "alien"))
+	assert.Equal(t, http.StatusOK, searchRequest(server, "lost", "matrix"))
+	assert.Equal(t, 3, len(factory.searches))
+
+	factory.searches[0].done(nil)
+	factory.searches[1].done(errors.New("channel closed"))
+	server.Quit()
+
+	// unpublished jobs are replayed through the job factory
+	server, factory = newTestJournalServer(t, fileName)
+	server.ReplayJournal()
+	assert.Equal(t, 2, len(factory.searches))
+	assert.Equal(t, "failed", factory.searches[0].req.RequestId)
+	assert.Equal(t, SearchQuery{Text: "alien", Source: SourceUpstream}, factory.searches[0].query)
+	assert.Equal(t, "lost", factory.searches[1].req.RequestId)
+	assert.Equal(t, Request{RequestId: "lost", ExchangeName: "movies", RoutingKey: "search"}, factory.searches[1].req)
+
+	factory.searches[0].done(nil)
+	server.Quit()
+
+	// the job which is never published is dropped after the last attempt
+	for attempt := 2; attempt <= maxReplayAttempts+1; attempt++ {
+		server, factory = newTestJournalServer(t, fileName)
+		server.ReplayJournal()
+		if attempt <= maxReplayAttempts {
+			assert.Equal(t, 1, len(factory.searches))
+			assert.Equal(t, "lost", factory.searches[0].req.RequestId)
+		} else {
+			assert.Equal(t, 0, len(factory.searches))
+		}
+		server.Quit()
+	}
+
+	server, factory = newTestJournalServer(t, fileName)
+	server.ReplayJournal()
+	assert.Equal(t, 0, len(factory.searches))
+	server.Quit()
+}
+
+func TestJournalReplaySnapshot(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "movie-service-journal")
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "jobs.db")
+
+	server, _ := newTestJournalServer(t, fileName)
+	assert.Equal(t, http.StatusOK, searchRequest(server, "lost", "matrix"))
+	server.Quit()
+
+	// the job accepted after the snapshot is dispatched by its request only
+	server, factory := newTestJournalServer(t, fileName)
+	defer server.Quit()
+	entries := server.unpublished()
+	assert.Equal(t, http.StatusOK, searchRequest(server, "live", "martian"))
+	server.replay(entries)
+
+	if assert.Equal(t, 2, len(factory.searches)) {
+		assert.Equal(t, "live", factory.searches[0].req.RequestId)
+		assert.Equal(t, "lost", factory.searches[1].req.RequestId)
+	}
+}
+
+func TestJournalReplayContinuesTrace(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "movie-service-journal")
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "jobs.db")
+
+	server, _ := newTestJournalServer(t, fileName)
+	reqBody, _ := json.Marshal(Request{RequestId: "traced", ExchangeName: "movies", RoutingKey: "search"})
+	req, _ := http.NewRequest("POST", "http://movie-search.devel/movies?q=martian", bytes.NewReader(reqBody))
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	server.Router().ServeHTTP(httptest.NewRecorder(), req)
+	server.Quit()
+
+	server, factory := newTestJournalServer(t, fileName)
+	defer server.Quit()
+	server.ReplayJournal()
+	if !assert.Equal(t, 1, len(factory.searches)) {
+		return
+	}
+	sc := trace.SpanContextFromContext(factory.searches[0].ctx)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID().String())
+	assert.True(t, sc.IsRemote())
+}
+
+func TestJournalOpenError(t *testing.T) {
+	ctx := NewTestMovieServerContext()
+	ctx.JournalFile = "/not-existing-dir/jobs.db"
+	_, err := NewMovieServer(ctx)
+	assert.Error(t, err)
+}
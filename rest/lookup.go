@@ -0,0 +1,226 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"regexp"
+
+	"go.opentelemetry.io/otel/trace"
+
+	log "github.com/plar/movie-service/logging"
+	"github.com/plar/movie-service/normalize"
+)
+
+// How the movie of the lookup was matched
+const (
+	MatchImdbId = "imdb_id" // the alias of the provider, the confidence is 1
+	MatchTitle  = "title"   // the search results ranked by the title and year
+)
+
+const (
+	// fieldImdbId is the log field with the IMDb id of the lookup
+	fieldImdbId = "imdb_id"
+
+	// the best match below this confidence is not published, the movie is not found
+	minConfidence = 0.5
+
+	// weights of the title and the year in the confidence of the title match,
+	// the title alone may match the remakes, it is never fully confident
+	titleWeight   = 0.7
+	yearWeight    = 0.3
+	noYearWeight  = 0.9
+	nearYearScore = 0.5 // the release years of the countries differ
+)
+
+// IMDb id with optional "tt" prefix, e.g. tt3659388
+var imdbIdPattern = regexp.MustCompile(`^(?i:tt)?[0-9]{1,10}$`)
+
+// MovieLookup finds the movie by its IMDb id or by the title and the optional year.
+type MovieLookup struct {
+	ImdbId string `json:"imdb_id,omitempty"`
+	Title  string `json:"title,omitempty"`
+	Year   int    `json:"year,omitempty"`
+}
+
+// LookupRequest is the body of the lookup, the match is published with its Request envelope.
+type LookupRequest struct {
+	Request
+	MovieLookup
+}
+
+// MovieMatch reports how the movie of the lookup was matched.
+type MovieMatch struct {
+	By         string  `json:"by"`         // MatchImdbId or MatchTitle
+	Confidence float64 `json:"confidence"` // 0..1, 1 - the same movie
+}
+
+// match returns the best match of the lookup, ErrMovieNotFound when no movie is confident enough.
+func (self *jobFactory) match(lookup MovieLookup) (searchResult, error) {
+	if len(lookup.ImdbId) > 0 {
+		movie, err := getMovieByImdb(self.client, lookup.ImdbId)
+		if err != nil {
+			return searchResult{}, err
+		}
+		return searchResult{movies: []Movie{*movie}, match: &MovieMatch{By: MatchImdbId, Confidence: 1}}, nil
+	}
+
+	title := normalize.Title(lookup.Title)
+	result, err := self.searchText(SourceUpstream, title)
+	if err != nil {
+		return result, err
+	}
+
+	// the provider order wins the ties
+	best, bestConfidence := -1, 0.0
+	for i, movie := range result.movies {
+		if c := confidence(title, lookup.Year, movie); c > bestConfidence {
+			best, bestConfidence = i, c
+		}
+	}
+	if best < 0 || bestConfidence < minConfidence {
+		return searchResult{}, ErrMovieNotFound
+	}
+
+	result.movies = []Movie{result.movies[best]}
+	result.match = &MovieMatch{By: MatchTitle, Confidence: bestConfidence}
+	return result, nil
+}
+
+// confidence scores the movie against the normalized title and the year, 0 - no year is requested.
+func confidence(title string, year int, movie Movie) float64 {
+	candidate := normalize.Title(movie.Title)
+	longest := len([]rune(title))
+	if n := len([]rune(candidate)); n > longest {
+		longest = n
+	}
+	similarity := 1.0
+	if longest > 0 {
+		similarity = 1 - float64(normalize.Distance(title, candidate))/float64(longest)
+	}
+
+	score := similarity * noYearWeight
+	if year != 0 {
+		var yearScore float64
+		switch movie.Year - year {
+		case 0:
+			yearScore = 1
+		case -1, 1:
+			yearScore = nearYearScore
+		}
+		score = similarity*titleWeight + yearScore*yearWeight
+	}
+	return math.Round(score*100) / 100
+}
+
+// parseLookup checks the body of the lookup.
+func parseLookup(body LookupRequest) (MovieLookup, *APIError) {
+	lookup := body.MovieLookup
+	var fields []FieldError
+	switch {
+	case len(lookup.ImdbId) > 0 && len(lookup.Title) > 0:
+		fields = append(fields, FieldError{"title", "cannot be combined with imdb_id"})
+	case len(lookup.ImdbId) > 0 && lookup.Year != 0:
+		fields = append(fields, FieldError{"year", "requires title"})
+	case len(lookup.ImdbId) > 0 && !imdbIdPattern.MatchString(lookup.ImdbId):
+		fields = append(fields, FieldError{"imdb_id", "must be an IMDb id, e.g. tt3659388"})
+	case len(lookup.ImdbId) > 0:
+	case len(lookup.Title) == 0:
+		fields = append(fields, FieldError{"imdb_id", "imdb_id or title is required"})
+	case len(normalize.Title(lookup.Title)) == 0:
+		fields = append(fields, FieldError{"title", "must contain letters or digits"})
+	}
+	if lookup.Year != 0 && (lookup.Year < 1 || lookup.Year > 9999) {
+		fields = append(fields, FieldError{"year", "must be a year"})
+	}
+
+	if len(fields) > 0 {
+		return MovieLookup{}, NewAPIError(http.StatusUnprocessableEntity, CodeInvalidLookup, "Invalid lookup", fields...)
+	}
+	return lookup, nil
+}
+
+// Lookup accepts the lookup of the movie by its IMDb id or its title and year, the best match is published.
+func (self *movieServer) Lookup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if !self.admit() {
+		writeError(w, requestId(r), NewAPIError(http.StatusServiceUnavailable, CodeShuttingDown, "Service is shutting down"))
+		return
+	}
+	defer self.release()
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, requestId(r), NewAPIError(http.StatusInternalServerError, CodeBodyReadFailed, "Cannot read request body"))
+		return
+	}
+
+	var body LookupRequest
+	if err := json.Unmarshal(data, &body); err != nil {
+		writeError(w, requestId(r), NewAPIError(http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("Cannot decode request body: %v", err)))
+		return
+	}
+	req := body.Request
+
+	client := self.auth.Client(r)
+	span := trace.SpanFromContext(r.Context())
+	span.SetAttributes(attrRequestId.String(req.RequestId), attrClient.String(client))
+	logger := log.With(log.Fields{log.FieldRequestId: req.RequestId, log.FieldClient: client})
+
+	// the rejected lookups are audited with the query of the body too
+	query := body.Title
+	if len(body.ImdbId) > 0 {
+		query = body.ImdbId
+	}
+	lookup, apiErr := parseLookup(body)
+	if apiErr != nil {
+		logger.Warnf("Lookup rejected, error=%s", apiErr)
+		self.auditReceived(req, query, client, apiErr)
+		writeError(w, req.RequestId, apiErr)
+		return
+	}
+	search := SearchQuery{Source: SourceUpstream, Lookup: &lookup}
+	logger = logger.With(queryFields(search))
+
+	_, _, validator := self.settings()
+	if verr := validator.Validate(client, &req); verr != nil {
+		logger.With(log.Fields{log.FieldExchange: req.ExchangeName, log.FieldRoutingKey: req.RoutingKey}).
+			Warnf("Request rejected, error=%s", verr)
+		self.auditReceived(req, query, client, verr)
+		writeError(w, req.RequestId, verr)
+		return
+	}
+
+	// the job outlives the request, it keeps the span only
+	ctx := trace.ContextWithSpan(context.Background(), span)
+	done, err := self.acceptJob(ctx, req, search)
+	if err != nil {
+		self.auditReceived(req, query, client, err)
+		writeError(w, req.RequestId, NewAPIError(http.StatusInternalServerError, CodeJournalFailed, "Cannot store the job"))
+		return
+	}
+	self.auditReceived(req, query, client, nil)
+
+	resp := Response{
+		RequestId:    req.RequestId,
+		Method:       "movies/lookup",
+		Query:        query,
+		Source:       SourceUpstream,
+		ExchangeName: req.ExchangeName,
+		RoutingKey:   req.RoutingKey,
+	}
+
+	data, err = json.Marshal(resp)
+	if err != nil {
+		writeError(w, req.RequestId, NewAPIError(http.StatusInternalServerError, CodeEncodeFailed, "Cannot encode response body"))
+		return
+	}
+	w.Write(data)
+	logger.Debugf("Lookup accepted")
+
+	self.dispatch(ctx, req, search, done)
+}
@@ -0,0 +1,166 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/plar/movie-service/audit"
+	wq "github.com/plar/movie-service/workerqueue"
+)
+
+var lookupMovies = []Movie{
+	{Id: "1", Title: "Martian Child", Year: 2007},
+	{Id: "2", Title: "The Martian", Year: 2015, ImdbId: "3659388"},
+	{Id: "3", Title: "Martians Go Home", Year: 1990},
+}
+
+// imdbClient searches the lookup movies and looks them up by the IMDb id.
+type imdbClient struct {
+	queryClient
+}
+
+func (self *imdbClient) MovieByImdb(imdbId string) (*Movie, error) {
+	for _, movie := range lookupMovies {
+		if len(movie.ImdbId) > 0 && "tt"+movie.ImdbId == imdbId {
+			return &movie, nil
+		}
+	}
+	return nil, ErrMovieNotFound
+}
+
+func TestParseLookup(t *testing.T) {
+	lookup, apiErr := parseLookup(LookupRequest{MovieLookup: MovieLookup{ImdbId: "tt3659388"}})
+	assert.Nil(t, apiErr)
+	assert.Equal(t, MovieLookup{ImdbId: "tt3659388"}, lookup)
+
+	lookup, apiErr = parseLookup(LookupRequest{MovieLookup: MovieLookup{Title: "The Martian", Year: 2015}})
+	assert.Nil(t, apiErr)
+	assert.Equal(t, MovieLookup{Title: "The Martian", Year: 2015}, lookup)
+
+	_, apiErr = parseLookup(LookupRequest{})
+	assert.Equal(t, NewAPIError(http.StatusUnprocessableEntity, CodeInvalidLookup, "Invalid lookup",
+		FieldError{"imdb_id", "imdb_id or title is required"}), apiErr)
+
+	for body, fields := range map[MovieLookup][]FieldError{
+		{ImdbId: "tt3659388", Title: "The Martian"}: {{"title", "cannot be combined with imdb_id"}},
+		{ImdbId: "tt3659388", Year: 2015}:           {{"year", "requires title"}},
+		{ImdbId: "nm0000354"}:                       {{"imdb_id", "must be an IMDb id, e.g. tt3659388"}},
+		{Title: "?!"}:                               {{"title", "must contain letters or digits"}},
+		{Title: "The Martian", Year: -1}:            {{"year", "must be a year"}},
+	} {
+		_, apiErr = parseLookup(LookupRequest{MovieLookup: body})
+		assert.Equal(t, fields, apiErr.Fields, body)
+	}
+}
+
+func TestConfidence(t *testing.T) {
+	martian := Movie{Title: "The Martian", Year: 2015}
+	assert.Equal(t, 1.0, confidence("martian", 2015, martian))
+	assert.Equal(t, 0.85, confidence("martian", 2014, martian))
+	assert.Equal(t, 0.7, confidence("martian", 1990, martian))
+	assert.Equal(t, 0.9, confidence("martian", 0, martian))
+
+	// the misspelled title is close
+	assert.Equal(t, 0.9, confidence("martain", 2015, martian))
+	assert.Equal(t, 0.68, confidence("martian child", 2015, martian))
+	assert.Equal(t, 0.38, confidence("martian child", 2007, martian))
+}
+
+func TestMatch(t *testing.T) {
+	client := &imdbClient{queryClient{movies: map[string][]Movie{"martian": lookupMovies}}}
+	factory := newRewriteFactory(client, nil)
+
+	result, err := factory.match(MovieLookup{Title: "The Martian", Year: 2015})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"martian"}, client.queries)
+	assert.Equal(t, []Movie{lookupMovies[1]}, result.movies)
+	assert.Equal(t, &MovieMatch{By: MatchTitle, Confidence: 1}, result.match)
+
+	// the year picks the movie among the similar titles
+	client.movies["martian child"] = lookupMovies
+	result, err = factory.match(MovieLookup{Title: "Martian Child", Year: 2007})
+	assert.NoError(t, err)
+	assert.Equal(t, []Movie{lookupMovies[0]}, result.movies)
+
+	result, err = factory.match(MovieLookup{ImdbId: "tt3659388"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Movie{lookupMovies[1]}, result.movies)
+	assert.Equal(t, &MovieMatch{By: MatchImdbId, Confidence: 1}, result.match)
+
+	_, err = factory.match(MovieLookup{ImdbId: "tt0133093"})
+	assert.Equal(t, ErrMovieNotFound, err)
+
+	// the results which are not similar are not matched
+	client.movies["alien"] = lookupMovies
+	_, err = factory.match(MovieLookup{Title: "Alien", Year: 1979})
+	assert.Equal(t, ErrMovieNotFound, err)
+}
+
+func TestNewSearchPublishesMatch(t *testing.T) {
+	workerQueue := make(wq.WorkerQueue, 1)
+	worker, _ := wq.NewWorker(1, workerQueue)
+	worker.Start()
+	defer worker.Stop()
+
+	mq := &testmqAndClientImpl{}
+	client := &imdbClient{queryClient{movies: map[string][]Movie{"martian": lookupMovies}}}
+	factory := NewJobFactory(mq, client, workerQueue)
+
+	published := make(chan error, 1)
+	factory.NewSearch(context.Background(), Request{RequestId: "RequestId"}, SearchQuery{Lookup: &MovieLookup{Title: "Martian", Year: 2014}},
+		func(err error) { published <- err })
+	<-published
+	assert.Equal(t, []Movie{lookupMovies[1]}, mq.resp.Data.Movies)
+	assert.Equal(t, &MovieMatch{By: MatchTitle, Confidence: 0.85}, mq.resp.Meta.Match)
+
+	factory.NewSearch(context.Background(), Request{RequestId: "RequestId"}, SearchQuery{Lookup: &MovieLookup{ImdbId: "tt0133093"}},
+		func(err error) { published <- err })
+	<-published
+	assert.Equal(t, ERROR, mq.resp.Meta.Status)
+	assert.Equal(t, CodeMovieNotFound, mq.resp.Meta.Code)
+	assert.Nil(t, mq.resp.Meta.Match)
+}
+
+func TestMovieServerLookup(t *testing.T) {
+	factory := &recordingJobFactory{}
+	ctx := NewTestMovieServerContext()
+	ctx.JobFactory = factory
+	s, err := NewMovieServer(ctx)
+	assert.NoError(t, err)
+	server := s.(*movieServer)
+	defer server.Quit()
+
+	req := Request{RequestId: "RequestId", ExchangeName: "movies", RoutingKey: "lookup"}
+	recorder := batchRequest(server, "/movies/lookup", LookupRequest{Request: req, MovieLookup: MovieLookup{ImdbId: "tt3659388"}})
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var resp Response
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &resp))
+	assert.Equal(t, Response{RequestId: "RequestId", Method: "movies/lookup", Query: "tt3659388", Source: SourceUpstream, ExchangeName: "movies", RoutingKey: "lookup"}, resp)
+	if assert.Equal(t, 1, len(factory.searches)) {
+		assert.Equal(t, req, factory.searches[0].req)
+		assert.Equal(t, SearchQuery{Source: SourceUpstream, Lookup: &MovieLookup{ImdbId: "tt3659388"}}, factory.searches[0].query)
+	}
+
+	recorder = batchRequest(server, "/movies/lookup", LookupRequest{Request: req, MovieLookup: MovieLookup{Year: 2015}})
+	assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"code":"INVALID_LOOKUP"`)
+
+	// the rejected lookup is audited with its query
+	auditLog := &recordingAuditLogger{}
+	server.audit = auditLog
+	recorder = batchRequest(server, "/movies/lookup", LookupRequest{Request: req, MovieLookup: MovieLookup{ImdbId: "tt3659388", Year: 2015}})
+	assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+	if events := auditLog.Events(); assert.Equal(t, 1, len(events)) {
+		assert.Equal(t, audit.OutcomeRejected, events[0].Outcome)
+		assert.Equal(t, "tt3659388", events[0].Query)
+	}
+
+	recorder = batchRequest(server, "/movies/lookup", LookupRequest{MovieLookup: MovieLookup{Title: "The Martian"}})
+	assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"code":"INVALID_REQUEST"`)
+	assert.Equal(t, 1, len(factory.searches))
+}
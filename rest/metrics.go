@@ -0,0 +1,328 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	wq "github.com/plar/movie-service/workerqueue"
+)
+
+const (
+	metricsNamespace = "movie_service"
+
+	unmatchedRoute = "unmatched"
+	unknownClient  = "anonymous"
+	otherExchange  = "other"
+
+	resultSuccess = "success"
+	resultError   = "error"
+
+	CacheHit  = "hit"
+	CacheMiss = "miss"
+)
+
+// Metrics keeps all service collectors, each server has its own registry.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests *prometheus.CounterVec
+	httpLatency  *prometheus.HistogramVec
+
+	jobsPending prometheus.Gauge
+
+	upstreamRequests *prometheus.CounterVec
+	upstreamLatency  *prometheus.HistogramVec
+
+	circuitState       *prometheus.GaugeVec
+	circuitTransitions *prometheus.CounterVec
+	circuitRejections  *prometheus.CounterVec
+
+	publishes      *prometheus.CounterVec
+	publishLatency prometheus.Histogram
+
+	cacheRequests *prometheus.CounterVec
+
+	authRejections *prometheus.CounterVec
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "Total number of HTTP requests by route, method, status code and client.",
+		}, []string{"route", "method", "code", "client"}),
+		httpLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+
+		jobsPending: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "jobs_pending",
+			Help:      "Number of jobs waiting for a free worker.",
+		}),
+
+		upstreamRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "upstream_requests_total",
+			Help:      "Total number of upstream provider calls by provider, method and result.",
+		}, []string{"provider", "method", "result"}),
+		upstreamLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "upstream_request_duration_seconds",
+			Help:      "Upstream provider call latency by provider and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"provider", "method"}),
+
+		circuitState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "upstream_circuit_state",
+			Help:      "Circuit breaker state by provider: 0 - closed, 1 - half-open, 2 - open.",
+		}, []string{"provider"}),
+		circuitTransitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "upstream_circuit_transitions_total",
+			Help:      "Total number of circuit breaker state changes by provider and new state.",
+		}, []string{"provider", "state"}),
+		circuitRejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "upstream_circuit_rejections_total",
+			Help:      "Total number of upstream calls failed immediately because the circuit is not closed.",
+		}, []string{"provider"}),
+
+		publishes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "amqp_publish_total",
+			Help:      "Total number of AMQP publishes by exchange and result.",
+		}, []string{"exchange", "result"}),
+		publishLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "amqp_publish_duration_seconds",
+			Help:      "AMQP publish latency including connect and exchange declaration.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+
+		cacheRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "cache_requests_total",
+			Help:      "Total number of cache lookups by cache and result (hit or miss).",
+		}, []string{"cache", "result"}),
+
+		authRejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "auth_rejections_total",
+			Help:      "Total number of requests rejected by authentication, rate limits or quotas by client and reason.",
+		}, []string{"client", "reason"}),
+	}
+
+	m.registry.MustRegister(
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		prometheus.NewGoCollector(),
+		m.httpRequests, m.httpLatency,
+		m.jobsPending,
+		m.upstreamRequests, m.upstreamLatency,
+		m.circuitState, m.circuitTransitions, m.circuitRejections,
+		m.publishes, m.publishLatency,
+		m.cacheRequests,
+		m.authRejections,
+	)
+	return m
+}
+
+func (self *Metrics) Registry() *prometheus.Registry {
+	return self.registry
+}
+
+func (self *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(self.registry, promhttp.HandlerOpts{})
+}
+
+// RegisterWorkerPool exposes worker pool size and utilization.
+func (self *Metrics) RegisterWorkerPool(workerQueue wq.WorkerQueue, pool *wq.Pool) {
+	self.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "workers_total",
+			Help:      "Number of workers in the pool.",
+		}, func() float64 {
+			return float64(pool.Size())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "workers_busy",
+			Help:      "Number of workers processing a job.",
+		}, func() float64 {
+			busy := pool.Size() - len(workerQueue)
+			if busy < 0 {
+				busy = 0
+			}
+			return float64(busy)
+		}),
+	)
+}
+
+func (self *Metrics) JobQueued() {
+	self.jobsPending.Inc()
+}
+
+func (self *Metrics) JobDispatched() {
+	self.jobsPending.Dec()
+}
+
+func (self *Metrics) ObserveUpstream(provider, method string, started time.Time, err error) {
+	self.upstreamLatency.WithLabelValues(provider, method).Observe(time.Since(started).Seconds())
+	self.upstreamRequests.WithLabelValues(provider, method, result(err)).Inc()
+}
+
+// CircuitState sets the current circuit breaker state of the provider.
+func (self *Metrics) CircuitState(provider string, state BreakerState) {
+	self.circuitState.WithLabelValues(provider).Set(float64(state))
+}
+
+func (self *Metrics) CircuitStateChanged(provider string, state BreakerState) {
+	self.CircuitState(provider, state)
+	self.circuitTransitions.WithLabelValues(provider, state.String()).Inc()
+}
+
+func (self *Metrics) CircuitRejected(provider string) {
+	self.circuitRejections.WithLabelValues(provider).Inc()
+}
+
+func (self *Metrics) ObservePublish(exchange string, started time.Time, err error) {
+	self.publishLatency.Observe(time.Since(started).Seconds())
+	self.publishes.WithLabelValues(exchange, result(err)).Inc()
+}
+
+// exchangeLabel bounds the exchange label by the allowed exchanges: the exchange is labeled by its name
+// or by the longest pattern which allows it, all other exchanges are otherExchange.
+func exchangeLabel(allowedExchanges map[string][]string, name string) string {
+	label := otherExchange
+	for _, patterns := range allowedExchanges {
+		for _, pattern := range patterns {
+			switch {
+			case pattern == name:
+				return name
+			case pattern == anyClient || !matchName(pattern, name):
+			case label == otherExchange || len(pattern) > len(label) || len(pattern) == len(label) && pattern < label:
+				label = pattern
+			}
+		}
+	}
+	return label
+}
+
+// ObserveCache records cache lookup result, CacheHit or CacheMiss.
+func (self *Metrics) ObserveCache(cache, res string) {
+	self.cacheRequests.WithLabelValues(cache, res).Inc()
+}
+
+// AuthRejected records request rejected by authenticator, reason is the error code.
+func (self *Metrics) AuthRejected(client, reason string) {
+	self.authRejections.WithLabelValues(client, reason).Inc()
+}
+
+// Middleware records request count and latency for every route of the router,
+// clientOf returns client name of the request.
+func (self *Metrics) Middleware(clientOf func(r *http.Request) string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+
+			route := routeName(r)
+			client := clientOf(r)
+			self.httpLatency.WithLabelValues(route, r.Method).Observe(time.Since(started).Seconds())
+			self.httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(recorder.status), client).Inc()
+		})
+	}
+}
+
+func routeName(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return unmatchedRoute
+}
+
+func result(err error) string {
+	if err != nil {
+		return resultError
+	}
+	return resultSuccess
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (self *statusRecorder) WriteHeader(status int) {
+	self.status = status
+	self.ResponseWriter.WriteHeader(status)
+}
+
+// instrumentedClient records latency and errors of the wrapped provider client.
+type instrumentedClient struct {
+	provider string
+	client   Client
+	metrics  *Metrics
+}
+
+func (self *instrumentedClient) Search(query string) ([]Movie, error) {
+	started := time.Now()
+	movies, err := self.client.Search(query)
+	self.metrics.ObserveUpstream(self.provider, "search", started, err)
+	return movies, err
+}
+
+func (self *instrumentedClient) Movie(id string) (*Movie, error) {
+	started := time.Now()
+	movie, err := getMovie(self.client, id)
+	self.observeLookup("movie", started, err)
+	return movie, err
+}
+
+func (self *instrumentedClient) MovieByImdb(imdbId string) (*Movie, error) {
+	started := time.Now()
+	movie, err := getMovieByImdb(self.client, imdbId)
+	self.observeLookup("alias", started, err)
+	return movie, err
+}
+
+// observeLookup records the movie lookup, the provider answered when the movie is not found.
+func (self *instrumentedClient) observeLookup(method string, started time.Time, err error) {
+	if errors.Is(err, ErrMovieNotFound) {
+		err = nil
+	}
+	self.metrics.ObserveUpstream(self.provider, method, started, err)
+}
+
+func (self *instrumentedClient) Ping() error {
+	pinger, ok := self.client.(Pinger)
+	if !ok {
+		return nil
+	}
+
+	started := time.Now()
+	err := pinger.Ping()
+	self.metrics.ObserveUpstream(self.provider, "ping", started, err)
+	return err
+}
+
+func NewInstrumentedClient(provider string, client Client, metrics *Metrics) Client {
+	return &instrumentedClient{provider, client, metrics}
+}
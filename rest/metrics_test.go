@@ -0,0 +1,119 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	wq "github.com/plar/movie-service/workerqueue"
+)
+
+func TestMetricsHttpRequests(t *testing.T) {
+	metrics := NewMetrics()
+	ctx := NewTestMovieServerContext()
+	ctx.Metrics = metrics
+	server, _ := NewMovieServer(ctx)
+
+	reqBody, _ := json.Marshal(Request{ExchangeName: "ExchangeName", RoutingKey: "RoutingKey"})
+	req, _ := http.NewRequest("POST", "http://movie-search.devel/movies?q=martian", bytes.NewReader(reqBody))
+	req.Header.Set(ClientIdHeader, "catalog")
+	server.Router().ServeHTTP(httptest.NewRecorder(), req)
+
+	req, _ = http.NewRequest("POST", "http://movie-search.devel/series", nil)
+	server.Router().ServeHTTP(httptest.NewRecorder(), req)
+
+	// X-Client-Id is not trusted without auth, it does not create series
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.httpRequests.WithLabelValues("/movies", "POST", "200", unknownClient)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.httpRequests.WithLabelValues(unmatchedRoute, "POST", "404", unknownClient)))
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.jobsPending))
+}
+
+func TestMetricsHandler(t *testing.T) {
+	ctx := NewTestMovieServerContext()
+	server, _ := NewMovieServer(ctx)
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://movie-search.devel/metrics", nil)
+	server.Router().ServeHTTP(recorder, req)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	body := recorder.Body.String()
+	assert.True(t, strings.Contains(body, "movie_service_workers_total 10"))
+	assert.True(t, strings.Contains(body, "movie_service_jobs_pending 0"))
+}
+
+func TestMetricsWorkerPool(t *testing.T) {
+	metrics := NewMetrics()
+	workerQueue := make(wq.WorkerQueue, 2)
+	pool, _ := wq.NewPool(workerQueue, 2)
+	metrics.RegisterWorkerPool(workerQueue, pool)
+	defer pool.Stop()
+
+	for len(workerQueue) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// one worker is busy
+	release := make(chan bool)
+	worker := <-workerQueue
+	worker <- func(id int) {
+		<-release
+	}
+	defer close(release)
+
+	count, err := testutil.GatherAndCount(metrics.Registry(), "movie_service_workers_busy", "movie_service_workers_total")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	expected := `
+# HELP movie_service_workers_busy Number of workers processing a job.
+# TYPE movie_service_workers_busy gauge
+movie_service_workers_busy 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(metrics.Registry(), strings.NewReader(expected), "movie_service_workers_busy"))
+}
+
+func TestMetricsInstrumentedClient(t *testing.T) {
+	metrics := NewMetrics()
+
+	client := NewInstrumentedClient("test", &testmqAndClientImpl{}, metrics)
+	movies, err := client.Search("martian")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(movies))
+
+	client = NewInstrumentedClient("test", &testmqAndClientImpl{simulateSearchError: errors.New("API is not available")}, metrics)
+	_, err = client.Search("martian")
+	assert.EqualError(t, err, "API is not available")
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.upstreamRequests.WithLabelValues("test", "search", resultSuccess)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.upstreamRequests.WithLabelValues("test", "search", resultError)))
+}
+
+func TestMetricsPublishAndCache(t *testing.T) {
+	metrics := NewMetrics()
+
+	metrics.ObservePublish("movies", time.Now(), nil)
+	metrics.ObservePublish("movies", time.Now(), errors.New("channel closed"))
+	metrics.ObservePublish("movies", time.Now(), errors.New("channel closed"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.publishes.WithLabelValues("movies", resultSuccess)))
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.publishes.WithLabelValues("movies", resultError)))
+
+	assert.Equal(t, "movies", exchangeLabel(map[string][]string{"billing": {"movies"}}, "movies"))
+	assert.Equal(t, "billing.*", exchangeLabel(map[string][]string{"billing": {"billing.*"}, "catalog": {"*"}}, "billing.results"))
+	assert.Equal(t, "billing.results.*", exchangeLabel(map[string][]string{"billing": {"billing.*", "billing.results.*"}}, "billing.results.eu"))
+	assert.Equal(t, otherExchange, exchangeLabel(map[string][]string{"billing": {"billing.*"}, "*": {"*"}}, "random-1234"))
+	assert.Equal(t, otherExchange, exchangeLabel(nil, "movies"))
+
+	metrics.ObserveCache("search", CacheHit)
+	metrics.ObserveCache("search", CacheMiss)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.cacheRequests.WithLabelValues("search", CacheHit)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.cacheRequests.WithLabelValues("search", CacheMiss)))
+}
@@ -1,35 +1,109 @@
 package rest
 
+import (
+	"errors"
+	"time"
+)
+
 const (
 	SUCCESS = "success"
 	ERROR   = "error"
 )
 
+// Sources of the search results
+const (
+	SourceUpstream = "upstream"
+	SourceLocal    = "local" // the local catalog, the provider is not called
+)
+
 // Movie Service Request objects
 
 type Request struct {
 	RequestId    string `json:"request_id,omitempty"`
 	ExchangeName string `json:"exchange_name,omitempty"`
 	RoutingKey   string `json:"routing_key,omitempty"`
+
+	// Optional per request overrides of the configured PublishOptions
+	ExchangeType    string            `json:"exchange_type,omitempty"`
+	ExchangeDeclare string            `json:"exchange_declare,omitempty"` // only stricter than the configured one
+	DeliveryMode    string            `json:"delivery_mode,omitempty"`
+	Expiration      string            `json:"expiration,omitempty"`
+	Priority        *uint8            `json:"priority,omitempty"`
+	CorrelationId   string            `json:"correlation_id,omitempty"`
+	Headers         map[string]string `json:"headers,omitempty"`
+}
+
+// SearchQuery is the query of the search job with the search parameters of the request URL.
+type SearchQuery struct {
+	Text   string        `json:"query"`
+	Id     string        `json:"id,omitempty"`     // the movie is looked up by the provider id instead of the text
+	Lookup *MovieLookup  `json:"lookup,omitempty"` // the best match of the lookup is published instead of the results
+	Source string        `json:"source,omitempty"` // empty - SourceUpstream
+	Filter *SearchFilter `json:"filter,omitempty"` // nil - the results are published as found
 }
 
 type Response struct {
 	RequestId    string `json:"request_id,omitempty"`
 	Method       string `json:"method,omitempty"`
 	Query        string `json:"query,omitempty"`
+	Source       string `json:"source,omitempty"`
+	Items        int    `json:"items,omitempty"` // of the batch search
 	ExchangeName string `json:"exchange_name,omitempty"`
 	RoutingKey   string `json:"routing_key,omitempty"`
 }
 
+type ErrorResponse struct {
+	Meta Meta `json:"meta"`
+}
+
+type HealthCheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type HealthResponse struct {
+	Status string                       `json:"status"`
+	Checks map[string]HealthCheckResult `json:"checks"`
+}
+
 // Movie MQ Response objects
 type Meta struct {
-	RequestId string `json:"request_id,omitempty"`
-	Status    string `json:"status"`
-	Error     string `json:"error,omitempty"`
+	RequestId string       `json:"request_id,omitempty"`
+	Status    string       `json:"status"`
+	Code      string       `json:"code,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+
+	// cached results are served because the provider failed
+	Stale      bool  `json:"stale,omitempty"`
+	AgeSeconds int64 `json:"age_seconds,omitempty"`
+
+	// the query was rewritten before the search
+	Rewrite *QueryRewrite `json:"rewrite,omitempty"`
+
+	// the filter applied to the results and the number of movies it excluded
+	Filter   *SearchFilter `json:"filter,omitempty"`
+	Excluded int           `json:"excluded,omitempty"`
+
+	// the item of the batch search published on its own
+	Batch *BatchItem `json:"batch,omitempty"`
+
+	// how the movie of the lookup was matched
+	Match *MovieMatch `json:"match,omitempty"`
+}
+
+// QueryRewrite reports the query which was searched instead of the requested one.
+type QueryRewrite struct {
+	Query     string `json:"query"`               // the searched query, it matched the results
+	Year      int    `json:"year,omitempty"`      // the year of the requested query, its movies go first
+	Suggested bool   `json:"suggested,omitempty"` // the query is the spelling suggestion of the local catalog
 }
 
 type SearchData struct {
 	Movies []Movie `json:"movies"`
+
+	// responses of the batch search items published in one message
+	Items []BatchItemResponse `json:"items,omitempty"`
 }
 
 type SearchResponse struct {
@@ -46,16 +120,45 @@ type Ratings struct {
 	AudienceScore  int
 }
 
+type CastMember struct {
+	Name       string
+	Characters []string `json:",omitempty"`
+}
+
 type Movie struct {
 	Id      string
 	Title   string
 	Ratings Ratings
+
+	// enriched fields, empty when the provider does not return them
+	Year             int          `json:",omitempty"`
+	MpaaRating       string       `json:",omitempty"`
+	CriticsConsensus string       `json:",omitempty"`
+	Synopsis         string       `json:",omitempty"`
+	Cast             []CastMember `json:",omitempty"`
+	ImdbId           string       `json:",omitempty"` // without "tt" prefix
 }
 
 func NewSearchResponseSuccess(requestId string, movies []Movie) *SearchResponse {
-	return &SearchResponse{Meta: Meta{RequestId: requestId, Status: SUCCESS}, Data: SearchData{movies}}
+	return &SearchResponse{Meta: Meta{RequestId: requestId, Status: SUCCESS}, Data: SearchData{Movies: movies}}
+}
+
+// NewSearchResponseStale creates response with the cached movies served instead of the failed search.
+func NewSearchResponseStale(requestId string, movies []Movie, age time.Duration) *SearchResponse {
+	resp := NewSearchResponseSuccess(requestId, movies)
+	resp.Meta.Stale = true
+	resp.Meta.AgeSeconds = int64(age / time.Second)
+	return resp
 }
 
 func NewSearchResponseError(requestId string, err error) *SearchResponse {
-	return &SearchResponse{Meta: Meta{RequestId: requestId, Status: ERROR, Error: err.Error()}}
+	resp := &SearchResponse{Meta: Meta{RequestId: requestId, Status: ERROR, Error: err.Error()}}
+	var circuitErr *CircuitOpenError
+	switch {
+	case errors.As(err, &circuitErr):
+		resp.Meta.Code = CodeUpstreamUnavailable
+	case errors.Is(err, ErrMovieNotFound):
+		resp.Meta.Code = CodeMovieNotFound
+	}
+	return resp
 }
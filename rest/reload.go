@@ -0,0 +1,261 @@
+package rest
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
+	log "github.com/plar/movie-service/logging"
+)
+
+func (self MovieServerContext) workers() int {
+	if self.Workers == 0 {
+		return totalWorkers
+	}
+	return self.Workers
+}
+
+// newClient returns the client of the context or creates one for the upstream API.
+func (self MovieServerContext) newClient() (Client, error) {
+	if self.Client != nil {
+		return self.Client, nil
+	}
+	httpClient := &http.Client{Timeout: self.upstreamTimeout()}
+	return NewClientWithBaseURL(httpClient, self.RottenTomatoesAPIKey, self.RottenTomatoesURL)
+}
+
+func (self MovieServerContext) upstreamTimeout() time.Duration {
+	if self.UpstreamTimeout == 0 {
+		return DefaultUpstreamTimeout
+	}
+	return self.UpstreamTimeout
+}
+
+// Validate returns all problems found in the context, empty if the context can be used.
+func (self MovieServerContext) Validate() []error {
+	var errs []error
+	if len(self.RottenTomatoesAPIKey) == 0 {
+		errs = append(errs, errors.New("RottenTomatoesAPIKey is required"))
+	}
+
+	if len(self.RottenTomatoesURL) > 0 {
+		if _, err := parseBaseURL(self.RottenTomatoesURL); err != nil {
+			errs = append(errs, fmt.Errorf("RottenTomatoesURL: %s", err))
+		}
+	}
+
+	if self.UpstreamTimeout < 0 {
+		errs = append(errs, fmt.Errorf("UpstreamTimeout cannot be negative, got %s", self.UpstreamTimeout))
+	}
+
+	if workers := self.workers(); workers < 0 || workers > MaxWorkers {
+		errs = append(errs, fmt.Errorf("Workers must be between 1 and %d, got %d", MaxWorkers, workers))
+	}
+
+	if self.PublishOptions != nil {
+		if err := self.PublishOptions.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if self.TLS != nil {
+		if err := self.TLS.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if self.MessageQueueTLS != nil {
+		if err := self.MessageQueueTLS.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if self.AuditOptions != nil {
+		if err := self.AuditOptions.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if self.BreakerOptions != nil {
+		if err := self.BreakerOptions.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if self.CacheOptions != nil {
+		if err := self.CacheOptions.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if self.BatchOptions != nil {
+		if err := self.BatchOptions.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	clients := make(map[string]bool)
+	keys := make(map[string]bool)
+	for i, key := range self.APIKeys {
+		switch {
+		case len(key.Key) == 0:
+			errs = append(errs, fmt.Errorf("APIKeys[%d]: key is required", i))
+		case keys[key.Key]:
+			errs = append(errs, fmt.Errorf("APIKeys[%d]: key of client '%s' is already used", i, key.Client))
+		}
+		switch {
+		case len(key.Client) == 0:
+			errs = append(errs, fmt.Errorf("APIKeys[%d]: client is required", i))
+		case clients[key.Client]:
+			errs = append(errs, fmt.Errorf("APIKeys[%d]: client '%s' is duplicated", i, key.Client))
+		}
+		if key.RateLimit < 0 || key.Burst < 0 || key.DailyQuota < 0 {
+			errs = append(errs, fmt.Errorf("APIKeys[%d]: limits of client '%s' cannot be negative", i, key.Client))
+		}
+		keys[key.Key], clients[key.Client] = true, true
+	}
+
+	return errs
+}
+
+// Reload applies settings which are safe to change at runtime: API key and upstream timeout, number of workers,
+// AMQP URI and TLS certificates, publish and validation options, API keys, listener certificates,
+// circuit breaker thresholds and cache limits. Invalid context is rejected as a whole:
+// certificates and the upstream client are prepared before any setting is changed.
+func (self *movieServer) Reload(ctx MovieServerContext) error {
+	self.reloadLock.Lock()
+	defer self.reloadLock.Unlock()
+
+	self.lifecycleLock.Lock()
+	shuttingDown := self.ShuttingDown()
+	self.lifecycleLock.Unlock()
+	if shuttingDown {
+		return errors.New("service is shutting down, configuration is not reloaded")
+	}
+
+	if errs := ctx.Validate(); len(errs) > 0 {
+		for _, err := range errs {
+			log.Errorf("Invalid configuration: %s", err)
+		}
+		return fmt.Errorf("configuration has %d error(s), keep the current one", len(errs))
+	}
+
+	if len(ctx.ServiceURI) > 0 && ctx.ServiceURI != self.serviceURI {
+		log.Warnf("Service URI cannot be changed at runtime, restart is required, uri=%s", ctx.ServiceURI)
+	}
+	if ctx.JournalFile != self.journalFile {
+		log.Warnf("Journal file cannot be changed at runtime, restart is required, file=%s", ctx.JournalFile)
+	}
+	if ctx.CatalogFile != self.catalogFile {
+		log.Warnf("Catalog file cannot be changed at runtime, restart is required, file=%s", ctx.CatalogFile)
+	}
+	if ctx.AuditOptions != nil && !reflect.DeepEqual(*ctx.AuditOptions, self.auditOptions) {
+		log.Warnf("Audit log settings cannot be changed at runtime, restart is required, file=%s", ctx.AuditOptions.FileName)
+	}
+
+	publishOptions := DefaultPublishOptions()
+	if ctx.PublishOptions != nil {
+		publishOptions = *ctx.PublishOptions
+	}
+
+	batchOptions := DefaultBatchOptions()
+	if ctx.BatchOptions != nil {
+		batchOptions = *ctx.BatchOptions
+	}
+
+	// certificates are read before any setting is changed
+	var messageQueueTLS *tls.Config
+	if ctx.MessageQueueTLS != nil {
+		var err error
+		messageQueueTLS, err = ctx.MessageQueueTLS.Config()
+		if err != nil {
+			log.Errorf("Cannot reload AMQP TLS settings: %s", err)
+			return err
+		}
+	}
+
+	var listenerCerts *serverTLS
+	if tlsEnabled := ctx.TLS != nil && ctx.TLS.Enabled(); tlsEnabled != (self.listenerTLS != nil) {
+		log.Warnf("TLS cannot be turned on or off at runtime, restart is required")
+	} else if tlsEnabled {
+		var err error
+		listenerCerts, err = readServerCerts(*ctx.TLS)
+		if err != nil {
+			log.Errorf("Cannot reload TLS certificates: %s", err)
+			return err
+		}
+	}
+
+	self.settingsLock.RLock()
+	upstreamChanged := ctx.RottenTomatoesAPIKey != self.apiKey || ctx.RottenTomatoesURL != self.upstreamURL ||
+		ctx.upstreamTimeout() != self.upstreamTimeout || ctx.Client != nil
+	self.settingsLock.RUnlock()
+	var client Client
+	if upstreamChanged {
+		var err error
+		client, err = ctx.newClient()
+		if err != nil {
+			log.Errorf("Cannot create upstream client: %s", err)
+			return err
+		}
+	}
+
+	// nothing can fail below, the settings are applied as a whole
+	if listenerCerts != nil {
+		self.listenerTLS.Set(listenerCerts)
+		log.Infof("TLS certificates are reloaded")
+	}
+
+	self.settingsLock.Lock()
+	if upstreamChanged {
+		self.upstream.Switch(client)
+		self.apiKey = ctx.RottenTomatoesAPIKey
+		self.upstreamURL = ctx.RottenTomatoesURL
+		self.upstreamTimeout = ctx.upstreamTimeout()
+		self.breaker.Reset()
+		log.Infof("Upstream client is replaced")
+	}
+
+	breakerOptions := DefaultBreakerOptions()
+	if ctx.BreakerOptions != nil {
+		breakerOptions = *ctx.BreakerOptions
+	}
+	self.breaker.SetOptions(breakerOptions)
+
+	cacheOptions := DefaultCacheOptions()
+	if ctx.CacheOptions != nil {
+		cacheOptions = *ctx.CacheOptions
+	}
+	self.cache.SetOptions(cacheOptions)
+
+	if ctx.MessageQueueURI != self.messageQueueURI {
+		// connection is opened for every publish, the next one uses the new URI
+		self.messageQueueURI = ctx.MessageQueueURI
+		log.Infof("MessageQueue URI is changed, reconnect on the next publish")
+	}
+	self.messageQueueTLS = messageQueueTLS
+	self.reliable = ctx.Reliable
+
+	self.publishOptions = publishOptions
+	self.batch = batchOptions
+	validation := keyRestrictions(ctx.ValidationOptions, ctx.APIKeys)
+	self.validator = NewRequestValidator(validation, publishOptions, self)
+	self.exchanges = validation.AllowedExchanges
+	self.settingsLock.Unlock()
+
+	self.auth.SetKeys(ctx.APIKeys)
+
+	// the context is validated and the size is positive, resize fails only when shutdown has stopped the pool
+	if workers := ctx.workers(); workers != self.pool.Size() {
+		log.Infof("Resize worker pool, %d -> %d workers", self.pool.Size(), workers)
+		if err := self.pool.Resize(workers); err != nil {
+			log.Warnf("Cannot resize worker pool, error=%s", err)
+		}
+	}
+
+	log.Infof("Configuration is reloaded")
+	return nil
+}
@@ -0,0 +1,154 @@
+package rest
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	log "github.com/plar/movie-service/logging"
+	"github.com/plar/movie-service/normalize"
+)
+
+// fieldRewrittenQuery is the log field with the query searched instead of the requested one
+const fieldRewrittenQuery = "rewritten_query"
+
+// Suggester is implemented by the clients which can correct the spelling of the query.
+type Suggester interface {
+	// Suggest returns the corrected queries, the closest first.
+	Suggest(query string) ([]string, error)
+}
+
+// searchResult is the outcome of the search job.
+type searchResult struct {
+	movies   []Movie
+	stale    bool          // the cached results are served instead of the failed search
+	age      time.Duration // of the stale results
+	rewrite  *QueryRewrite // nil - the requested query is searched
+	excluded int           // movies excluded by the filter of the query
+	match    *MovieMatch   // the best match of the lookup
+}
+
+// search normalizes the query and searches the spelling suggestions of the local catalog
+// when there are no results, the movies of the year found in the query go first.
+// The query with the id or the lookup finds the movie instead. The filter of the query is applied to the results.
+func (self *jobFactory) search(query SearchQuery) (searchResult, error) {
+	var result searchResult
+	var err error
+	switch {
+	case len(query.Id) > 0:
+		result, err = self.lookup(query.Source, query.Id)
+	case query.Lookup != nil:
+		result, err = self.match(*query.Lookup)
+	default:
+		result, err = self.rewrite(query)
+	}
+	if err == nil && query.Filter != nil {
+		filtered := query.Filter.Apply(result.movies)
+		result.movies, result.excluded = filtered, len(result.movies)-len(filtered)
+	}
+	return result, err
+}
+
+func (self *jobFactory) rewrite(query SearchQuery) (searchResult, error) {
+	normalized := normalize.Query(query.Text)
+	if len(normalized.Text) == 0 {
+		// nothing is left of the query, it is searched as is
+		return self.searchText(query.Source, query.Text)
+	}
+
+	text, suggested := normalized.Text, false
+	result, err := self.searchText(query.Source, text)
+	if err == nil && len(result.movies) == 0 {
+		for _, suggestion := range self.suggestions(text) {
+			found, err := self.searchText(query.Source, suggestion)
+			if err != nil {
+				// the empty results of the query are published
+				break
+			}
+			if len(found.movies) > 0 {
+				result, text, suggested = found, suggestion, true
+				break
+			}
+		}
+	}
+	if err != nil {
+		return result, err
+	}
+
+	if normalized.Year != 0 {
+		result.movies = yearFirst(result.movies, normalized.Year)
+	}
+	if suggested || normalized.Year != 0 || text != strings.Join(normalize.Terms(query.Text), " ") {
+		result.rewrite = &QueryRewrite{Query: text, Year: normalized.Year, Suggested: suggested}
+	}
+	return result, nil
+}
+
+// searchText searches the text in the source, stale is set when the client serves the cached results.
+func (self *jobFactory) searchText(source, text string) (searchResult, error) {
+	if source == SourceLocal {
+		if self.local == nil {
+			return searchResult{}, errors.New("Local catalog is not configured")
+		}
+		movies, err := self.local.Search(text)
+		return searchResult{movies: movies}, err
+	}
+
+	if searcher, ok := self.client.(StaleSearcher); ok {
+		movies, stale, age, err := searcher.SearchStale(text)
+		return searchResult{movies: movies, stale: stale, age: age}, err
+	}
+	movies, err := self.client.Search(text)
+	return searchResult{movies: movies}, err
+}
+
+// lookup returns the movie with the provider id in the source.
+func (self *jobFactory) lookup(source, id string) (searchResult, error) {
+	client := self.client
+	if source == SourceLocal {
+		if self.local == nil {
+			return searchResult{}, errors.New("Local catalog is not configured")
+		}
+		client = self.local
+	}
+
+	movie, err := getMovie(client, id)
+	if err != nil {
+		return searchResult{}, err
+	}
+	return searchResult{movies: []Movie{*movie}}, nil
+}
+
+// suggestions returns the spelling corrections of the local catalog, nil - no catalog.
+func (self *jobFactory) suggestions(text string) []string {
+	suggester, ok := self.local.(Suggester)
+	if !ok {
+		return nil
+	}
+	suggestions, err := suggester.Suggest(text)
+	if err != nil {
+		log.With(log.Fields{log.FieldQuery: text}).Warnf("Cannot suggest spelling corrections, error=%s", err)
+		return nil
+	}
+	return suggestions
+}
+
+// yearFirst returns the movies of the year before the other ones, the order is kept otherwise.
+// The movies may be shared with the cache, they are copied.
+func yearFirst(movies []Movie, year int) []Movie {
+	if len(movies) == 0 {
+		return movies
+	}
+	sorted := make([]Movie, 0, len(movies))
+	for _, movie := range movies {
+		if movie.Year == year {
+			sorted = append(sorted, movie)
+		}
+	}
+	for _, movie := range movies {
+		if movie.Year != year {
+			sorted = append(sorted, movie)
+		}
+	}
+	return sorted
+}
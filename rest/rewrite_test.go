@@ -0,0 +1,112 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/plar/movie-service/audit"
+	wq "github.com/plar/movie-service/workerqueue"
+)
+
+// queryClient returns the movies of the searched query and records the queries.
+type queryClient struct {
+	movies  map[string][]Movie
+	errs    map[string]error
+	queries []string
+}
+
+func (self *queryClient) Search(query string) ([]Movie, error) {
+	self.queries = append(self.queries, query)
+	return self.movies[query], self.errs[query]
+}
+
+func newRewriteFactory(client Client, local Client) *jobFactory {
+	return NewJobFactoryWithCatalog(nil, client, local, nil, audit.NewNopLogger()).(*jobFactory)
+}
+
+func TestSearchNormalizesQuery(t *testing.T) {
+	movies := []Movie{{Id: "1", Title: "Martian Child", Year: 2007}, {Id: "2", Title: "The Martian", Year: 2015}, {Id: "3", Title: "Martian Land", Year: 2015}}
+	client := &queryClient{movies: map[string][]Movie{"martian": movies}}
+	factory := newRewriteFactory(client, nil)
+
+	result, err := factory.search(SearchQuery{Text: "The Martian (2015)"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"martian"}, client.queries)
+	assert.Equal(t, []Movie{movies[1], movies[2], movies[0]}, result.movies)
+	assert.Equal(t, &QueryRewrite{Query: "martian", Year: 2015}, result.rewrite)
+
+	// the client results are not reordered
+	assert.Equal(t, "Martian Child", movies[0].Title)
+
+	// case and spaces are not reported as rewrite
+	result, err = factory.search(SearchQuery{Text: " Martian"})
+	assert.NoError(t, err)
+	assert.Equal(t, movies, result.movies)
+	assert.Nil(t, result.rewrite)
+
+	result, err = factory.search(SearchQuery{Text: "?!"})
+	assert.NoError(t, err)
+	assert.Equal(t, "?!", client.queries[len(client.queries)-1])
+	assert.Nil(t, result.rewrite)
+}
+
+func TestSearchRetriesSuggestions(t *testing.T) {
+	store, cleanup := openTestCatalog(t)
+	defer cleanup()
+	assert.NoError(t, storeMovies(store, []Movie{martian}))
+
+	client := &queryClient{movies: map[string][]Movie{"martian": {martian}}}
+	factory := newRewriteFactory(client, NewLocalClient(store))
+
+	result, err := factory.search(SearchQuery{Text: "the marshian"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"marshian", "martian"}, client.queries)
+	assert.Equal(t, []Movie{martian}, result.movies)
+	assert.Equal(t, &QueryRewrite{Query: "martian", Suggested: true}, result.rewrite)
+
+	// local searches are corrected too
+	result, err = factory.search(SearchQuery{Text: "Matt Damin", Source: SourceLocal})
+	assert.NoError(t, err)
+	assert.Equal(t, []Movie{martian}, result.movies)
+	assert.Equal(t, &QueryRewrite{Query: "matt damon", Suggested: true}, result.rewrite)
+
+	// failed suggestion keeps the empty results of the query
+	client = &queryClient{errs: map[string]error{"martian": errors.New("timeout")}}
+	factory = newRewriteFactory(client, NewLocalClient(store))
+	result, err = factory.search(SearchQuery{Text: "marshian"})
+	assert.NoError(t, err)
+	assert.Empty(t, result.movies)
+	assert.Nil(t, result.rewrite)
+
+	// no suggestions without the catalog
+	client = &queryClient{}
+	factory = newRewriteFactory(client, nil)
+	result, err = factory.search(SearchQuery{Text: "marshian"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"marshian"}, client.queries)
+}
+
+func TestNewSearchPublishesRewrite(t *testing.T) {
+	workerQueue := make(wq.WorkerQueue, 1)
+	worker, _ := wq.NewWorker(1, workerQueue)
+	worker.Start()
+	defer worker.Stop()
+
+	mqAndClient := &testmqAndClientImpl{}
+	factory := NewJobFactory(mqAndClient, mqAndClient, workerQueue)
+
+	published := make(chan error, 1)
+	factory.NewSearch(context.Background(), Request{RequestId: "RequestId"}, SearchQuery{Text: "Title 2015"}, func(err error) { published <- err })
+	<-published
+	assert.Equal(t, "title", mqAndClient.query)
+	assert.Equal(t, &QueryRewrite{Query: "title", Year: 2015}, mqAndClient.resp.Meta.Rewrite)
+
+	// failed searches report no rewrite
+	mqAndClient.simulateSearchError = errors.New("API is not available")
+	factory.NewSearch(context.Background(), Request{RequestId: "RequestId"}, SearchQuery{Text: "Title 2015"}, func(err error) { published <- err })
+	<-published
+	assert.Nil(t, mqAndClient.resp.Meta.Rewrite)
+}
@@ -1,18 +1,30 @@
 package rest
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"gopkg.in/tylerb/graceful.v1"
 
-	log "github.com/cihub/seelog"
 	"github.com/streadway/amqp"
-
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/plar/movie-service/audit"
+	"github.com/plar/movie-service/catalog"
+	"github.com/plar/movie-service/journal"
+	log "github.com/plar/movie-service/logging"
+	"github.com/plar/movie-service/tracing"
 	wq "github.com/plar/movie-service/workerqueue"
 
 	"github.com/gorilla/mux"
@@ -20,144 +32,410 @@ import (
 
 const (
 	totalWorkers = 10
+	MaxWorkers   = 256
+
+	rottenTomatoesProvider = "rottentomatoes"
+
+	// how long reliable publish waits for the broker confirmation
+	confirmTimeout = 5 * time.Second
 )
 
 type MovieServerContext struct {
 	MessageQueueURI      string
+	MessageQueueTLS      *MessageQueueTLSOptions
+	Reliable             bool // wait for publisher confirms
 	ServiceURI           string
+	TLS                  *TLSOptions
 	RottenTomatoesAPIKey string
+	RottenTomatoesURL    string        // base URL of the API, empty - the real one
+	UpstreamTimeout      time.Duration // limits one request to the API, 0 - DefaultUpstreamTimeout
+	Workers              int
+	PublishOptions       *PublishOptions
+	ValidationOptions    ValidationOptions
+	APIKeys              []APIKey
+	Metrics              *Metrics
+	HealthOptions        *HealthOptions
+	ShutdownOptions      *ShutdownOptions
+	JournalFile          string // accepted jobs are kept in the file until published, empty - no journal
+	CatalogFile          string // movies found by the provider are kept in the file for source=local, empty - no catalog
+	AuditOptions         *audit.Options
+	BreakerOptions       *BreakerOptions
+	CacheOptions         *CacheOptions
+	BatchOptions         *BatchOptions
 	Client               Client
 	JobFactory           JobFactory
+	Dialer               Dialer // nil - DialMessageQueue
 }
 
 type MessageQueue interface {
-	PublishSearchResponse(req *Request, resp *SearchResponse) error
+	// PublishSearchResponse sends the response to the exchange of the request,
+	// trace context of ctx is added to the message headers.
+	PublishSearchResponse(ctx context.Context, req *Request, resp *SearchResponse) error
 }
 
 type MovieServer interface {
 	MessageQueue
 
 	Search(w http.ResponseWriter, r *http.Request)
+	SearchBatch(w http.ResponseWriter, r *http.Request)
+	Lookup(w http.ResponseWriter, r *http.Request)
 	FullCast(w http.ResponseWriter, r *http.Request)
+	Healthz(w http.ResponseWriter, r *http.Request)
+	Readyz(w http.ResponseWriter, r *http.Request)
 
 	Router() *mux.Router
 
 	Start()
 
+	Reload(ctx MovieServerContext) error
+
 	Quit()
 }
 
 type movieServer struct {
+	pendingJobs  int64 // jobs waiting for a free worker
+	shuttingDown int32
+	stopping     int32 // readiness fails before shutdown, requests are still served
+
+	// Start and shutdown agree whether the listener has to be stopped
+	lifecycleLock sync.Mutex
+	listening     bool
+
+	// settings which can be changed by Reload, reloads run one at a time
+	reloadLock      sync.Mutex
+	settingsLock    sync.RWMutex
+	apiKey          string
+	upstreamURL     string
+	upstreamTimeout time.Duration
 	messageQueueURI string
+	messageQueueTLS *tls.Config
+	dial            Dialer
+	reliable        bool
+	publishOptions  PublishOptions
+	batch           BatchOptions
+	validator       RequestValidator
+	exchanges       map[string][]string // allowed exchanges of all clients, they bound the metrics label
+
 	serviceURI      string
+	listenerTLS     *serverTLS // nil - plain HTTP
+	httpServer      *graceful.Server
 	client          Client
+	upstream        *switchableClient
+	breaker         *breakerClient
+	cache           *cachingClient
+	metrics         *Metrics
+	auth            *authenticator
+	health          *healthChecker
 	router          *mux.Router
 	jobFactory      JobFactory
 	workerQueue     wq.WorkerQueue
-	workers         []*wq.Worker
-	quit            chan bool
+	pool            *wq.Pool
+	connections     connectionTracker
+	journal         journal.Journal // nil - no journal
+	journalFile     string
+	catalog         catalog.Catalog // nil - no catalog
+	catalogFile     string
+	audit           audit.Logger
+	auditOptions    audit.Options
+	shutdownOptions ShutdownOptions
+	shutdownOnce    sync.Once
+	done            chan struct{}
 }
 
-func (self *movieServer) PublishSearchResponse(req *Request, resp *SearchResponse) error {
+func (self *movieServer) PublishSearchResponse(ctx context.Context, req *Request, resp *SearchResponse) (err error) {
+	started := time.Now()
+	ctx, span := tracing.Tracer().Start(ctx, spanPublish,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attrRequestId.String(req.RequestId),
+			attrExchange.String(req.ExchangeName),
+			attrRoutingKey.String(req.RoutingKey),
+		))
+	defer func() {
+		self.metrics.ObservePublish(self.exchangeLabel(req.ExchangeName), started, err)
+		tracing.End(span, err)
+	}()
+
+	// errors are logged by the job with the request fields
+	_, publishOptions, _ := self.settings()
+	opts, err := publishOptions.Resolve(req)
+	if err != nil {
+		return fmt.Errorf("Invalid publish options: %s", err)
+	}
+
 	// TBD: Connect during service start...
-	conn, err := amqp.Dial(self.messageQueueURI)
+	conn, err := self.connect()
 	if err != nil {
-		log.Errorf("Cannot connect to MessageQueue, uri=%s, error=%s", self.messageQueueURI, err)
-		return err
+		return fmt.Errorf("Cannot connect to MessageQueue: %s", err)
 	}
-	defer conn.Close()
+	defer self.connections.Close(conn)
 
 	ch, err := conn.Channel()
 	if err != nil {
-		log.Errorf("Cannot open channel, error=%s", err)
-		return err
+		return fmt.Errorf("Cannot open channel: %s", err)
 	}
 	defer ch.Close()
 
-	err = ch.ExchangeDeclare(
-		req.ExchangeName,
-		"topic", // type
-		true,    // durable
-		false,   // auto-deleted
-		false,   // internal
-		false,   // no-wait
-		nil,     // arguments
-	)
+	err = opts.DeclareExchange(ch, req.ExchangeName)
 	if err != nil {
-		log.Errorf("Cannot declare exchange, error=%s", err)
-		return err
+		return fmt.Errorf("Cannot declare exchange: %s", err)
+	}
+
+	var confirms chan amqp.Confirmation
+	if self.isReliable() {
+		err = ch.Confirm(false)
+		if err != nil {
+			return fmt.Errorf("Cannot put channel into confirm mode: %s", err)
+		}
+		confirms = ch.NotifyPublish(make(chan amqp.Confirmation, 1))
 	}
 
 	body, err := json.Marshal(*resp)
 	if err != nil {
-		log.Errorf("Cannot encode response body, error=%s", err)
-		return err
+		return fmt.Errorf("Cannot encode response body: %s", err)
 	}
 
+	publishing := opts.Publishing(req, body, time.Now())
+	publishing.Headers = tracing.InjectAMQP(ctx, publishing.Headers)
 	err = ch.Publish(
 		req.ExchangeName,
 		req.RoutingKey,
 		false,
 		false,
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        body,
-		})
+		publishing)
+
+	if err != nil {
+		return fmt.Errorf("Cannot publish message: %s", err)
+	}
+
+	if confirms != nil {
+		select {
+		case confirm, ok := <-confirms:
+			if !ok || !confirm.Ack {
+				err = errors.New("Message is not confirmed by the broker")
+			}
+		case <-time.After(confirmTimeout):
+			err = fmt.Errorf("No publisher confirm in %s", confirmTimeout)
+		}
+	}
+	return err
+}
 
+func (self *movieServer) isReliable() bool {
+	self.settingsLock.RLock()
+	defer self.settingsLock.RUnlock()
+	return self.reliable
+}
+
+func (self *movieServer) exchangeLabel(exchange string) string {
+	self.settingsLock.RLock()
+	defer self.settingsLock.RUnlock()
+	return exchangeLabel(self.exchanges, exchange)
+}
+
+func (self *movieServer) settings() (string, PublishOptions, RequestValidator) {
+	self.settingsLock.RLock()
+	defer self.settingsLock.RUnlock()
+	return self.messageQueueURI, self.publishOptions, self.validator
+}
+
+// connect opens a new connection to the message queue with the current URI and TLS settings,
+// the connection must be closed by self.connections.Close.
+func (self *movieServer) connect() (Connection, error) {
+	self.settingsLock.RLock()
+	uri, config := self.messageQueueURI, self.messageQueueTLS
+	self.settingsLock.RUnlock()
+
+	conn, err := self.dial(uri, config)
 	if err != nil {
-		log.Errorf("Cannot publish message, error=%s", err)
+		return nil, err
+	}
+	self.connections.Add(conn)
+	return conn, nil
+}
+
+func (self *movieServer) PingMessageQueue() error {
+	conn, err := self.connect()
+	if err != nil {
+		return err
+	}
+	return self.connections.Close(conn)
+}
+
+func (self *movieServer) ProbeExchange(name, kind string) error {
+	_, publishOptions, _ := self.settings()
+	conn, err := self.connect()
+	if err != nil {
+		log.Errorf("Cannot connect to MessageQueue, error=%s", err)
+		return err
+	}
+	defer self.connections.Close(conn)
+
+	ch, err := conn.Channel()
+	if err != nil {
+		log.Errorf("Cannot open channel, error=%s", err)
 		return err
 	}
+	defer ch.Close()
 
-	return nil
+	return ch.ExchangeDeclarePassive(name, kind, publishOptions.ExchangeDurable, publishOptions.ExchangeAutoDelete, false, false, nil)
 }
 
 func (self *movieServer) Search(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	if !self.admit() {
+		writeError(w, requestId(r), NewAPIError(http.StatusServiceUnavailable, CodeShuttingDown, "Service is shutting down"))
+		return
+	}
+	defer self.release()
+
 	vars := mux.Vars(r)
 	query, exists := vars["q"]
 	if !exists || len(query) == 0 {
-		http.Error(w, "Query cannot be empty", http.StatusBadRequest)
+		writeError(w, requestId(r), NewAPIError(http.StatusBadRequest, CodeEmptyQuery, "Query cannot be empty",
+			FieldError{"q", "cannot be empty"}))
+		return
+	}
+
+	source, apiErr := self.searchSource(r)
+	if apiErr != nil {
+		writeError(w, requestId(r), apiErr)
+		return
+	}
+
+	filter, apiErr := ParseSearchFilter(r.URL.Query())
+	if apiErr != nil {
+		writeError(w, requestId(r), apiErr)
 		return
 	}
 
 	// read POST Body
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Cannot read request body", http.StatusInternalServerError)
+		writeError(w, requestId(r), NewAPIError(http.StatusInternalServerError, CodeBodyReadFailed, "Cannot read request body"))
 		return
 	}
 
 	var req Request
 	err = json.Unmarshal(body, &req)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Cannot decode request body: %v", err), http.StatusBadRequest)
+		writeError(w, requestId(r), NewAPIError(http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("Cannot decode request body: %v", err)))
+		return
+	}
+
+	client := self.auth.Client(r)
+	span := trace.SpanFromContext(r.Context())
+	span.SetAttributes(
+		attrRequestId.String(req.RequestId),
+		attrQuery.String(query),
+		attrClient.String(client),
+	)
+	logger := log.With(log.Fields{log.FieldRequestId: req.RequestId, log.FieldClient: client, log.FieldQuery: query})
+	_, _, validator := self.settings()
+	if verr := validator.Validate(client, &req); verr != nil {
+		logger.With(log.Fields{log.FieldExchange: req.ExchangeName, log.FieldRoutingKey: req.RoutingKey}).
+			Warnf("Request rejected, error=%s", verr)
+		self.auditReceived(req, query, client, verr)
+		writeError(w, req.RequestId, verr)
 		return
 	}
 
+	// the job outlives the request, it keeps the span only
+	ctx := trace.ContextWithSpan(context.Background(), span)
+	search := SearchQuery{Text: query, Source: source, Filter: filter}
+	done, err := self.acceptJob(ctx, req, search)
+	if err != nil {
+		self.auditReceived(req, query, client, err)
+		writeError(w, req.RequestId, NewAPIError(http.StatusInternalServerError, CodeJournalFailed, "Cannot store the job"))
+		return
+	}
+	self.auditReceived(req, query, client, nil)
+
 	// create response
 	resp := Response{
 		RequestId:    req.RequestId,
 		Method:       "movies",
 		Query:        query,
+		Source:       source,
 		ExchangeName: req.ExchangeName,
 		RoutingKey:   req.RoutingKey,
 	}
 
 	body, err = json.Marshal(resp)
 	if err != nil {
-		http.Error(w, "Cannot encode response body", http.StatusInternalServerError)
+		writeError(w, req.RequestId, NewAPIError(http.StatusInternalServerError, CodeEncodeFailed, "Cannot encode response body"))
 		return
 	}
 	w.Write(body)
+	logger.Debugf("Search accepted")
 
 	// send query to the workerpool
-	self.jobFactory.NewSearch(req, query)
+	self.dispatch(ctx, req, search, done)
+}
+
+// dispatch sends the accepted query to the worker pool, it returns once a worker takes it.
+// The job is pending since the request is admitted, see admit.
+func (self *movieServer) dispatch(ctx context.Context, req Request, query SearchQuery, done func(error)) {
+	self.audit.Log(audit.Event{Event: audit.EventQueued, RequestId: req.RequestId})
+	self.metrics.JobQueued()
+	self.jobFactory.NewSearch(ctx, req, query, done)
+	self.metrics.JobDispatched()
+}
+
+// auditReceived records the decoded request, err is the reason of rejection.
+func (self *movieServer) auditReceived(req Request, query, client string, err error) {
+	event := audit.Event{Event: audit.EventReceived, RequestId: req.RequestId, Client: client, Query: query, Request: req, Outcome: audit.OutcomeAccepted}
+	if err != nil {
+		event.Outcome, event.Error = audit.OutcomeRejected, err.Error()
+	}
+	self.audit.Log(event)
 }
 
 func (self *movieServer) FullCast(w http.ResponseWriter, r *http.Request) {
-	http.Error(w, "Not implemented", http.StatusNotImplemented)
+	writeError(w, requestId(r), NewAPIError(http.StatusNotImplemented, CodeNotImplemented, "Not implemented"))
+}
+
+func (self *movieServer) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealthResponse(w, self.health.Live())
+}
+
+func (self *movieServer) Readyz(w http.ResponseWriter, r *http.Request) {
+	writeHealthResponse(w, self.health.Ready())
+}
+
+func (self *movieServer) setupHealthChecks(opts HealthOptions) {
+	self.health = &healthChecker{}
+
+	self.health.AddLiveness("workers", func() error {
+		workers := self.pool.Workers()
+		busy := make([]time.Duration, len(workers))
+		for i := range workers {
+			busy[i] = workers[i].BusyFor()
+		}
+		return workerPoolWedged(busy, opts.WorkerStuckTimeout)
+	})
+
+	self.health.AddReadiness("shutdown", func() error {
+		if self.ShuttingDown() || atomic.LoadInt32(&self.stopping) != 0 {
+			return errors.New("shutdown in progress")
+		}
+		return nil
+	})
+	self.health.AddReadiness("amqp", newCachedCheck(opts.MessageQueueProbeInterval, self.PingMessageQueue))
+	if pinger, ok := self.client.(Pinger); ok {
+		self.health.AddReadiness("upstream", newCachedCheck(opts.UpstreamProbeInterval, pinger.Ping))
+	}
+	self.health.AddReadiness("upstream_circuit", self.breaker.Check)
+	self.health.AddReadiness("workers", func() error {
+		idle := len(self.workerQueue)
+		if idle > self.pool.Size() {
+			// stopped workers may leave their inbound in the queue for a moment
+			idle = self.pool.Size()
+		}
+		return workerPoolSaturated(idle, int(atomic.LoadInt64(&self.pendingJobs)), opts.MaxPendingJobs)
+	})
 }
 
 func (self *movieServer) Router() *mux.Router {
@@ -165,40 +443,51 @@ func (self *movieServer) Router() *mux.Router {
 }
 
 func (self *movieServer) Start() {
+	self.lifecycleLock.Lock()
+	if self.ShuttingDown() {
+		self.lifecycleLock.Unlock()
+		self.waitForQuit()
+		return
+	}
+	self.listening = true
+	self.lifecycleLock.Unlock()
+
+	// the snapshot is taken before any request is accepted, the live jobs are never replayed
+	go self.replay(self.unpublished())
+
 	log.Infof("Welcome to Movie Service!")
-	log.Infof("Listen on %s\nPress Ctrl-C to quit...\n", self.serviceURI)
-	graceful.Run(self.serviceURI, 10*time.Second, self.router)
-	self.waitForQuit()
-}
 
-func (self *movieServer) Quit() {
-	log.Infof("Stop workers...")
-	for i := range self.workers {
-		self.workers[i].Stop()
+	var err error
+	if self.listenerTLS != nil {
+		log.Infof("Listen on https://%s\nPress Ctrl-C to quit...\n", self.serviceURI)
+		err = self.httpServer.ListenAndServeTLSConfig(self.listenerTLS.Config())
+	} else {
+		log.Infof("Listen on http://%s\nPress Ctrl-C to quit...\n", self.serviceURI)
+		err = self.httpServer.ListenAndServe()
 	}
 
-	log.Infof("Wait for the workers to quit...")
-	for i := range self.workers {
-		self.workers[i].WaitForFinish()
+	if opErr, ok := err.(*net.OpError); err != nil && (!ok || opErr.Op != "accept") {
+		log.Criticalf("Cannot start service, uri=%s, error=%s", self.serviceURI, err)
+		log.Flush()
+		os.Exit(1)
 	}
+	self.waitForQuit()
+}
 
-	log.Infof("Stop service (10s timeout)...")
-	self.quit <- true
+// Quit runs the shutdown sequence once and returns when it is completed, Start returns after that too.
+func (self *movieServer) Quit() {
+	self.shutdownOnce.Do(self.shutdown)
+	<-self.done
 }
 
 func (self *movieServer) waitForQuit() {
-	for {
-		select {
-		case <-self.quit:
-			log.Infof("Bye-bye")
-			return
-		}
-	}
+	<-self.done
+	log.Infof("Bye-bye")
 }
 
 func NewMovieServer(ctx MovieServerContext) (MovieServer, error) {
-	if len(ctx.RottenTomatoesAPIKey) == 0 {
-		return nil, errors.New("RottenTomatoesAPIKey is required")
+	if errs := ctx.Validate(); len(errs) > 0 {
+		return nil, errs[0]
 	}
 
 	serviceURI := ctx.ServiceURI
@@ -206,38 +495,165 @@ func NewMovieServer(ctx MovieServerContext) (MovieServer, error) {
 		serviceURI = "127.0.0.1:12345"
 	}
 
-	client := ctx.Client
-	if client == nil {
-		client = NewClient(ctx.RottenTomatoesAPIKey)
+	publishOptions := DefaultPublishOptions()
+	if ctx.PublishOptions != nil {
+		publishOptions = *ctx.PublishOptions
+	}
+
+	healthOptions := DefaultHealthOptions()
+	if ctx.HealthOptions != nil {
+		healthOptions = *ctx.HealthOptions
+	}
+
+	shutdownOptions := DefaultShutdownOptions()
+	if ctx.ShutdownOptions != nil {
+		shutdownOptions = *ctx.ShutdownOptions
+	}
+
+	breakerOptions := DefaultBreakerOptions()
+	if ctx.BreakerOptions != nil {
+		breakerOptions = *ctx.BreakerOptions
+	}
+
+	cacheOptions := DefaultCacheOptions()
+	if ctx.CacheOptions != nil {
+		cacheOptions = *ctx.CacheOptions
 	}
 
+	batchOptions := DefaultBatchOptions()
+	if ctx.BatchOptions != nil {
+		batchOptions = *ctx.BatchOptions
+	}
+
+	metrics := ctx.Metrics
+	if metrics == nil {
+		metrics = NewMetrics()
+	}
+
+	client, err := ctx.newClient()
+	if err != nil {
+		return nil, err
+	}
+	upstream := &switchableClient{client: client}
+	breaker := newBreakerClient(rottenTomatoesProvider, NewInstrumentedClient(rottenTomatoesProvider, upstream, metrics), breakerOptions, metrics)
+
 	server := &movieServer{
+		apiKey:          ctx.RottenTomatoesAPIKey,
+		upstreamURL:     ctx.RottenTomatoesURL,
+		upstreamTimeout: ctx.upstreamTimeout(),
 		messageQueueURI: ctx.MessageQueueURI,
+		reliable:        ctx.Reliable,
+		dial:            ctx.Dialer,
+		publishOptions:  publishOptions,
+		batch:           batchOptions,
+		shutdownOptions: shutdownOptions,
+		metrics:         metrics,
+		auth:            newAuthenticator(ctx.APIKeys, metrics),
 		serviceURI:      serviceURI,
-		client:          client,
-		workerQueue:     make(wq.WorkerQueue, totalWorkers),
-		quit:            make(chan bool),
+		journalFile:     ctx.JournalFile,
+		catalogFile:     ctx.CatalogFile,
+		upstream:        upstream,
+		breaker:         breaker,
+		workerQueue:     make(wq.WorkerQueue, MaxWorkers),
+		done:            make(chan struct{}),
+	}
+
+	if server.dial == nil {
+		server.dial = DialMessageQueue
 	}
 
-	server.workers = make([]*wq.Worker, totalWorkers)
-	for i := range server.workers {
+	if ctx.TLS != nil && ctx.TLS.Enabled() {
 		var err error
-		server.workers[i], err = wq.NewWorker(i, server.workerQueue)
+		server.listenerTLS, err = newServerTLS(*ctx.TLS)
 		if err != nil {
 			return nil, err
 		}
-		server.workers[i].Start()
 	}
 
+	if ctx.MessageQueueTLS != nil {
+		var err error
+		server.messageQueueTLS, err = ctx.MessageQueueTLS.Config()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	validation := keyRestrictions(ctx.ValidationOptions, ctx.APIKeys)
+	server.validator = NewRequestValidator(validation, publishOptions, server)
+	server.exchanges = validation.AllowedExchanges
+
+	server.pool, err = wq.NewPool(server.workerQueue, ctx.workers())
+	if err != nil {
+		return nil, err
+	}
+	metrics.RegisterWorkerPool(server.workerQueue, server.pool)
+
+	if ctx.AuditOptions != nil {
+		server.auditOptions = *ctx.AuditOptions
+	}
+	server.audit, err = audit.Open(server.auditOptions)
+	if err != nil {
+		server.pool.Stop()
+		return nil, err
+	}
+
+	// the movies are stored before they get to the cache
+	var cataloged Client = breaker
+	var local Client
+	if len(ctx.CatalogFile) > 0 {
+		server.catalog, err = catalog.Open(ctx.CatalogFile)
+		if err != nil {
+			server.pool.Stop()
+			server.audit.Close()
+			return nil, err
+		}
+		cataloged = &catalogingClient{client: breaker, store: server.catalog}
+		local = NewLocalClient(server.catalog)
+	}
+	server.cache = newCachingClient(cataloged, cacheOptions, metrics)
+	server.client = server.cache
+
 	jobFactory := ctx.JobFactory
 	if jobFactory == nil {
-		jobFactory = NewJobFactory(server, server.client, server.workerQueue)
+		jobFactory = NewJobFactoryWithCatalog(server, server.client, local, server.workerQueue, server.audit)
 	}
 	server.jobFactory = jobFactory
 
+	if len(ctx.JournalFile) > 0 {
+		server.journal, err = journal.Open(ctx.JournalFile)
+		if err != nil {
+			server.pool.Stop()
+			server.audit.Close()
+			if server.catalog != nil {
+				server.catalog.Close()
+			}
+			return nil, err
+		}
+	}
+
+	server.setupHealthChecks(healthOptions)
+
+	instrument := metrics.Middleware(server.auth.KeyClient)
 	server.router = mux.NewRouter()
-	server.router.HandleFunc("/movies", http.HandlerFunc(server.Search)).Methods("POST").Queries("q", "{q}")
-	server.router.HandleFunc("/movie/{id}/full_cast", http.HandlerFunc(server.FullCast)).Methods("POST")
+	server.router.NotFoundHandler = instrument(http.HandlerFunc(notFound))
+	server.router.MethodNotAllowedHandler = instrument(http.HandlerFunc(methodNotAllowed))
+	server.router.Use(instrument)
+	server.router.Handle("/metrics", metrics.Handler()).Methods("GET")
+	server.router.HandleFunc("/healthz", http.HandlerFunc(server.Healthz)).Methods("GET")
+	server.router.HandleFunc("/readyz", http.HandlerFunc(server.Readyz)).Methods("GET")
+
+	// API routes require API key when keys are configured
+	server.router.Handle("/movies/lookup", traced(server.auth.Middleware(http.HandlerFunc(server.Lookup)))).Methods("POST")
+	server.router.Handle("/movies/batch", traced(server.auth.Middleware(http.HandlerFunc(server.SearchBatch)))).Methods("POST")
+	server.router.Handle("/movies", traced(server.auth.Middleware(http.HandlerFunc(server.Search)))).Methods("POST").Queries("q", "{q}")
+	server.router.Handle("/movie/{id}/full_cast", traced(server.auth.Middleware(http.HandlerFunc(server.FullCast)))).Methods("POST")
+
+	server.httpServer = &graceful.Server{
+		Timeout:          shutdownOptions.RequestTimeout,
+		TCPKeepAlive:     3 * time.Minute,
+		NoSignalHandling: true, // signals are handled by the caller, see Quit
+		Server:           &http.Server{Addr: serviceURI, Handler: server.router},
+	}
 
 	return server, nil
 }
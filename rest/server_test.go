@@ -7,10 +7,14 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/plar/movie-service/audit"
 )
 
 type errorReader struct{}
@@ -23,6 +27,15 @@ func (self *errorReader) Close() error {
 	return nil
 }
 
+func assertErrorResponse(t *testing.T, recorder *httptest.ResponseRecorder, expected ErrorResponse) {
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+	resp := ErrorResponse{}
+	err := json.Unmarshal(recorder.Body.Bytes(), &resp)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, resp)
+}
+
 func NewTestMovieServerContext() MovieServerContext {
 	return MovieServerContext{
 		JobFactory:           NewTestJobFactory(),
@@ -44,6 +57,14 @@ func TestCreateMovieServer(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestCreateMovieServerInvalidPublishOptions(t *testing.T) {
+	ctx := NewTestMovieServerContext()
+	ctx.PublishOptions = &PublishOptions{ExchangeType: "x-custom"}
+	server, err := NewMovieServer(ctx)
+	assert.Nil(t, server)
+	assert.EqualError(t, err, "Unknown exchange type 'x-custom'")
+}
+
 func TestMovieServerSearch(t *testing.T) {
 	ctx := NewTestMovieServerContext()
 	server, _ := NewMovieServer(ctx)
@@ -71,6 +92,7 @@ func TestMovieServerSearch(t *testing.T) {
 		RequestId:    "unique-request-id",
 		Method:       "movies",
 		Query:        "martian",
+		Source:       SourceUpstream,
 		ExchangeName: "ExchangeName",
 		RoutingKey:   "RoutingKey",
 	}
@@ -96,8 +118,12 @@ func TestMovieServerSearchEmptyQuery(t *testing.T) {
 	server.Router().ServeHTTP(recorder, req)
 	assert.Equal(t, http.StatusBadRequest, recorder.Code)
 
-	body, _ := ioutil.ReadAll(recorder.Body)
-	assert.Equal(t, "Query cannot be empty\n", string(body))
+	assertErrorResponse(t, recorder, ErrorResponse{Meta: Meta{
+		Status: ERROR,
+		Code:   CodeEmptyQuery,
+		Error:  "Query cannot be empty",
+		Errors: []FieldError{{"q", "cannot be empty"}},
+	}})
 }
 
 func TestMovieServerSearchEmptyBody(t *testing.T) {
@@ -116,8 +142,11 @@ func TestMovieServerSearchEmptyBody(t *testing.T) {
 	server.Router().ServeHTTP(recorder, req)
 	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
 
-	body, _ := ioutil.ReadAll(recorder.Body)
-	assert.Equal(t, "Cannot read request body\n", string(body))
+	assertErrorResponse(t, recorder, ErrorResponse{Meta: Meta{
+		Status: ERROR,
+		Code:   CodeBodyReadFailed,
+		Error:  "Cannot read request body",
+	}})
 }
 
 func TestMovieServerSearchWrongBody(t *testing.T) {
@@ -135,6 +164,137 @@ func TestMovieServerSearchWrongBody(t *testing.T) {
 	server.Router().ServeHTTP(recorder, req)
 	assert.Equal(t, http.StatusBadRequest, recorder.Code)
 
-	body, _ := ioutil.ReadAll(recorder.Body)
-	assert.Equal(t, "Cannot decode request body: unexpected end of JSON input\n", string(body))
+	assertErrorResponse(t, recorder, ErrorResponse{Meta: Meta{
+		Status: ERROR,
+		Code:   CodeInvalidBody,
+		Error:  "Cannot decode request body: unexpected end of JSON input",
+	}})
+}
+
+func TestMovieServerSearchInvalidExchange(t *testing.T) {
+	ctx := NewTestMovieServerContext()
+	server, _ := NewMovieServer(ctx)
+	recorder := httptest.NewRecorder()
+
+	reqBody, err := json.Marshal(Request{RequestId: "unique-request-id", RoutingKey: "RoutingKey"})
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("POST", "http://movie-search.devel/movies?q=martian", bytes.NewReader(reqBody))
+	assert.NoError(t, err)
+
+	server.Router().ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+
+	assertErrorResponse(t, recorder, ErrorResponse{Meta: Meta{
+		RequestId: "unique-request-id",
+		Status:    ERROR,
+		Code:      CodeInvalidRequest,
+		Error:     "Invalid request",
+		Errors:    []FieldError{{"exchange_name", "cannot be empty"}},
+	}})
+}
+
+func TestMovieServerFullCastNotImplemented(t *testing.T) {
+	ctx := NewTestMovieServerContext()
+	server, _ := NewMovieServer(ctx)
+	recorder := httptest.NewRecorder()
+
+	req, err := http.NewRequest("POST", "http://movie-search.devel/movie/771380589/full_cast", strings.NewReader("{}"))
+	assert.NoError(t, err)
+	req.Header.Set(RequestIdHeader, "unique-request-id")
+
+	server.Router().ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusNotImplemented, recorder.Code)
+	assertErrorResponse(t, recorder, ErrorResponse{Meta: Meta{
+		RequestId: "unique-request-id",
+		Status:    ERROR,
+		Code:      CodeNotImplemented,
+		Error:     "Not implemented",
+	}})
+}
+
+func TestMovieServerNotFound(t *testing.T) {
+	ctx := NewTestMovieServerContext()
+	server, _ := NewMovieServer(ctx)
+	recorder := httptest.NewRecorder()
+
+	req, err := http.NewRequest("POST", "http://movie-search.devel/series", strings.NewReader("{}"))
+	assert.NoError(t, err)
+
+	server.Router().ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assertErrorResponse(t, recorder, ErrorResponse{Meta: Meta{Status: ERROR, Code: CodeNotFound, Error: "Not found"}})
+}
+
+func TestMovieServerMethodNotAllowed(t *testing.T) {
+	ctx := NewTestMovieServerContext()
+	server, _ := NewMovieServer(ctx)
+	recorder := httptest.NewRecorder()
+
+	req, err := http.NewRequest("GET", "http://movie-search.devel/movie/771380589/full_cast", nil)
+	assert.NoError(t, err)
+
+	server.Router().ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+	assertErrorResponse(t, recorder, ErrorResponse{Meta: Meta{Status: ERROR, Code: CodeMethodNotAllowed, Error: "Method not allowed"}})
+}
+
+func TestMovieServerSearchAudit(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "movie-service-audit")
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "audit.jsonl")
+
+	ctx := NewTestMovieServerContext()
+	ctx.AuditOptions = &audit.Options{
+		FileName:  fileName,
+		Redaction: audit.RedactionPolicy{Fields: []string{"request.headers"}, Mode: audit.RedactMask},
+	}
+	server, err := NewMovieServer(ctx)
+	assert.NoError(t, err)
+
+	reqBody, _ := json.Marshal(Request{RequestId: "accepted", ExchangeName: "movies", RoutingKey: "search", Headers: map[string]string{"token": "secret"}})
+	req, _ := http.NewRequest("POST", "http://movie-search.devel/movies?q=martian", bytes.NewReader(reqBody))
+	server.Router().ServeHTTP(httptest.NewRecorder(), req)
+
+	reqBody, _ = json.Marshal(Request{RequestId: "rejected", RoutingKey: "search"})
+	req, _ = http.NewRequest("POST", "http://movie-search.devel/movies?q=alien", bytes.NewReader(reqBody))
+	server.Router().ServeHTTP(httptest.NewRecorder(), req)
+	server.Quit()
+
+	data, err := ioutil.ReadFile(fileName)
+	assert.NoError(t, err)
+	assert.False(t, strings.Contains(string(data), "secret"))
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if !assert.Equal(t, 3, len(lines)) {
+		return
+	}
+
+	var events [3]map[string]interface{}
+	for i := range lines {
+		assert.NoError(t, json.Unmarshal([]byte(lines[i]), &events[i]))
+	}
+
+	assert.Equal(t, "received", events[0]["event"])
+	assert.Equal(t, "accepted", events[0]["request_id"])
+	assert.Equal(t, "anonymous", events[0]["client"])
+	assert.Equal(t, "martian", events[0]["query"])
+	assert.Equal(t, "accepted", events[0]["outcome"])
+	assert.Equal(t, "REDACTED", events[0]["request"].(map[string]interface{})["headers"])
+
+	assert.Equal(t, "queued", events[1]["event"])
+	assert.Equal(t, "accepted", events[1]["request_id"])
+
+	assert.Equal(t, "received", events[2]["event"])
+	assert.Equal(t, "rejected", events[2]["request_id"])
+	assert.Equal(t, "rejected", events[2]["outcome"])
+	assert.NotEmpty(t, events[2]["error"])
+}
+
+func TestCreateMovieServerInvalidAuditOptions(t *testing.T) {
+	ctx := NewTestMovieServerContext()
+	ctx.AuditOptions = &audit.Options{FileName: "audit.jsonl", MaxBackups: -1}
+	server, err := NewMovieServer(ctx)
+	assert.Nil(t, server)
+	assert.EqualError(t, err, "Audit file max backups cannot be negative, got -1")
 }
@@ -0,0 +1,173 @@
+package rest
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/plar/movie-service/logging"
+)
+
+// ShutdownOptions limits every phase of the shutdown.
+type ShutdownOptions struct {
+	// Readiness fails this long before the listener stops, so load balancers stop routing requests first
+	PreStopDelay time.Duration
+
+	// In-flight HTTP requests, they are cut off when the timeout expires
+	RequestTimeout time.Duration
+
+	// Jobs waiting for a free worker, they are dropped when the timeout expires
+	DrainTimeout time.Duration
+
+	// Running jobs with their AMQP publishes and confirms, connections are closed when the timeout expires
+	PublishTimeout time.Duration
+}
+
+func DefaultShutdownOptions() ShutdownOptions {
+	return ShutdownOptions{
+		RequestTimeout: 10 * time.Second,
+		DrainTimeout:   10 * time.Second,
+		PublishTimeout: 10 * time.Second,
+	}
+}
+
+// connectionTracker keeps open AMQP connections, so shutdown can close the stuck ones.
+type connectionTracker struct {
+	sync.Mutex
+	connections map[Connection]bool
+}
+
+func (self *connectionTracker) Add(conn Connection) {
+	self.Lock()
+	defer self.Unlock()
+	if self.connections == nil {
+		self.connections = make(map[Connection]bool)
+	}
+	self.connections[conn] = true
+}
+
+// Close closes the connection and forgets it.
+func (self *connectionTracker) Close(conn Connection) error {
+	self.Lock()
+	delete(self.connections, conn)
+	self.Unlock()
+	return conn.Close()
+}
+
+// CloseAll closes all open connections and returns their number.
+func (self *connectionTracker) CloseAll() int {
+	self.Lock()
+	connections := self.connections
+	self.connections = nil
+	self.Unlock()
+
+	for conn := range connections {
+		if err := conn.Close(); err != nil {
+			log.Errorf("Cannot close MessageQueue connection, error=%s", err)
+		}
+	}
+	return len(connections)
+}
+
+// waitFor returns false when done is not closed within the timeout.
+func waitFor(done <-chan struct{}, timeout time.Duration) bool {
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (self *movieServer) ShuttingDown() bool {
+	return atomic.LoadInt32(&self.shuttingDown) != 0
+}
+
+// admit counts the request as a pending job unless the service is shutting down. The job is counted
+// before the check, so the drain of the shutdown either sees it or the request is rejected.
+// The admitted request calls release once its job is dispatched or rejected.
+func (self *movieServer) admit() bool {
+	atomic.AddInt64(&self.pendingJobs, 1)
+	if self.ShuttingDown() {
+		self.release()
+		return false
+	}
+	return true
+}
+
+func (self *movieServer) release() {
+	atomic.AddInt64(&self.pendingJobs, -1)
+}
+
+// shutdown stops the server phase by phase, every phase is limited by its own timeout:
+// stop accepting requests, drain pending jobs, wait for running jobs and publishes, close connections.
+func (self *movieServer) shutdown() {
+	started := time.Now()
+	opts := self.shutdownOptions
+
+	if opts.PreStopDelay > 0 {
+		log.Infof("Shutdown 1/4: fail readiness, stop accepting requests in %s", opts.PreStopDelay)
+		atomic.StoreInt32(&self.stopping, 1)
+		time.Sleep(opts.PreStopDelay)
+	}
+
+	log.Infof("Shutdown 1/4: stop accepting requests, timeout=%s", opts.RequestTimeout)
+	self.lifecycleLock.Lock()
+	atomic.StoreInt32(&self.shuttingDown, 1)
+	listening := self.listening
+	if listening {
+		self.httpServer.Stop(opts.RequestTimeout)
+	}
+	self.lifecycleLock.Unlock()
+
+	log.Infof("Shutdown 2/4: drain pending jobs, pending=%d, timeout=%s", atomic.LoadInt64(&self.pendingJobs), opts.DrainTimeout)
+	drained := make(chan struct{})
+	go func() {
+		for atomic.LoadInt64(&self.pendingJobs) > 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		close(drained)
+	}()
+	if !waitFor(drained, opts.DrainTimeout) {
+		log.Warnf("Pending jobs are not drained in %s, drop them, journaled ones are replayed on the next start, pending=%d",
+			opts.DrainTimeout, atomic.LoadInt64(&self.pendingJobs))
+	}
+	// the jobs which are still waiting for a worker give up
+	self.jobFactory.Close()
+
+	log.Infof("Shutdown 3/4: wait for running jobs and publishes, timeout=%s", opts.PublishTimeout)
+	stopped := make(chan struct{})
+	go func() {
+		self.pool.Stop()
+		close(stopped)
+	}()
+	if !waitFor(stopped, opts.PublishTimeout) {
+		log.Warnf("Running jobs are not finished in %s, abort their publishes", opts.PublishTimeout)
+	}
+
+	log.Infof("Shutdown 4/4: close MessageQueue connections, the journal, the catalog and the audit log")
+	self.cache.Close()
+	if closed := self.connections.CloseAll(); closed > 0 {
+		log.Warnf("Closed %d MessageQueue connection(s) of unfinished publishes", closed)
+	}
+	if self.journal != nil {
+		if err := self.journal.Close(); err != nil {
+			log.Errorf("Cannot close the journal, error=%s", err)
+		}
+	}
+	if self.catalog != nil {
+		if err := self.catalog.Close(); err != nil {
+			log.Errorf("Cannot close the catalog, error=%s", err)
+		}
+	}
+	if err := self.audit.Close(); err != nil {
+		log.Errorf("Cannot close the audit log, error=%s", err)
+	}
+
+	if listening && !waitFor(self.httpServer.StopChan(), opts.RequestTimeout) {
+		log.Warnf("HTTP server is not stopped in %s", opts.RequestTimeout)
+	}
+
+	log.Infof("Shutdown completed in %s", time.Since(started))
+	close(self.done)
+}
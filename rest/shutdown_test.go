@@ -0,0 +1,186 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	wq "github.com/plar/movie-service/workerqueue"
+)
+
+type blockingMessageQueue struct {
+	published int32
+	release   chan struct{}
+}
+
+func (self *blockingMessageQueue) PublishSearchResponse(ctx context.Context, req *Request, resp *SearchResponse) error {
+	<-self.release
+	atomic.AddInt32(&self.published, 1)
+	return nil
+}
+
+func newTestShutdownServer(t *testing.T) *movieServer {
+	ctx := NewTestMovieServerContext()
+	ctx.ServiceURI = "127.0.0.1:0"
+	ctx.Workers = 1
+	ctx.ShutdownOptions = &ShutdownOptions{
+		RequestTimeout: time.Second,
+		DrainTimeout:   50 * time.Millisecond,
+		PublishTimeout: 50 * time.Millisecond,
+	}
+	server, err := NewMovieServer(ctx)
+	assert.NoError(t, err)
+	return server.(*movieServer)
+}
+
+func TestJobFactoryClose(t *testing.T) {
+	mq := &blockingMessageQueue{release: make(chan struct{})}
+	factory := NewJobFactory(mq, &testmqAndClientImpl{}, make(wq.WorkerQueue))
+
+	done := make(chan struct{})
+	go func() {
+		// no workers, the job waits until the factory is closed
+		factory.NewSearch(context.Background(), Request{RequestId: "RequestId"}, SearchQuery{Text: "martian"}, nil)
+		close(done)
+	}()
+
+	factory.Close()
+	factory.Close()
+	assert.True(t, waitFor(done, time.Second))
+
+	// new jobs are dropped right away
+	factory.NewSearch(context.Background(), Request{RequestId: "RequestId"}, SearchQuery{Text: "martian"}, nil)
+	assert.Equal(t, int32(0), mq.published)
+}
+
+func TestJobFactoryCloseWithFreeWorker(t *testing.T) {
+	mq := &blockingMessageQueue{release: make(chan struct{})}
+	close(mq.release)
+	workerQueue := make(wq.WorkerQueue, 1)
+	factory := NewJobFactory(mq, &testmqAndClientImpl{}, workerQueue)
+
+	// the closed factory does not hand the job to the free worker
+	worker := make(wq.Inbound)
+	workerQueue <- worker
+	factory.Close()
+	for i := 0; i < 10; i++ {
+		factory.NewSearch(context.Background(), Request{RequestId: "RequestId"}, SearchQuery{Text: "martian"}, nil)
+	}
+	assert.Equal(t, 1, len(workerQueue))
+
+	// the worker which stopped after it got into the queue does not block the job
+	factory = NewJobFactory(mq, &testmqAndClientImpl{}, workerQueue)
+	done := make(chan struct{})
+	go func() {
+		factory.NewSearch(context.Background(), Request{RequestId: "RequestId"}, SearchQuery{Text: "martian"}, nil)
+		close(done)
+	}()
+	for len(workerQueue) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	factory.Close()
+	assert.True(t, waitFor(done, time.Second))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&mq.published))
+}
+
+func TestMovieServerAdmit(t *testing.T) {
+	server := newTestShutdownServer(t)
+	defer server.pool.Stop()
+
+	assert.True(t, server.admit())
+	assert.Equal(t, int64(1), atomic.LoadInt64(&server.pendingJobs))
+
+	// the admitted request is drained, the new ones are rejected
+	atomic.StoreInt32(&server.shuttingDown, 1)
+	assert.False(t, server.admit())
+	assert.Equal(t, int64(1), atomic.LoadInt64(&server.pendingJobs))
+
+	server.release()
+	assert.Equal(t, int64(0), atomic.LoadInt64(&server.pendingJobs))
+}
+
+func TestMovieServerPreStopDelay(t *testing.T) {
+	server := newTestShutdownServer(t)
+	server.shutdownOptions.PreStopDelay = 200 * time.Millisecond
+
+	quit := make(chan struct{})
+	go func() {
+		server.Quit()
+		close(quit)
+	}()
+
+	// readiness fails first, requests are still admitted until the delay expires
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&server.stopping) != 0
+	}, time.Second, 5*time.Millisecond)
+	resp := server.health.Ready()
+	assert.Equal(t, HealthCheckResult{Status: ERROR, Error: "shutdown in progress"}, resp.Checks["shutdown"])
+	assert.False(t, server.ShuttingDown())
+	assert.True(t, server.admit())
+	server.release()
+
+	assert.True(t, waitFor(quit, time.Second))
+	assert.True(t, server.ShuttingDown())
+	assert.False(t, server.admit())
+}
+
+func TestMovieServerQuit(t *testing.T) {
+	server := newTestShutdownServer(t)
+
+	started := make(chan struct{})
+	go func() {
+		server.Start()
+		close(started)
+	}()
+
+	server.Quit()
+	assert.True(t, waitFor(started, time.Second), "Start must return after Quit")
+	assert.True(t, server.ShuttingDown())
+	assert.Equal(t, 0, server.pool.Size())
+
+	// the second call does not block
+	server.Quit()
+
+	// new requests are rejected
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "http://movie-search.devel/movies?q=martian", nil)
+	server.Router().ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assertErrorResponse(t, recorder, ErrorResponse{Meta: Meta{Status: ERROR, Code: CodeShuttingDown, Error: "Service is shutting down"}})
+}
+
+func TestMovieServerQuitDrainTimeout(t *testing.T) {
+	server := newTestShutdownServer(t)
+	mq := &blockingMessageQueue{release: make(chan struct{})}
+	defer close(mq.release)
+	server.jobFactory = NewJobFactory(mq, &testmqAndClientImpl{}, server.workerQueue)
+
+	// the only worker is busy with the first job, the second one waits for it
+	server.jobFactory.NewSearch(context.Background(), Request{RequestId: "first"}, SearchQuery{Text: "martian"}, nil)
+	pending := make(chan struct{})
+	go func() {
+		atomic.AddInt64(&server.pendingJobs, 1)
+		server.jobFactory.NewSearch(context.Background(), Request{RequestId: "second"}, SearchQuery{Text: "martian"}, nil)
+		atomic.AddInt64(&server.pendingJobs, -1)
+		close(pending)
+	}()
+
+	started := time.Now()
+	server.Quit()
+	assert.True(t, time.Since(started) < time.Second)
+
+	// the pending job is dropped, the running one is not finished
+	assert.True(t, waitFor(pending, time.Second))
+	assert.Equal(t, int64(0), atomic.LoadInt64(&server.pendingJobs))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&mq.published))
+}
+
+func TestConnectionTrackerCloseAll(t *testing.T) {
+	tracker := connectionTracker{}
+	assert.Equal(t, 0, tracker.CloseAll())
+}
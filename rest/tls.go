@@ -0,0 +1,191 @@
+package rest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+const (
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional" // verify client certificate when the client sends one
+	ClientAuthRequire  = "require"
+)
+
+// TLSOptions configures HTTPS listener, the listener uses plain HTTP when CertFile is empty.
+type TLSOptions struct {
+	CertFile string
+	KeyFile  string
+
+	// CA to verify client certificates (mutual TLS) and client auth mode
+	ClientCAFile string
+	ClientAuth   string
+}
+
+func (self TLSOptions) Enabled() bool {
+	return len(self.CertFile) > 0 || len(self.KeyFile) > 0
+}
+
+func (self TLSOptions) Validate() error {
+	if !self.Enabled() {
+		return nil
+	}
+	if len(self.CertFile) == 0 || len(self.KeyFile) == 0 {
+		return errors.New("TLS requires both certificate and key files")
+	}
+
+	switch self.ClientAuth {
+	case "", ClientAuthNone:
+	case ClientAuthOptional, ClientAuthRequire:
+		if len(self.ClientCAFile) == 0 {
+			return fmt.Errorf("Client auth '%s' requires client CA file", self.ClientAuth)
+		}
+	default:
+		return fmt.Errorf("Unknown client auth '%s'", self.ClientAuth)
+	}
+	return nil
+}
+
+func (self TLSOptions) clientAuthType() tls.ClientAuthType {
+	switch self.ClientAuth {
+	case ClientAuthOptional:
+		return tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		return tls.RequireAndVerifyClientCert
+	}
+	return tls.NoClientCert
+}
+
+// MessageQueueTLSOptions configures amqps:// connections, empty CAFile - system roots are used.
+type MessageQueueTLSOptions struct {
+	CAFile string
+
+	// Client certificate, optional
+	CertFile string
+	KeyFile  string
+
+	// Overrides host name of the URI to verify the broker certificate
+	ServerName string
+}
+
+func (self MessageQueueTLSOptions) Validate() error {
+	if (len(self.CertFile) == 0) != (len(self.KeyFile) == 0) {
+		return errors.New("AMQP client certificate requires both certificate and key files")
+	}
+	return nil
+}
+
+// Config reads certificates and returns TLS config for amqp.DialTLS.
+func (self MessageQueueTLSOptions) Config() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: self.ServerName,
+	}
+
+	if len(self.CAFile) > 0 {
+		pool, err := loadCertPool(self.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	if len(self.CertFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(self.CertFile, self.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot load AMQP client certificate: %s", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func loadCertPool(fileName string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read CA file: %s", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("No certificates found in %s", fileName)
+	}
+	return pool, nil
+}
+
+// serverTLS keeps the listener certificate and client CAs, they can be reloaded without restart.
+type serverTLS struct {
+	sync.RWMutex
+	options   TLSOptions
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+func newServerTLS(options TLSOptions) (*serverTLS, error) {
+	server := &serverTLS{}
+	if err := server.Load(options); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// Load reads certificates of the options, the current ones are kept on error.
+func (self *serverTLS) Load(options TLSOptions) error {
+	certs, err := readServerCerts(options)
+	if err != nil {
+		return err
+	}
+	self.Set(certs)
+	return nil
+}
+
+// Set replaces the certificates with the ones read by readServerCerts.
+func (self *serverTLS) Set(certs *serverTLS) {
+	self.Lock()
+	defer self.Unlock()
+	self.options, self.cert, self.clientCAs = certs.options, certs.cert, certs.clientCAs
+}
+
+// readServerCerts reads certificates of the options without applying them to any listener.
+func readServerCerts(options TLSOptions) (*serverTLS, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot load TLS certificate: %s", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if len(options.ClientCAFile) > 0 {
+		clientCAs, err = loadCertPool(options.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &serverTLS{options: options, cert: &cert, clientCAs: clientCAs}, nil
+}
+
+// Config returns listener config which picks up reloaded certificates for every new connection.
+func (self *serverTLS) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: self.configForClient,
+	}
+}
+
+func (self *serverTLS) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	self.RLock()
+	defer self.RUnlock()
+
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*self.cert},
+		ClientCAs:    self.clientCAs,
+		ClientAuth:   self.options.clientAuthType(),
+	}, nil
+}
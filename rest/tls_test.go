@@ -0,0 +1,225 @@
+package rest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testPKI generates CA and certificates signed by it into a temp directory.
+type testPKI struct {
+	t      *testing.T
+	dir    string
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	serial int64
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	dir, err := ioutil.TempDir("", "movie-service-tls")
+	assert.NoError(t, err)
+
+	pki := &testPKI{t: t, dir: dir}
+	pki.ca, pki.caKey = pki.issue("ca", "Test CA", nil, nil)
+	return pki
+}
+
+func (self *testPKI) Close() {
+	os.RemoveAll(self.dir)
+}
+
+func (self *testPKI) CAFile() string {
+	return filepath.Join(self.dir, "ca.crt")
+}
+
+// Issue writes <name>.crt and <name>.key signed by the CA and returns file names.
+func (self *testPKI) Issue(name, commonName string, usage x509.ExtKeyUsage) (string, string) {
+	self.issue(name, commonName, self.ca, &usage)
+	return filepath.Join(self.dir, name+".crt"), filepath.Join(self.dir, name+".key")
+}
+
+func (self *testPKI) issue(name, commonName string, parent *x509.Certificate, usage *x509.ExtKeyUsage) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(self.t, err)
+
+	self.serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(self.serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{*usage}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		signer, signerKey = parent, self.caKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	assert.NoError(self.t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(self.t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	assert.NoError(self.t, ioutil.WriteFile(filepath.Join(self.dir, name+".crt"), certPEM, 0600))
+	assert.NoError(self.t, ioutil.WriteFile(filepath.Join(self.dir, name+".key"), keyPEM, 0600))
+
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(self.t, err)
+	return cert, key
+}
+
+// serveTLS starts HTTPS server on a random port and returns its address.
+func serveTLS(t *testing.T, config *tls.Config) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	go http.Serve(tls.NewListener(listener, config), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			w.Header().Set(ClientIdHeader, r.TLS.PeerCertificates[0].Subject.CommonName)
+		}
+	}))
+	return listener.Addr().String(), func() { listener.Close() }
+}
+
+func tlsGet(addr string, config *tls.Config) (*http.Response, error) {
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: config, DisableKeepAlives: true}}
+	return client.Get("https://" + addr + "/")
+}
+
+func TestTLSOptionsValidate(t *testing.T) {
+	assert.NoError(t, TLSOptions{}.Validate())
+	assert.False(t, TLSOptions{}.Enabled())
+	assert.NoError(t, TLSOptions{CertFile: "a.crt", KeyFile: "a.key", ClientAuth: ClientAuthNone}.Validate())
+
+	assert.EqualError(t, TLSOptions{CertFile: "a.crt"}.Validate(), "TLS requires both certificate and key files")
+	assert.EqualError(t, TLSOptions{CertFile: "a.crt", KeyFile: "a.key", ClientAuth: ClientAuthRequire}.Validate(),
+		"Client auth 'require' requires client CA file")
+	assert.EqualError(t, TLSOptions{CertFile: "a.crt", KeyFile: "a.key", ClientAuth: "always"}.Validate(),
+		"Unknown client auth 'always'")
+
+	assert.NoError(t, MessageQueueTLSOptions{CAFile: "ca.crt"}.Validate())
+	assert.EqualError(t, MessageQueueTLSOptions{KeyFile: "a.key"}.Validate(),
+		"AMQP client certificate requires both certificate and key files")
+}
+
+func TestServerTLSMutual(t *testing.T) {
+	pki := newTestPKI(t)
+	defer pki.Close()
+
+	certFile, keyFile := pki.Issue("server", "movie-service", x509.ExtKeyUsageServerAuth)
+	clientCertFile, clientKeyFile := pki.Issue("client", "billing", x509.ExtKeyUsageClientAuth)
+
+	listenerTLS, err := newServerTLS(TLSOptions{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: pki.CAFile(),
+		ClientAuth:   ClientAuthRequire,
+	})
+	assert.NoError(t, err)
+
+	addr, stop := serveTLS(t, listenerTLS.Config())
+	defer stop()
+
+	clientConfig, err := MessageQueueTLSOptions{CAFile: pki.CAFile(), CertFile: clientCertFile, KeyFile: clientKeyFile}.Config()
+	assert.NoError(t, err)
+
+	resp, err := tlsGet(addr, clientConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "billing", resp.Header.Get(ClientIdHeader))
+	assert.Equal(t, "movie-service", resp.TLS.PeerCertificates[0].Subject.CommonName)
+
+	// client without certificate is rejected
+	noCertConfig, _ := MessageQueueTLSOptions{CAFile: pki.CAFile()}.Config()
+	_, err = tlsGet(addr, noCertConfig)
+	assert.Error(t, err)
+
+	// new connections use the reloaded certificate, client certificate becomes optional
+	certFile, keyFile = pki.Issue("server2", "movie-service-2", x509.ExtKeyUsageServerAuth)
+	assert.NoError(t, listenerTLS.Load(TLSOptions{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: pki.CAFile(),
+		ClientAuth:   ClientAuthOptional,
+	}))
+
+	resp, err = tlsGet(addr, noCertConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, "movie-service-2", resp.TLS.PeerCertificates[0].Subject.CommonName)
+
+	// invalid files keep the current certificate
+	assert.Error(t, listenerTLS.Load(TLSOptions{CertFile: "not-existing.crt", KeyFile: keyFile}))
+	resp, err = tlsGet(addr, noCertConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, "movie-service-2", resp.TLS.PeerCertificates[0].Subject.CommonName)
+}
+
+func TestMessageQueueTLSOptionsConfig(t *testing.T) {
+	pki := newTestPKI(t)
+	defer pki.Close()
+
+	config, err := MessageQueueTLSOptions{ServerName: "rabbitmq"}.Config()
+	assert.NoError(t, err)
+	assert.Nil(t, config.RootCAs)
+	assert.Equal(t, "rabbitmq", config.ServerName)
+
+	_, err = MessageQueueTLSOptions{CAFile: "not-existing.crt"}.Config()
+	assert.Error(t, err)
+
+	_, keyFile := pki.Issue("client", "movie-service", x509.ExtKeyUsageClientAuth)
+	_, err = MessageQueueTLSOptions{CAFile: keyFile}.Config()
+	assert.EqualError(t, err, "No certificates found in "+keyFile)
+}
+
+func TestMovieServerTLSReload(t *testing.T) {
+	pki := newTestPKI(t)
+	defer pki.Close()
+	certFile, keyFile := pki.Issue("server", "movie-service", x509.ExtKeyUsageServerAuth)
+
+	ctx := NewTestMovieServerContext()
+	ctx.TLS = &TLSOptions{CertFile: certFile, KeyFile: "not-existing.key"}
+	_, err := NewMovieServer(ctx)
+	assert.Error(t, err)
+
+	ctx.TLS = &TLSOptions{CertFile: certFile, KeyFile: keyFile}
+	ctx.MessageQueueTLS = &MessageQueueTLSOptions{CAFile: pki.CAFile()}
+	server, err := NewMovieServer(ctx)
+	assert.NoError(t, err)
+	impl := server.(*movieServer)
+	defer impl.pool.Stop()
+	assert.NotNil(t, impl.listenerTLS)
+	assert.NotNil(t, impl.messageQueueTLS.RootCAs)
+
+	certFile, keyFile = pki.Issue("server2", "movie-service-2", x509.ExtKeyUsageServerAuth)
+	ctx.TLS = &TLSOptions{CertFile: certFile, KeyFile: keyFile}
+	assert.NoError(t, server.Reload(ctx))
+	assert.Equal(t, certFile, impl.listenerTLS.options.CertFile)
+
+	// broken AMQP CA keeps the current settings
+	ctx.MessageQueueTLS = &MessageQueueTLSOptions{CAFile: "not-existing.crt"}
+	ctx.Workers = 3
+	assert.Error(t, server.Reload(ctx))
+	assert.Equal(t, totalWorkers, impl.pool.Size())
+}
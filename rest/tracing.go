@@ -0,0 +1,55 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/plar/movie-service/tracing"
+)
+
+const (
+	spanWorkerQueue = "workerqueue.wait"
+	spanUpstream    = "upstream.search"
+	spanPublish     = "amqp.publish"
+
+	attrRequestId  = attribute.Key("movie.request_id")
+	attrQuery      = attribute.Key("movie.query")
+	attrClient     = attribute.Key("movie.client")
+	attrWorkerId   = attribute.Key("movie.worker_id")
+	attrProvider   = attribute.Key("movie.provider")
+	attrResults    = attribute.Key("movie.results")
+	attrStale      = attribute.Key("movie.stale")
+	attrRewritten  = attribute.Key("movie.rewritten_query")
+	attrMovieId    = attribute.Key("movie.id")
+	attrImdbId     = attribute.Key("movie.imdb_id")
+	attrBatchSize  = attribute.Key("movie.batch_size")
+	attrBatchIndex = attribute.Key("movie.batch_index")
+	attrExchange   = attribute.Key("messaging.destination.name")
+	attrRoutingKey = attribute.Key("messaging.rabbitmq.destination.routing_key")
+)
+
+// traced starts the server span of the request, it continues the trace of the incoming headers.
+func traced(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeName(r)
+		ctx, span := tracing.Tracer().Start(tracing.ExtractHTTP(r.Context(), r.Header), r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", r.Method),
+				attribute.String("http.route", route),
+			))
+		defer span.End()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.status_code", recorder.status))
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, fmt.Sprintf("HTTP %d", recorder.status))
+		}
+	})
+}
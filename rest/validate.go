@@ -0,0 +1,145 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	ClientIdHeader = "X-Client-Id"
+
+	anyClient              = "*"
+	maxShortStrLength      = 255
+	reservedExchangePrefix = "amq."
+)
+
+var exchangeNameRe = regexp.MustCompile(`^[a-zA-Z0-9_.:-]+$`)
+
+// ExchangeProber checks that exchange exists on the broker.
+type ExchangeProber interface {
+	ProbeExchange(name, kind string) error
+}
+
+type ValidationOptions struct {
+	// Allowed exchanges per API client, "*" key is used for clients without own list.
+	// Exchange "prefix.*" allows all exchanges starting with "prefix.".
+	AllowedExchanges map[string][]string
+
+	// Allowed routing keys per API client, same rules as for exchanges.
+	AllowedRoutingKeys map[string][]string
+
+	// Passive declare exchange before accepting the request.
+	ProbeExchange bool
+}
+
+type RequestValidator interface {
+	Validate(client string, req *Request) *APIError
+}
+
+type requestValidator struct {
+	options        ValidationOptions
+	publishOptions PublishOptions
+	prober         ExchangeProber
+}
+
+func (self *requestValidator) Validate(client string, req *Request) *APIError {
+	opts, err := self.publishOptions.Resolve(req)
+	if err != nil {
+		return NewAPIError(http.StatusUnprocessableEntity, CodeInvalidPublish, "Invalid publish options",
+			FieldError{"publish_options", err.Error()})
+	}
+
+	var fields []FieldError
+	if msg := validateExchangeName(req.ExchangeName, opts); len(msg) > 0 {
+		fields = append(fields, FieldError{"exchange_name", msg})
+	}
+	if msg := validateRoutingKey(req.RoutingKey, opts); len(msg) > 0 {
+		fields = append(fields, FieldError{"routing_key", msg})
+	}
+	if len(fields) > 0 {
+		return NewAPIError(http.StatusUnprocessableEntity, CodeInvalidRequest, "Invalid request", fields...)
+	}
+
+	if !allowed(self.options.AllowedExchanges, client, req.ExchangeName) {
+		return NewAPIError(http.StatusForbidden, CodeExchangeNotAllowed, "Exchange is not allowed",
+			FieldError{"exchange_name", fmt.Sprintf("exchange '%s' is not allowed for the client", req.ExchangeName)})
+	}
+
+	if !allowed(self.options.AllowedRoutingKeys, client, req.RoutingKey) {
+		return NewAPIError(http.StatusForbidden, CodeRoutingKeyNotAllowed, "Routing key is not allowed",
+			FieldError{"routing_key", fmt.Sprintf("routing key '%s' is not allowed for the client", req.RoutingKey)})
+	}
+
+	if self.options.ProbeExchange && self.prober != nil && opts.ExchangeDeclare != ExchangeDeclareActive {
+		if err := self.prober.ProbeExchange(req.ExchangeName, opts.ExchangeType); err != nil {
+			return NewAPIError(http.StatusUnprocessableEntity, CodeExchangeNotAvailable, "Exchange is not available",
+				FieldError{"exchange_name", err.Error()})
+		}
+	}
+
+	return nil
+}
+
+// allowed checks name against patterns of the client, empty patterns allow everything.
+func allowed(patterns map[string][]string, client, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	clientPatterns, exists := patterns[client]
+	if !exists {
+		clientPatterns, exists = patterns[anyClient]
+	}
+	if !exists {
+		return false
+	}
+
+	for _, pattern := range clientPatterns {
+		if matchName(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchName matches exact name or "prefix*" pattern.
+func matchName(pattern, name string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(name, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == name
+}
+
+func validateExchangeName(name string, opts PublishOptions) string {
+	switch {
+	case len(name) == 0:
+		return "cannot be empty"
+	case len(name) > maxShortStrLength:
+		return fmt.Sprintf("cannot be longer than %d bytes", maxShortStrLength)
+	case !exchangeNameRe.MatchString(name):
+		return "may contain only letters, digits, '-', '_', '.' and ':'"
+	case opts.ExchangeDeclare == ExchangeDeclareActive && strings.HasPrefix(name, reservedExchangePrefix):
+		return fmt.Sprintf("'%s' prefix is reserved by the broker and cannot be declared", reservedExchangePrefix)
+	}
+	return ""
+}
+
+func validateRoutingKey(key string, opts PublishOptions) string {
+	switch {
+	case len(key) == 0 && opts.ExchangeType != amqp.ExchangeFanout && opts.ExchangeType != amqp.ExchangeHeaders:
+		return "cannot be empty"
+	case len(key) > maxShortStrLength:
+		return fmt.Sprintf("cannot be longer than %d bytes", maxShortStrLength)
+	case opts.ExchangeType == amqp.ExchangeTopic && strings.ContainsAny(key, "*#"):
+		return "wildcards '*' and '#' cannot be used to publish to topic exchange"
+	}
+	return ""
+}
+
+func NewRequestValidator(options ValidationOptions, publishOptions PublishOptions, prober ExchangeProber) RequestValidator {
+	return &requestValidator{options, publishOptions, prober}
+}
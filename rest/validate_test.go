@@ -0,0 +1,135 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testExchangeProber struct {
+	simulateError error
+
+	name string
+	kind string
+}
+
+func (self *testExchangeProber) ProbeExchange(name, kind string) error {
+	self.name, self.kind = name, kind
+	return self.simulateError
+}
+
+func TestValidateRequest(t *testing.T) {
+	validator := NewRequestValidator(ValidationOptions{}, DefaultPublishOptions(), nil)
+
+	verr := validator.Validate("", &Request{ExchangeName: "movies.results", RoutingKey: "search.martian"})
+	assert.Nil(t, verr)
+}
+
+func TestValidateRequestEmptyExchangeAndRoutingKey(t *testing.T) {
+	validator := NewRequestValidator(ValidationOptions{}, DefaultPublishOptions(), nil)
+
+	verr := validator.Validate("", &Request{})
+	assert.NotNil(t, verr)
+	assert.Equal(t, http.StatusUnprocessableEntity, verr.Status)
+	assert.Equal(t, CodeInvalidRequest, verr.Code)
+	assert.Equal(t, "Invalid request", verr.Error())
+	assert.Equal(t, []FieldError{
+		{"exchange_name", "cannot be empty"},
+		{"routing_key", "cannot be empty"},
+	}, verr.Fields)
+}
+
+func TestValidateRequestSyntax(t *testing.T) {
+	validator := NewRequestValidator(ValidationOptions{}, DefaultPublishOptions(), nil)
+
+	verr := validator.Validate("", &Request{ExchangeName: "movies results", RoutingKey: "search.*"})
+	assert.NotNil(t, verr)
+	assert.Equal(t, []FieldError{
+		{"exchange_name", "may contain only letters, digits, '-', '_', '.' and ':'"},
+		{"routing_key", "wildcards '*' and '#' cannot be used to publish to topic exchange"},
+	}, verr.Fields)
+
+	verr = validator.Validate("", &Request{ExchangeName: strings.Repeat("x", 256), RoutingKey: strings.Repeat("x", 256)})
+	assert.NotNil(t, verr)
+	assert.Equal(t, []FieldError{
+		{"exchange_name", "cannot be longer than 255 bytes"},
+		{"routing_key", "cannot be longer than 255 bytes"},
+	}, verr.Fields)
+
+	verr = validator.Validate("", &Request{ExchangeName: "amq.topic", RoutingKey: "search"})
+	assert.NotNil(t, verr)
+	assert.Equal(t, []FieldError{{"exchange_name", "'amq.' prefix is reserved by the broker and cannot be declared"}}, verr.Fields)
+
+	// predeclared exchanges can be used without declaration
+	verr = validator.Validate("", &Request{ExchangeName: "amq.topic", RoutingKey: "search", ExchangeDeclare: ExchangeDeclareNone})
+	assert.Nil(t, verr)
+
+	// routing key is ignored by the fanout exchange
+	verr = validator.Validate("", &Request{ExchangeName: "movies", ExchangeType: "fanout"})
+	assert.Nil(t, verr)
+}
+
+func TestValidateRequestPublishOptions(t *testing.T) {
+	validator := NewRequestValidator(ValidationOptions{}, DefaultPublishOptions(), nil)
+
+	verr := validator.Validate("", &Request{ExchangeName: "movies", RoutingKey: "search", DeliveryMode: "forever"})
+	assert.NotNil(t, verr)
+	assert.Equal(t, http.StatusUnprocessableEntity, verr.Status)
+	assert.Equal(t, CodeInvalidPublish, verr.Code)
+	assert.Equal(t, []FieldError{{"publish_options", "Unknown delivery mode 'forever'"}}, verr.Fields)
+}
+
+func TestValidateRequestAllowedExchanges(t *testing.T) {
+	options := ValidationOptions{AllowedExchanges: map[string][]string{
+		"billing": {"billing.results"},
+		"*":       {"movies.*"},
+	}}
+	validator := NewRequestValidator(options, DefaultPublishOptions(), nil)
+
+	assert.Nil(t, validator.Validate("billing", &Request{ExchangeName: "billing.results", RoutingKey: "search"}))
+	assert.Nil(t, validator.Validate("", &Request{ExchangeName: "movies.results", RoutingKey: "search"}))
+	assert.Nil(t, validator.Validate("catalog", &Request{ExchangeName: "movies.import", RoutingKey: "search"}))
+
+	verr := validator.Validate("billing", &Request{ExchangeName: "movies.results", RoutingKey: "search"})
+	assert.NotNil(t, verr)
+	assert.Equal(t, http.StatusForbidden, verr.Status)
+	assert.Equal(t, CodeExchangeNotAllowed, verr.Code)
+	assert.Equal(t, []FieldError{{"exchange_name", "exchange 'movies.results' is not allowed for the client"}}, verr.Fields)
+
+	verr = validator.Validate("", &Request{ExchangeName: "billing.results", RoutingKey: "search"})
+	assert.NotNil(t, verr)
+	assert.Equal(t, http.StatusForbidden, verr.Status)
+
+	// no default list, unknown clients are rejected
+	options = ValidationOptions{AllowedExchanges: map[string][]string{"billing": {"billing.results"}}}
+	validator = NewRequestValidator(options, DefaultPublishOptions(), nil)
+	assert.NotNil(t, validator.Validate("catalog", &Request{ExchangeName: "billing.results", RoutingKey: "search"}))
+}
+
+func TestValidateRequestProbeExchange(t *testing.T) {
+	publishOptions := DefaultPublishOptions()
+	publishOptions.ExchangeDeclare = ExchangeDeclarePassive
+
+	prober := &testExchangeProber{}
+	validator := NewRequestValidator(ValidationOptions{ProbeExchange: true}, publishOptions, prober)
+	assert.Nil(t, validator.Validate("", &Request{ExchangeName: "movies", RoutingKey: "search"}))
+	assert.Equal(t, "movies", prober.name)
+	assert.Equal(t, "topic", prober.kind)
+
+	prober = &testExchangeProber{simulateError: errors.New("NOT_FOUND - no exchange 'movies'")}
+	validator = NewRequestValidator(ValidationOptions{ProbeExchange: true}, publishOptions, prober)
+	verr := validator.Validate("", &Request{ExchangeName: "movies", RoutingKey: "search"})
+	assert.NotNil(t, verr)
+	assert.Equal(t, http.StatusUnprocessableEntity, verr.Status)
+	assert.Equal(t, CodeExchangeNotAvailable, verr.Code)
+	assert.Equal(t, []FieldError{{"exchange_name", "NOT_FOUND - no exchange 'movies'"}}, verr.Fields)
+
+	// exchange will be declared by the worker, nothing to probe
+	prober = &testExchangeProber{simulateError: errors.New("must not be called")}
+	validator = NewRequestValidator(ValidationOptions{ProbeExchange: true}, DefaultPublishOptions(), prober)
+	assert.Nil(t, validator.Validate("", &Request{ExchangeName: "movies", RoutingKey: "search"}))
+	assert.Equal(t, "", prober.name)
+}
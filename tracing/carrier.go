@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// propagator reads and writes traceparent, tracestate and baggage headers
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// amqpCarrier adapts AMQP message headers to the propagator.
+type amqpCarrier amqp.Table
+
+func (self amqpCarrier) Get(key string) string {
+	value, _ := self[key].(string)
+	return value
+}
+
+func (self amqpCarrier) Set(key, value string) {
+	self[key] = value
+}
+
+func (self amqpCarrier) Keys() []string {
+	keys := make([]string, 0, len(self))
+	for key := range self {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// ExtractHTTP returns ctx with the remote span of the incoming request headers.
+func ExtractHTTP(ctx context.Context, header http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// InjectAMQP adds trace context of ctx to the message headers, nil headers are allocated.
+func InjectAMQP(ctx context.Context, headers amqp.Table) amqp.Table {
+	if headers == nil {
+		headers = amqp.Table{}
+	}
+	propagator.Inject(ctx, amqpCarrier(headers))
+	return headers
+}
+
+// ExtractAMQP returns ctx with the remote span of the message headers.
+func ExtractAMQP(ctx context.Context, headers amqp.Table) context.Context {
+	return propagator.Extract(ctx, amqpCarrier(headers))
+}
+
+// InjectMap returns trace context of ctx as a map, e.g. to store it with the job, nil when there is none.
+func InjectMap(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// ExtractMap returns ctx with the remote span stored by InjectMap.
+func ExtractMap(ctx context.Context, carrier map[string]string) context.Context {
+	return propagator.Extract(ctx, propagation.MapCarrier(carrier))
+}
+
+// End ends the span, err marks it failed.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,76 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const testTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func remoteContext() context.Context {
+	header := http.Header{}
+	header.Set("traceparent", testTraceparent)
+	return ExtractHTTP(context.Background(), header)
+}
+
+func TestExtractHTTP(t *testing.T) {
+	sc := trace.SpanContextFromContext(remoteContext())
+	assert.True(t, sc.IsRemote())
+	assert.True(t, sc.IsSampled())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID().String())
+
+	assert.False(t, trace.SpanContextFromContext(ExtractHTTP(context.Background(), http.Header{})).IsValid())
+}
+
+func TestInjectAMQP(t *testing.T) {
+	headers := InjectAMQP(remoteContext(), amqp.Table{"request_id": "r1"})
+	assert.Equal(t, amqp.Table{"request_id": "r1", "traceparent": testTraceparent}, headers)
+
+	headers = InjectAMQP(remoteContext(), nil)
+	assert.Equal(t, testTraceparent, headers["traceparent"])
+
+	sc := trace.SpanContextFromContext(ExtractAMQP(context.Background(), headers))
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID().String())
+
+	// non-string header values are ignored
+	sc = trace.SpanContextFromContext(ExtractAMQP(context.Background(), amqp.Table{"traceparent": int64(1)}))
+	assert.False(t, sc.IsValid())
+}
+
+func TestInjectMap(t *testing.T) {
+	carrier := InjectMap(remoteContext())
+	assert.Equal(t, map[string]string{"traceparent": testTraceparent}, carrier)
+	assert.Nil(t, InjectMap(context.Background()))
+
+	sc := trace.SpanContextFromContext(ExtractMap(context.Background(), carrier))
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID().String())
+	assert.False(t, trace.SpanContextFromContext(ExtractMap(context.Background(), nil)).IsValid())
+}
+
+func TestEnd(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer(TracerName)
+
+	_, span := tracer.Start(context.Background(), "success")
+	End(span, nil)
+	_, span = tracer.Start(context.Background(), "failure")
+	End(span, errors.New("channel/connection is not open"))
+
+	spans := recorder.Ended()
+	if !assert.Equal(t, 2, len(spans)) {
+		return
+	}
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+	assert.Equal(t, sdktrace.Status{Code: codes.Error, Description: "channel/connection is not open"}, spans[1].Status())
+	assert.Equal(t, 1, len(spans[1].Events()))
+}
@@ -0,0 +1,121 @@
+// Package tracing exports OpenTelemetry spans of the service and propagates W3C trace context
+// through HTTP headers, AMQP message headers and the job journal.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ExporterNone   = "none"   // spans are not recorded, incoming trace context is still propagated
+	ExporterStdout = "stdout" // one JSON object per span
+	ExporterOTLP   = "otlp"   // OTLP over HTTP, e.g. to the OpenTelemetry collector
+
+	// TracerName is the instrumentation scope of the service spans
+	TracerName = "github.com/plar/movie-service"
+)
+
+type Options struct {
+	Exporter string
+
+	// OTLP collector host:port, e.g. localhost:4318
+	Endpoint string
+
+	// OTLP over plain HTTP
+	Insecure bool
+
+	ServiceName string
+
+	// Fraction of new traces to sample, sampled parents are always followed
+	SampleRatio float64
+}
+
+func (self Options) Validate() error {
+	switch self.Exporter {
+	case ExporterNone:
+		return nil
+	case ExporterStdout:
+	case ExporterOTLP:
+		if len(self.Endpoint) == 0 {
+			return fmt.Errorf("OTLP endpoint cannot be empty")
+		}
+	default:
+		return fmt.Errorf("Unknown trace exporter '%s'", self.Exporter)
+	}
+
+	if len(self.ServiceName) == 0 {
+		return fmt.Errorf("Service name cannot be empty")
+	}
+	if self.SampleRatio < 0 || self.SampleRatio > 1 {
+		return fmt.Errorf("Sample ratio must be between 0 and 1, got %g", self.SampleRatio)
+	}
+	return nil
+}
+
+// Provider flushes spans which are not exported yet.
+type Provider interface {
+	Shutdown(ctx context.Context) error
+}
+
+type nopProvider struct {
+}
+
+func (self nopProvider) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// stdout exporter output, replaced by tests
+var stdout io.Writer = os.Stdout
+
+// Open installs the global tracer provider of the options.
+func Open(options Options) (Provider, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	otel.SetTextMapPropagator(propagator)
+	if options.Exporter == ExporterNone {
+		return nopProvider{}, nil
+	}
+
+	exporter, err := newExporter(options)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(options.ServiceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(options.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider, nil
+}
+
+func newExporter(options Options) (sdktrace.SpanExporter, error) {
+	if options.Exporter == ExporterStdout {
+		return stdouttrace.New(stdouttrace.WithWriter(stdout))
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(options.Endpoint)}
+	if options.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	return otlptracehttp.New(context.Background(), opts...)
+}
+
+// Tracer returns tracer of the global provider, spans are dropped until Open installs one.
+func Tracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
@@ -0,0 +1,76 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	valid := Options{Exporter: ExporterOTLP, Endpoint: "localhost:4318", ServiceName: "movie-service", SampleRatio: 1}
+	assert.NoError(t, valid.Validate())
+	assert.NoError(t, Options{Exporter: ExporterNone}.Validate())
+
+	for _, tc := range []struct {
+		options Options
+		err     string
+	}{
+		{Options{}, "Unknown trace exporter ''"},
+		{Options{Exporter: "jaeger"}, "Unknown trace exporter 'jaeger'"},
+		{Options{Exporter: ExporterOTLP, ServiceName: "movie-service"}, "OTLP endpoint cannot be empty"},
+		{Options{Exporter: ExporterStdout}, "Service name cannot be empty"},
+		{Options{Exporter: ExporterStdout, ServiceName: "movie-service", SampleRatio: -0.5}, "Sample ratio must be between 0 and 1, got -0.5"},
+	} {
+		assert.EqualError(t, tc.options.Validate(), tc.err)
+	}
+}
+
+func TestOpenNone(t *testing.T) {
+	provider, err := Open(Options{Exporter: ExporterNone})
+	assert.NoError(t, err)
+	assert.Equal(t, nopProvider{}, provider)
+	assert.NoError(t, provider.Shutdown(context.Background()))
+
+	_, err = Open(Options{Exporter: "jaeger"})
+	assert.EqualError(t, err, "Unknown trace exporter 'jaeger'")
+}
+
+func TestOpenStdout(t *testing.T) {
+	var out bytes.Buffer
+	stdout = &out
+	previous := otel.GetTracerProvider()
+	defer func() {
+		stdout = os.Stdout
+		otel.SetTracerProvider(previous)
+	}()
+
+	provider, err := Open(Options{Exporter: ExporterStdout, ServiceName: "movie-service", SampleRatio: 1})
+	assert.NoError(t, err)
+
+	_, span := Tracer().Start(remoteContext(), "amqp.publish")
+	span.End()
+	assert.NoError(t, provider.Shutdown(context.Background()))
+
+	var exported struct {
+		Name        string
+		SpanContext struct{ TraceID string }
+		Resource    []struct {
+			Key   string
+			Value struct{ Value interface{} }
+		}
+	}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &exported))
+	assert.Equal(t, "amqp.publish", exported.Name)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", exported.SpanContext.TraceID)
+
+	resource := map[string]interface{}{}
+	for _, attr := range exported.Resource {
+		resource[attr.Key] = attr.Value.Value
+	}
+	assert.Equal(t, "movie-service", resource["service.name"])
+}
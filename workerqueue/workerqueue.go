@@ -2,6 +2,9 @@ package workerqueue
 
 import (
 	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -15,6 +18,7 @@ type Inbound chan Work
 type WorkerQueue chan Inbound
 
 type Worker struct {
+	busySince   int64 // unix nano time when the current job started, 0 - idle
 	id          int
 	inbound     Inbound
 	workerQueue WorkerQueue
@@ -44,7 +48,9 @@ func (w *Worker) Start() {
 			w.workerQueue <- w.inbound
 			select {
 			case work := <-w.inbound:
+				atomic.StoreInt64(&w.busySince, time.Now().UnixNano())
 				work(w.id)
+				atomic.StoreInt64(&w.busySince, 0)
 			case <-w.quit:
 				w.done <- true
 				return
@@ -60,11 +66,136 @@ func (w *Worker) Stop() {
 	}()
 }
 
+// Returns how long the worker is busy with the current job, 0 if the worker is idle.
+func (w *Worker) BusyFor() time.Duration {
+	since := atomic.LoadInt64(&w.busySince)
+	if since == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, since))
+}
+
 // Waits for a worker to finish before returning.
 func (w *Worker) WaitForFinish() {
 	<-w.done
 }
 
+// Pool keeps a resizable set of workers serving the same workerQueue.
+type Pool struct {
+	sync.Mutex
+	resizeLock  sync.Mutex // serializes Resize and Stop, the pool lock is not held while they wait
+	workerQueue WorkerQueue
+	workers     []*Worker
+	nextId      int
+	stopped     bool // guarded by resizeLock, the stopped pool is never resized
+}
+
+var ErrPoolStopped = errors.New("pool is stopped")
+
+func NewPool(workerQueue WorkerQueue, size int) (*Pool, error) {
+	if workerQueue == nil {
+		return nil, errors.New("workerQueue cannot be nil")
+	}
+
+	pool := &Pool{workerQueue: workerQueue}
+	if err := pool.Resize(size); err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+// Starts new workers or stops idle ones until the pool has the given size.
+// Shrinking waits until enough workers finish their current jobs, Size and Workers are not blocked meanwhile.
+func (p *Pool) Resize(size int) error {
+	if size <= 0 {
+		return errors.New("pool size must be positive")
+	}
+
+	p.resizeLock.Lock()
+	defer p.resizeLock.Unlock()
+
+	if p.stopped {
+		return ErrPoolStopped
+	}
+	if err := p.grow(size); err != nil {
+		return err
+	}
+
+	for p.Size() > size {
+		// take an idle worker out of the queue, so nobody can send a job to it
+		inbound := <-p.workerQueue
+		if worker := p.remove(inbound); worker != nil {
+			worker.Stop()
+			worker.WaitForFinish()
+		}
+	}
+
+	return nil
+}
+
+func (p *Pool) grow(size int) error {
+	p.Lock()
+	defer p.Unlock()
+
+	for len(p.workers) < size {
+		worker, err := NewWorker(p.nextId, p.workerQueue)
+		if err != nil {
+			return err
+		}
+		p.nextId++
+		p.workers = append(p.workers, worker)
+		worker.Start()
+	}
+	return nil
+}
+
+// remove takes the worker of the inbound out of the pool.
+func (p *Pool) remove(inbound Inbound) *Worker {
+	p.Lock()
+	defer p.Unlock()
+
+	for i, worker := range p.workers {
+		if worker.inbound == inbound {
+			p.workers = append(p.workers[:i], p.workers[i+1:]...)
+			return worker
+		}
+	}
+	return nil
+}
+
+func (p *Pool) Size() int {
+	p.Lock()
+	defer p.Unlock()
+	return len(p.workers)
+}
+
+// Returns a snapshot of the pool workers.
+func (p *Pool) Workers() []*Worker {
+	p.Lock()
+	defer p.Unlock()
+	return append([]*Worker(nil), p.workers...)
+}
+
+// Tells all workers to stop and waits for them to finish their current jobs.
+// The stopped pool cannot be resized anymore.
+func (p *Pool) Stop() {
+	p.resizeLock.Lock()
+	defer p.resizeLock.Unlock()
+	p.stopped = true
+
+	p.Lock()
+	workers := p.workers
+	p.workers = nil
+	p.Unlock()
+
+	for i := range workers {
+		workers[i].Stop()
+	}
+	for i := range workers {
+		workers[i].WaitForFinish()
+	}
+}
+
 // func NewJob(i int) Work {
 // 	return func(id int) {
 // 		time.Sleep(time.Duration(rand.Intn(3)) * time.Second)
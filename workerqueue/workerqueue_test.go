@@ -83,3 +83,110 @@ FINISH:
 		}
 	}
 }
+
+func TestWorkerBusyFor(t *testing.T) {
+	wq := make(WorkerQueue, 1)
+	worker, _ := NewWorker(1, wq)
+	assert.Equal(t, time.Duration(0), worker.BusyFor())
+
+	worker.Start()
+	w := <-wq
+
+	started := make(chan bool)
+	release := make(chan bool)
+	w <- func(id int) {
+		started <- true
+		<-release
+	}
+
+	<-started
+	time.Sleep(10 * time.Millisecond)
+	assert.True(t, worker.BusyFor() >= 10*time.Millisecond)
+	release <- true
+
+	// worker is ready for the next job
+	<-wq
+	assert.Equal(t, time.Duration(0), worker.BusyFor())
+
+	worker.Stop()
+	worker.WaitForFinish()
+}
+
+func TestNewPoolWithoutWorkerQueue(t *testing.T) {
+	_, err := NewPool(nil, 1)
+	assert.EqualError(t, err, "workerQueue cannot be nil")
+
+	_, err = NewPool(make(WorkerQueue, 1), 0)
+	assert.EqualError(t, err, "pool size must be positive")
+}
+
+func TestPoolResize(t *testing.T) {
+	wq := make(WorkerQueue, 4)
+	pool, err := NewPool(wq, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, pool.Size())
+
+	assert.NoError(t, pool.Resize(4))
+	assert.Equal(t, 4, pool.Size())
+
+	ids := map[int]bool{}
+	for _, worker := range pool.Workers() {
+		ids[worker.id] = true
+	}
+	assert.Equal(t, map[int]bool{0: true, 1: true, 2: true, 3: true}, ids)
+
+	assert.NoError(t, pool.Resize(1))
+	assert.Equal(t, 1, pool.Size())
+
+	// the remaining worker still gets jobs
+	out := make(chan int)
+	w := <-wq
+	w <- func(id int) {
+		out <- id
+	}
+	assert.Equal(t, pool.Workers()[0].id, <-out)
+
+	pool.Stop()
+	assert.Equal(t, 0, pool.Size())
+
+	// the stopped pool does not start workers again
+	assert.Equal(t, ErrPoolStopped, pool.Resize(2))
+	assert.Equal(t, 0, pool.Size())
+}
+
+func TestPoolResizeWaitsWithoutLock(t *testing.T) {
+	wq := make(WorkerQueue, 2)
+	pool, err := NewPool(wq, 2)
+	assert.NoError(t, err)
+
+	// both workers are busy, shrinking has to wait for one of them
+	release := make(chan bool)
+	for i := 0; i < 2; i++ {
+		w := <-wq
+		w <- func(id int) {
+			<-release
+		}
+	}
+
+	resized := make(chan error)
+	go func() {
+		resized <- pool.Resize(1)
+	}()
+
+	// the pool can be inspected while the busy workers are waited for
+	inspected := make(chan int)
+	go func() {
+		inspected <- len(pool.Workers()) + pool.Size()
+	}()
+	select {
+	case n := <-inspected:
+		assert.Equal(t, 4, n)
+	case <-time.After(time.Second):
+		t.Fatal("Size is blocked by Resize")
+	}
+
+	close(release)
+	assert.NoError(t, <-resized)
+	assert.Equal(t, 1, pool.Size())
+	pool.Stop()
+}